@@ -0,0 +1,130 @@
+// Package broker carries the events of the bus between the instances of
+// the API over a notification channel, such as Postgres LISTEN/NOTIFY.
+package broker
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/cad/vehicle-tracker-api/event"
+)
+
+// MAX_PAYLOAD is the size of the largest event sent, that of the
+// payloads of Postgres notifications. Larger events are sent without
+// their payload when they're logged, for receivers to load it from the
+// event log.
+const MAX_PAYLOAD = 8000
+
+// Notifier sends payloads to every listener of a channel, including
+// those of the sender.
+type Notifier interface {
+	Notify(payload string) error
+
+	// Listen returns the payloads notified from now on. It's closed on
+	// Close.
+	Listen() (<-chan string, error)
+
+	Close() error
+}
+
+// NotifyBroker is an event.Broker sending the events over a Notifier.
+type NotifyBroker struct {
+	notifier Notifier
+}
+
+func New(notifier Notifier) *NotifyBroker {
+	return &NotifyBroker{notifier: notifier}
+}
+
+func (b *NotifyBroker) Publish(e *event.Event) error {
+	payload, err := event.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if len(payload) > MAX_PAYLOAD && e.LogID != 0 {
+		payload, err = event.MarshalReference(e)
+		if err != nil {
+			return err
+		}
+	}
+	if len(payload) > MAX_PAYLOAD {
+		return fmt.Errorf("event of %d bytes, larger than %d", len(payload), MAX_PAYLOAD)
+	}
+	return b.notifier.Notify(string(payload))
+}
+
+func (b *NotifyBroker) Subscribe(receive func(e *event.Event)) error {
+	payloads, err := b.notifier.Listen()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for payload := range payloads {
+			e, err := event.Unmarshal([]byte(payload))
+			if err != nil {
+				log.Println("[Broker] Can't decode the event. Ignoring.", err)
+				continue
+			}
+			receive(e)
+		}
+	}()
+	return nil
+}
+
+func (b *NotifyBroker) Close() error {
+	return b.notifier.Close()
+}
+
+// LocalNotifier is an in-memory Notifier, standing in for a networked one
+// within a process. Payloads are dropped for the listeners whose buffer
+// is full.
+type LocalNotifier struct {
+	lock      sync.Mutex
+	listeners []chan string
+	closed    bool
+}
+
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{}
+}
+
+func (n *LocalNotifier) Notify(payload string) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return fmt.Errorf("notifier closed")
+	}
+	for _, listener := range n.listeners {
+		select {
+		case listener <- payload:
+		default:
+		}
+	}
+	return nil
+}
+
+func (n *LocalNotifier) Listen() (<-chan string, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return nil, fmt.Errorf("notifier closed")
+	}
+	listener := make(chan string, event.DEFAULT_BUFFER)
+	n.listeners = append(n.listeners, listener)
+	return listener, nil
+}
+
+func (n *LocalNotifier) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+	for _, listener := range n.listeners {
+		close(listener)
+	}
+	n.listeners = nil
+	return nil
+}
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresNotifier is a Notifier over Postgres LISTEN/NOTIFY.
+type PostgresNotifier struct {
+	url     string
+	channel string
+	db      *sql.DB
+
+	lock      sync.Mutex
+	listeners []*pq.Listener
+}
+
+// NewPostgres returns a broker sending the events over the channel of
+// the Postgres database at url.
+func NewPostgres(url string, channel string) (*NotifyBroker, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return New(&PostgresNotifier{url: url, channel: channel, db: db}), nil
+}
+
+func (n *PostgresNotifier) Notify(payload string) error {
+	_, err := n.db.Exec("SELECT pg_notify($1, $2)", n.channel, payload)
+	return err
+}
+
+func (n *PostgresNotifier) Listen() (<-chan string, error) {
+	listener := pq.NewListener(n.url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("[Broker] Postgres listener:", err)
+		}
+	})
+	if err := listener.Listen(n.channel); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	n.lock.Lock()
+	n.listeners = append(n.listeners, listener)
+	n.lock.Unlock()
+
+	payloads := make(chan string)
+	go func() {
+		defer close(payloads)
+		for notification := range listener.Notify {
+			if notification == nil {
+				// Sent on reconnection.
+				log.Println("[Broker] Reconnected to Postgres, events of other instances may have been missed.")
+				continue
+			}
+			payloads <- notification.Extra
+		}
+	}()
+	return payloads, nil
+}
+
+func (n *PostgresNotifier) Close() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for _, listener := range n.listeners {
+		listener.Close()
+	}
+	n.listeners = nil
+	return n.db.Close()
+}
@@ -9,8 +9,17 @@ import (
 const VERSION = "1.1.10"
 
 type Configuration struct {
-	DB     DBParams     `json:"db`
-	Server ServerParams `json:"server"`
+	DB        DBParams        `json:"db"`
+	Server    ServerParams    `json:"server"`
+	Speeding  SpeedingParams  `json:"speeding"`
+	Idle      IdleParams      `json:"idle"`
+	Geocoder  GeocoderParams  `json:"geocoder"`
+	WebSocket WebSocketParams `json:"websocket"`
+	SSE       SSEParams       `json:"sse"`
+	Webhook   WebhookParams   `json:"webhook"`
+	Broker    BrokerParams    `json:"broker"`
+	GRPC      GRPCParams      `json:"grpc"`
+	Session   SessionParams   `json:"session"`
 }
 
 type DBParams struct {
@@ -18,11 +27,174 @@ type DBParams struct {
 	URL  string `json:"url"`
 }
 
+// ServerParams configures the API server. Every route needs a user token
+// whose role permits it, the reads included, unless AnonymousRead opts in
+// to reads without token: the vehicles, groups, agents, geofences, tracks
+// and tiles, and the streams of vehicles over REST, WebSocket, SSE and
+// gRPC, as before users had roles.
 type ServerParams struct {
-	Port string `json:"port"`
+	Port          string `json:"port"`
+	AnonymousRead bool   `json:"anonymous_read"`
 }
 
-var C Configuration
+// SpeedingParams configures overspeed detection. Limits are in km/h;
+// a vehicle type without a limit is only checked against geofences.
+type SpeedingParams struct {
+	TypeLimits map[string]float64 `json:"type_limits"`
+}
+
+// IdleParams configures idle detection. A vehicle moving slower than
+// Speed (km/h) for longer than Threshold (seconds) is idling. With
+// OutsideDepotsOnly set, time spent inside DEPOT geofences isn't idling.
+type IdleParams struct {
+	Threshold         float64 `json:"threshold"`
+	Speed             float64 `json:"speed"`
+	OutsideDepotsOnly bool    `json:"outside_depots_only"`
+}
+
+// GeocoderParams configures reverse geocoding. Gazetteer is a CSV file
+// of `name,lat,lon` records; geocoding is disabled when it's empty.
+// MaxDistance is in meters.
+type GeocoderParams struct {
+	Gazetteer   string  `json:"gazetteer"`
+	MaxDistance float64 `json:"max_distance"`
+	CacheSize   int     `json:"cache_size"`
+}
+
+// Policies for WebSocket clients that don't keep up with their messages.
+const (
+	SLOW_CONSUMER_DROP_OLDEST = "drop_oldest"
+	SLOW_CONSUMER_COALESCE    = "coalesce"
+	SLOW_CONSUMER_DISCONNECT  = "disconnect"
+)
+
+// WebSocketParams configures WebSocket connections. Browsers connect from
+// the origin of the API, or from one of AllowedOrigins, "*" allowing any
+// origin. Clients have to authenticate with a user token, within
+// AuthTimeout seconds when they send it as their first message, unless
+// the server allows anonymous reads.
+//
+// Each connection queues up to QueueSize messages. When it's full, the
+// SlowConsumer policy drops the oldest update, coalesces the updates of a
+// vehicle or disconnects the client. Replies and removals are never
+// dropped, and coalescing keeps the last update of every vehicle, so the
+// client is disconnected when those don't fit: with coalesce, QueueSize
+// should exceed the number of vehicles a client subscribes to.
+//
+// Clients are pinged every PingInterval seconds and disconnected when they
+// don't answer within PongTimeout or a write takes longer than
+// WriteTimeout.
+type WebSocketParams struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AuthTimeout    float64  `json:"auth_timeout"`
+	QueueSize      int      `json:"queue_size"`
+	SlowConsumer   string   `json:"slow_consumer"`
+	PingInterval   float64  `json:"ping_interval"`
+	PongTimeout    float64  `json:"pong_timeout"`
+	WriteTimeout   float64  `json:"write_timeout"`
+}
+
+// SSEParams configures Server-Sent Events streams. The last ReplayBuffer
+// vehicle updates are kept for clients resuming with Last-Event-ID, and
+// a comment is sent every KeepAlive seconds so that proxies don't close
+// idle streams.
+type SSEParams struct {
+	ReplayBuffer int     `json:"replay_buffer"`
+	KeepAlive    float64 `json:"keep_alive"`
+}
+
+// WebhookParams configures webhook deliveries. Due deliveries are looked
+// for every PollInterval seconds and posted by up to Workers at a time,
+// each given Timeout seconds. A failed delivery is retried after
+// RetryBase seconds, doubled on every attempt up to RetryMax, and is dead
+// after MaxAttempts.
+type WebhookParams struct {
+	PollInterval float64 `json:"poll_interval"`
+	Workers      int     `json:"workers"`
+	Timeout      float64 `json:"timeout"`
+	MaxAttempts  int     `json:"max_attempts"`
+	RetryBase    float64 `json:"retry_base"`
+	RetryMax     float64 `json:"retry_max"`
+}
+
+// Event brokers.
+const (
+	BROKER_LOCAL    = "local"
+	BROKER_POSTGRES = "postgres"
+)
+
+// BrokerParams configures how events are shared between the instances of
+// the API. The local broker keeps them within the instance. The postgres
+// one sends them over LISTEN/NOTIFY on Channel of the database at URL, or
+// of DB when it's empty.
+type BrokerParams struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Channel string `json:"channel"`
+}
+
+// GRPCParams configures the gRPC API. It's served on Port, or on the port
+// of the REST API when it's empty.
+type GRPCParams struct {
+	Enabled bool   `json:"enabled"`
+	Port    string `json:"port"`
+}
+
+// SessionParams configures the sessions users sign in to. A session
+// expires IdleTimeout seconds after its token was last used, and
+// AbsoluteTimeout seconds after it was issued. Zero disables a timeout.
+//
+// Streams check their session every StreamCheck seconds, which keeps it in
+// use, and are closed once it's signed out or expired.
+type SessionParams struct {
+	IdleTimeout     float64 `json:"idle_timeout"`
+	AbsoluteTimeout float64 `json:"absolute_timeout"`
+	StreamCheck     float64 `json:"stream_check"`
+}
+
+var C = Configuration{
+	Idle: IdleParams{
+		Threshold: 300,
+		Speed:     3,
+	},
+	Geocoder: GeocoderParams{
+		MaxDistance: 1000,
+		CacheSize:   10000,
+	},
+	WebSocket: WebSocketParams{
+		AllowedOrigins: []string{},
+		AuthTimeout:    10,
+		QueueSize:      256,
+		SlowConsumer:   SLOW_CONSUMER_DROP_OLDEST,
+		PingInterval:   30,
+		PongTimeout:    60,
+		WriteTimeout:   10,
+	},
+	SSE: SSEParams{
+		ReplayBuffer: 1000,
+		KeepAlive:    15,
+	},
+	Webhook: WebhookParams{
+		PollInterval: 1,
+		Workers:      4,
+		Timeout:      10,
+		MaxAttempts:  8,
+		RetryBase:    10,
+		RetryMax:     3600,
+	},
+	Broker: BrokerParams{
+		Type:    BROKER_LOCAL,
+		Channel: "vehicle_tracker_events",
+	},
+	GRPC: GRPCParams{
+		Enabled: true,
+	},
+	Session: SessionParams{
+		IdleTimeout:     7 * 24 * 3600,
+		AbsoluteTimeout: 30 * 24 * 3600,
+		StreamCheck:     30,
+	},
+}
 
 func LoadConfigFile(filePath string) (err error) {
 	var file *os.File
@@ -15,6 +15,9 @@ type GPSData struct {
 	Lat string `json:"lat"`
 	Lon string `json:"lon"`
 	TS  string `json:"ts"`
+
+	// Speed in km/h as reported by the device, if it reports one.
+	Speed string `json:"speed,omitempty"`
 }
 
 // swagger:parameters FilterAgents
@@ -36,6 +39,9 @@ type FilterAgentsParams struct {
 // Filter vehicles.
 //
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: AgentSuccessAgentsResponse
@@ -47,7 +53,13 @@ func FilterAgents(w http.ResponseWriter, req *http.Request) {
 	}
 	params := FilterAgentsParams{AgentState: state}
 
-	agents = repository.FilterAgents(params.AgentState)
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	agents = repository.RestrictAgents(repository.FilterAgents(params.AgentState), restriction)
 
 	j, err := json.Marshal(agents)
 	checkErr(w, err)
@@ -90,6 +102,7 @@ func SyncAgent(w http.ResponseWriter, req *http.Request) {
 		params.Data.Lat,
 		params.Data.Lon,
 		params.Data.TS,
+		params.Data.Speed,
 	)
 	if err != nil {
 		sendErrorMessage(w, "Agent Sync Error", http.StatusBadRequest)
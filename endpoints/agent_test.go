@@ -25,9 +25,11 @@ func TestGetAllAgentsEndpoint(t *testing.T) {
 
 	// Prepare
 	_, _ = repository.CreateNewAgent("test")
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/agent/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -55,9 +57,11 @@ func TestFilterAgentsEndpoint(t *testing.T) {
 	_, _ = repository.CreateNewAgent("test")
 	_, _ = repository.CreateNewAgent("assigned")
 	_ = repository.CreateVehicle("testvehicle", "assigned", []int{}, "SCHOOL-BUS")
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/agent/?state=ASSIGNED", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -76,6 +80,7 @@ func TestFilterAgentsEndpoint(t *testing.T) {
 
 	// Execute
 	req, _ = http.NewRequest("GET", "/agent/?state=UNASSIGNED", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res = httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -93,6 +98,7 @@ func TestFilterAgentsEndpoint(t *testing.T) {
 
 	// Execute
 	req, _ = http.NewRequest("GET", "/agent/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res = httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -2,47 +2,132 @@ package endpoints
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cad/vehicle-tracker-api/config"
 	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
 )
 
+// bearerToken returns the token of an `Authorization: Bearer <token>`
+// header.
+func bearerToken(r *http.Request) (string, bool) {
+	s := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(s) != 2 || s[0] != "Bearer" {
+		return "", false
+	}
+	return s[1], true
+}
+
+// sessionByToken returns the user the token was issued to, and the
+// session it was issued in.
+func sessionByToken(token string) (repository.User, repository.Session, error) {
+	user, session, err := repository.Authenticate(token)
+	return user, session, authError(err)
+}
+
+// authError is the error sent to clients that failed to authenticate.
+func authError(err error) error {
+	if e, ok := err.(*repository.UserError); ok && e.What == "Session" && e.Type == "Expired" {
+		return fmt.Errorf("Session expired")
+	}
+	if err != nil {
+		// Rejected
+		return fmt.Errorf("Not Authorized")
+	}
+	// Permitted
+	return nil
+}
+
+// checkSession authenticates the session of a stream again, and checks
+// that its user may still read. The stream is closed when it fails.
+func checkSession(sessionID uint) error {
+	user, _, err := repository.AuthenticateSession(sessionID)
+	if err != nil {
+		return authError(err)
+	}
+	if !repository.RolePermits(user.Role, repository.PERMISSION_READ) {
+		return forbidden(user.Role, repository.PERMISSION_READ)
+	}
+	return nil
+}
+
+// streamChecks ticks every StreamCheck seconds, for streams to check
+// their session. It never ticks when the checks are disabled.
+func streamChecks() (<-chan time.Time, func()) {
+	interval := seconds(config.C.Session.StreamCheck)
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
 func TokenAuthMiddleware(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s := strings.Split(r.Header.Get("Authorization"), " ")
-
-		if len(s) != 2 {
+		token, ok := bearerToken(r)
+		if !ok {
 			sendErrorMessage(w, "Authorization token should be in the form of Authorization: Bearer <token>", 401)
 			return
 		}
 
-		if s[0] != "Bearer" {
-			sendErrorMessage(w, "Authorization token should be in the form of Authorization: Bearer <token>", 401)
+		user, session, err := sessionByToken(token)
+		if err != nil {
+			sendErrorMessage(w, err.Error(), 401)
 			return
 		}
+		ctx := NewUUIDContext(r.Context(), user.UUID)
+		ctx = NewRoleContext(ctx, user.Role)
+		ctx = NewSessionContext(ctx, session.ID)
 
-		// Check token
-		token := s[1]
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
 
-		if repository.CheckToken(token) != true {
-			// Rejected
-			sendErrorMessage(w, "Not Authorized", 401)
+// ReadAuthMiddleware authenticates the requests of the routes that read
+// as TokenAuthMiddleware does, requiring the read permission. Requests
+// without token are let through anonymous when the server allows
+// anonymous reads.
+func ReadAuthMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	authenticated := TokenAuthMiddleware(RequirePermission(repository.PERMISSION_READ)(h))
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" && config.C.Server.AnonymousRead {
+			h.ServeHTTP(w, r)
 			return
 		}
-		// Permitted
-		user, err := repository.GetUserByToken(token)
-		if err != nil {
-			log.Printf("can't get user by token %s", token)
-			sendErrorMessage(w, "Not Authorized", 401)
-		}
-		ctx := NewUUIDContext(r.Context(), user.UUID)
+		authenticated.ServeHTTP(w, r)
+	}
+}
 
-		h.ServeHTTP(w, r.WithContext(ctx))
+// RequirePermission answers 403 to the users whose role doesn't grant
+// permission. It goes inside TokenAuthMiddleware.
+func RequirePermission(permission string) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			role, _ := RoleFromContext(r.Context())
+			if !repository.RolePermits(role, permission) {
+				sendForbidden(w, role, permission)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
 	}
 }
 
+func sendForbidden(w http.ResponseWriter, role string, permission string) {
+	sendErrorMessage(w, forbidden(role, permission).Error(), http.StatusForbidden)
+}
+
+func forbidden(role string, permission string) error {
+	return fmt.Errorf("Forbidden: the %s role doesn't have the %s permission", role, permission)
+}
+
 type AuthorizationRequestPayload struct {
 	Email    string `json:"email" valid:"email"`
 	Password string `json:"password"`
@@ -60,6 +145,9 @@ type AuthorizationParams struct {
 // swagger:route POST /auth/ Auth Authorize
 // Get an `authorization_token`.
 //
+// Each token is issued in a session of its own, so that signing in on a
+// device leaves the others signed in. Sessions expire after the idle and
+// absolute timeouts of the configuration.
 //
 //   Responses:
 //     default: ErrorMsg
@@ -84,14 +172,14 @@ func Authorize(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token, err := user.RenewToken()
+	session, err := user.NewSession(req.UserAgent(), clientIP(req))
 	if err != nil {
 		log.Println(err.Error())
 		sendErrorMessage(w, "Can not create token", 500)
 		return
 	}
 	payload := AuthorizationResponsePayload{
-		AuthorizationToken: token,
+		AuthorizationToken: session.Token,
 	}
 	j, err := json.Marshal(payload)
 	checkErr(w, err)
@@ -130,3 +218,127 @@ func CheckAuth(w http.ResponseWriter, req *http.Request) {
 	sendContentType(w, "application/json")
 	w.Write(j)
 }
+
+// clientIP returns the address of the client of req, without port.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// tokenOwner returns the user who made req, authenticated by
+// TokenAuthMiddleware.
+func tokenOwner(req *http.Request) (repository.User, bool) {
+	uuid, ok := UUIDFromContext(req.Context())
+	if !ok {
+		return repository.User{}, false
+	}
+	user, err := repository.GetUserByUUID(uuid)
+	return user, err == nil
+}
+
+// swagger:route DELETE /auth/ Auth Logout
+// Revoke the token of the request, signing out of its session.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: SessionSuccessSessionResponse
+func Logout(w http.ResponseWriter, req *http.Request) {
+	user, ok := tokenOwner(req)
+	sessionID, _ := SessionFromContext(req.Context())
+	if !ok {
+		sendErrorMessage(w, "Can't get uuid from token", 500)
+		return
+	}
+
+	session, err := repository.DeleteSession(user.ID, sessionID)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(session)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:route GET /auth/session/ Auth GetSessions
+// Get the sessions you are signed in to, latest first.
+//
+// The session of the request is marked as current.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: SessionSuccessSessionsResponse
+func GetSessions(w http.ResponseWriter, req *http.Request) {
+	user, ok := tokenOwner(req)
+	sessionID, _ := SessionFromContext(req.Context())
+	if !ok {
+		sendErrorMessage(w, "Can't get uuid from token", 500)
+		return
+	}
+
+	sessions := repository.GetUserSessions(user.ID)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sessionID
+	}
+
+	j, err := json.Marshal(sessions)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters RevokeSession
+type RevokeSessionParams struct {
+
+	// SessionID
+	// in: path
+	// required: true
+	ID string `json:"session_id"`
+}
+
+// swagger:route DELETE /auth/session/{session_id} Auth RevokeSession
+// Revoke one of your sessions, e.g. that of a lost device.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: SessionSuccessSessionResponse
+func RevokeSession(w http.ResponseWriter, req *http.Request) {
+	params := RevokeSessionParams{ID: mux.Vars(req)["session_id"]}
+
+	sessionID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "session_id should be int", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := tokenOwner(req)
+	if !ok {
+		sendErrorMessage(w, "Can't get uuid from token", 500)
+		return
+	}
+
+	session, err := repository.DeleteSession(user.ID, uint(sessionID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(session)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
@@ -0,0 +1,263 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/cad/vehicle-tracker-api/rpc"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// signIn posts the credentials from a client with the user agent and
+// returns the token issued.
+func signIn(email string, password string, userAgent string) string {
+	req, _ := http.NewRequest("POST", "/auth/", strings.NewReader(fmt.Sprintf(`{"email": "%s", "password": "%s"}`, email, password)))
+	req.Header.Set("User-Agent", userAgent)
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+	var payload AuthorizationResponsePayload
+	json.Unmarshal(res.Body.Bytes(), &payload)
+	return payload.AuthorizationToken
+}
+
+// viewerToken creates a viewer and returns a token of theirs, for the
+// tests of the reads.
+func viewerToken() string {
+	user, _ := repository.CreateNewUser("viewer@test.com", "1234", repository.ROLE_VIEWER)
+	token, _ := user.RenewToken()
+	return token
+}
+
+func TestSessionEndpoints(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	phone := signIn("test@test.com", "1234", "phone")
+	laptop := signIn("test@test.com", "1234", "laptop")
+
+	// Test
+	for _, token := range []string{phone, laptop} {
+		if res := authorized("GET", "/auth/", "", token); res.Code != 200 {
+			t.Error(errorMsg("StatusCode of both sessions", "200", fmt.Sprintf("%d", res.Code)))
+			return
+		}
+	}
+
+	// Execute
+	res := authorized("GET", "/auth/session/", "", laptop)
+
+	// Test
+	var sessions []repository.Session
+	if err := json.Unmarshal(res.Body.Bytes(), &sessions); err != nil || len(sessions) != 2 {
+		t.Error(errorMsg("Sessions", "2", res.Body.String()))
+		return
+	}
+
+	if !sessions[0].Current || sessions[0].UserAgent != "laptop" || sessions[1].Current || sessions[1].UserAgent != "phone" {
+		t.Error(errorMsg("Sessions", "laptop (current), phone", res.Body.String()))
+		return
+	}
+
+	// Execute
+	res = authorized("DELETE", fmt.Sprintf("/auth/session/%d", sessions[1].ID), "", laptop)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode of revoking", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if res := authorized("GET", "/auth/", "", phone); res.Code != 401 {
+		t.Error(errorMsg("StatusCode of a revoked session", "401", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = authorized("DELETE", "/auth/", "", laptop)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode of logging out", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if res := authorized("GET", "/auth/", "", laptop); res.Code != 401 {
+		t.Error(errorMsg("StatusCode after logging out", "401", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestRevokeSessionOfAnotherUser(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	other, _ := repository.CreateNewUser("other@test.com", "1234", repository.ROLE_VIEWER)
+	token, _ := user.RenewToken()
+	session, _ := other.NewSession("phone", "")
+
+	// Execute
+	res := authorized("DELETE", fmt.Sprintf("/auth/session/%d", session.ID), "", token)
+
+	// Test
+	if res.Code != 404 {
+		t.Error(errorMsg("StatusCode", "404", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if _, err := repository.GetUserByToken(session.Token); err != nil {
+		t.Error(errorMsg("Session of the other user", "Kept", err.Error()))
+		return
+	}
+}
+
+func TestSessionTimeouts(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	defaults := config.C.Session
+	defer func() { config.C.Session = defaults }()
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	config.C.Session.IdleTimeout = 0.2
+	config.C.Session.AbsoluteTimeout = 0
+	idle, _ := user.RenewToken()
+
+	// Execute
+	time.Sleep(100 * time.Millisecond)
+	res := authorized("GET", "/auth/", "", idle)
+	time.Sleep(100 * time.Millisecond)
+	kept := authorized("GET", "/auth/", "", idle)
+	time.Sleep(300 * time.Millisecond)
+	expired := authorized("GET", "/auth/", "", idle)
+
+	// Test
+	if res.Code != 200 || kept.Code != 200 {
+		t.Error(errorMsg("StatusCode of a session in use", "200", fmt.Sprintf("%d, %d", res.Code, kept.Code)))
+		return
+	}
+
+	if expired.Code != 401 || !strings.Contains(expired.Body.String(), "Session expired") {
+		t.Error(errorMsg("Idle session", "Session expired", expired.Body.String()))
+		return
+	}
+
+	// Prepare
+	config.C.Session.IdleTimeout = 0
+	config.C.Session.AbsoluteTimeout = 0.3
+	absolute, _ := user.RenewToken()
+
+	// Execute
+	time.Sleep(150 * time.Millisecond)
+	res = authorized("GET", "/auth/", "", absolute)
+	time.Sleep(200 * time.Millisecond)
+	expired = authorized("GET", "/auth/", "", absolute)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode before expiry", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if expired.Code != 401 {
+		t.Error(errorMsg("StatusCode after expiry", "401", fmt.Sprintf("%d", expired.Code)))
+		return
+	}
+}
+
+func TestStreamsSignedOut(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	// Restored once the servers are closed, as the streams read it.
+	defaults := config.C.Session
+	defer func() { config.C.Session = defaults }()
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+	client, stop := rpcClient()
+	defer stop()
+	config.C.Session.StreamCheck = 0.05
+
+	// Prepare
+	repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	phone := signIn("test@test.com", "1234", "phone")
+	laptop := signIn("test@test.com", "1234", "laptop")
+	ws, err := dialStream(server, phone)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer ws.Close()
+	kept, err := dialStream(server, laptop)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer kept.Close()
+	res, r, err := openSSE(server, phone, "", "")
+	if err != nil {
+		t.Error(errorMsg("Connect", "Connected", err.Error()))
+		return
+	}
+	defer res.Body.Close()
+	watch, _ := client.WatchVehicles(rpcContext(phone), &rpc.VehicleFilter{})
+	time.Sleep(100 * time.Millisecond)
+
+	// Execute
+	authorized("DELETE", "/auth/", "", phone)
+	_, _, wsErr := ws.ReadMessage()
+	_, sseErr := readSSE(r)
+	_, watchErr := watch.Recv()
+
+	// Test
+	if !websocket.IsCloseError(wsErr, websocket.ClosePolicyViolation) {
+		t.Error(errorMsg("Stream of the session signed out", "closed", fmt.Sprintf("%v", wsErr)))
+		return
+	}
+
+	if sseErr != io.EOF {
+		t.Error(errorMsg("SSE stream of the session signed out", "EOF", fmt.Sprintf("%v", sseErr)))
+		return
+	}
+
+	if status.Code(watchErr) != codes.Unauthenticated {
+		t.Error(errorMsg("Watch of the session signed out", "Unauthenticated", fmt.Sprintf("%v", watchErr)))
+		return
+	}
+
+	// Execute
+	kept.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_ALERTS})
+	var reply StreamEnvelope
+	err = kept.ReadJSON(&reply)
+
+	// Test
+	if err != nil || reply.Type != STREAM_SUBSCRIBED {
+		t.Error(errorMsg("Stream of the other session", "subscribed", fmt.Sprintf("%v %v", reply, err)))
+		return
+	}
+}
@@ -0,0 +1,209 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// VehicleCluster is a group of vehicles close to each other on the map
+// at the requested zoom level.
+type VehicleCluster struct {
+	Count int     `json:"count"`
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+
+	// Types maps vehicle types to the number of vehicles of that type.
+	Types map[string]int `json:"types"`
+
+	// Groups maps group names to the number of vehicles in that group.
+	Groups map[string]int `json:"groups"`
+
+	vehicles []repository.Vehicle
+}
+
+// VehicleClusters holds the clusters and the vehicles that are shown
+// individually because their cluster is small enough.
+type VehicleClusters struct {
+	Clusters []VehicleCluster     `json:"clusters"`
+	Vehicles []repository.Vehicle `json:"vehicles"`
+}
+
+// swagger:parameters ClusterVehicles
+type ClusterVehiclesParams struct {
+
+	// BBox
+	//
+	// Bounding box of the map as min_lon,min_lat,max_lon,max_lat.
+	//
+	// in: query
+	// required: true
+	BBox string `json:"bbox"`
+
+	// Zoom
+	//
+	// Zoom level of the map.
+	//
+	// in: query
+	// required: true
+	Zoom float64 `json:"zoom"`
+
+	// Radius
+	//
+	// Cluster radius in pixels, 60 by default.
+	//
+	// in: query
+	// required: false
+	Radius float64 `json:"radius"`
+
+	// MinClusterSize
+	//
+	// Clusters with fewer vehicles are returned as individual vehicles,
+	// 2 by default.
+	//
+	// in: query
+	// required: false
+	MinClusterSize int `json:"min_cluster_size"`
+
+	FilterVehiclesParams
+}
+
+func parseBBox(s string) (geo.BBox, error) {
+	var bbox geo.BBox
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox, &ParamError{Name: "bbox", Type: "min_lon,min_lat,max_lon,max_lat"}
+	}
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return bbox, &ParamError{Name: "bbox", Type: "min_lon,min_lat,max_lon,max_lat"}
+		}
+		values[i] = v
+	}
+	return geo.BBox{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}, nil
+}
+
+func parseClusterVehiclesParams(req *http.Request) (ClusterVehiclesParams, error) {
+	filter, err := parseFilterVehiclesParams(req)
+	if err != nil {
+		return ClusterVehiclesParams{}, err
+	}
+	params := ClusterVehiclesParams{
+		BBox:                 req.URL.Query().Get("bbox"),
+		Radius:               60,
+		MinClusterSize:       2,
+		FilterVehiclesParams: filter,
+	}
+	q := req.URL.Query()
+	if params.Zoom, err = strconv.ParseFloat(q.Get("zoom"), 64); err != nil || params.Zoom < 0 {
+		return params, &ParamError{Name: "zoom", Type: "a positive number"}
+	}
+	if s := q.Get("radius"); s != "" {
+		if params.Radius, err = strconv.ParseFloat(s, 64); err != nil || params.Radius <= 0 {
+			return params, &ParamError{Name: "radius", Type: "a positive number"}
+		}
+	}
+	if s := q.Get("min_cluster_size"); s != "" {
+		if params.MinClusterSize, err = strconv.Atoi(s); err != nil {
+			return params, &ParamError{Name: "min_cluster_size", Type: "int"}
+		}
+	}
+	return params, nil
+}
+
+// clusterVehicles groups the vehicles inside bbox on a grid of radius
+// pixels at zoom.
+func clusterVehicles(vehicles []repository.Vehicle, bbox geo.BBox, zoom, radius float64, minClusterSize int) VehicleClusters {
+	type cellKey struct {
+		x, y int64
+	}
+	cells := map[cellKey]*VehicleCluster{}
+	order := make([]cellKey, 0)
+
+	for _, vehicle := range vehicles {
+		if vehicle.Agent == nil {
+			continue
+		}
+		p, ok := vehicle.Agent.Point()
+		if !ok || !bbox.Contains(p) {
+			continue
+		}
+		x, y := geo.Project(p, zoom)
+		key := cellKey{x: int64(math.Floor(x / radius)), y: int64(math.Floor(y / radius))}
+		cluster, ok := cells[key]
+		if !ok {
+			cluster = &VehicleCluster{Types: map[string]int{}, Groups: map[string]int{}}
+			cells[key] = cluster
+			order = append(order, key)
+		}
+		cluster.Count++
+		cluster.Lat += p.Lat
+		cluster.Lon += p.Lon
+		cluster.Types[vehicle.Type]++
+		for _, group := range vehicle.Groups {
+			cluster.Groups[group.Name]++
+		}
+		cluster.vehicles = append(cluster.vehicles, vehicle)
+	}
+
+	result := VehicleClusters{
+		Clusters: make([]VehicleCluster, 0),
+		Vehicles: make([]repository.Vehicle, 0),
+	}
+	for _, key := range order {
+		cluster := cells[key]
+		if cluster.Count < minClusterSize {
+			result.Vehicles = append(result.Vehicles, cluster.vehicles...)
+			continue
+		}
+		cluster.Lat /= float64(cluster.Count)
+		cluster.Lon /= float64(cluster.Count)
+		result.Clusters = append(result.Clusters, *cluster)
+	}
+	return result
+}
+
+// swagger:route GET /vehicle/cluster Vehicles ClusterVehicles
+// Cluster the vehicles within a bounding box for a map zoom level.
+//
+// Accepts the filters of FilterVehicles.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessClustersResponse
+func ClusterVehicles(w http.ResponseWriter, req *http.Request) {
+	params, err := parseClusterVehiclesParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bbox, err := parseBBox(params.BBox)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	vehicles := restrictVehicles(filterVehicles(params.FilterVehiclesParams), restriction)
+	clusters := clusterVehicles(vehicles, bbox, params.Zoom, params.Radius, params.MinClusterSize)
+
+	j, err := json.Marshal(clusters)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
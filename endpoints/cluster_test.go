@@ -0,0 +1,113 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestClusterVehiclesEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	groupID, _ := repository.CreateNewGroup("north")
+	for i, fix := range [][2]string{
+		{"41.000", "29.000"}, {"41.001", "29.001"}, {"41.002", "29.002"}, {"38.000", "27.000"},
+	} {
+		uuid := fmt.Sprintf("agent%d", i)
+		plateID := fmt.Sprintf("vehicle%d", i)
+		vehicleType := "SCHOOL-BUS"
+		if i == 2 {
+			vehicleType = "SOLAR-CAR"
+		}
+		_, _ = repository.CreateNewAgent(uuid)
+		_ = repository.CreateVehicle(plateID, uuid, []int{int(groupID)}, vehicleType)
+		syncAgent(uuid, GPSData{Lat: fix[0], Lon: fix[1], TS: "1000"})
+	}
+	_ = repository.CreateVehicle("outside", "", []int{}, "SCHOOL-BUS")
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/cluster?bbox=26,37,30,42&zoom=8", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var clusters VehicleClusters
+	err := json.Unmarshal([]byte(res.Body.String()), &clusters)
+	if err != nil {
+		t.Error(errorMsg("Clusters", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(clusters.Clusters); count != 1 {
+		t.Error(errorMsg("len(Clusters)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	cluster := clusters.Clusters[0]
+	if cluster.Count != 3 {
+		t.Error(errorMsg("Count", "3", fmt.Sprintf("%d", cluster.Count)))
+		return
+	}
+
+	if cluster.Types["SCHOOL-BUS"] != 2 || cluster.Types["SOLAR-CAR"] != 1 {
+		t.Error(errorMsg("Types", "SCHOOL-BUS:2 SOLAR-CAR:1", fmt.Sprintf("%v", cluster.Types)))
+		return
+	}
+
+	if cluster.Groups["north"] != 3 {
+		t.Error(errorMsg("Groups", "north:3", fmt.Sprintf("%v", cluster.Groups)))
+		return
+	}
+
+	if cluster.Lat < 41.0009 || cluster.Lat > 41.0011 {
+		t.Error(errorMsg("Lat", "41.001", fmt.Sprintf("%v", cluster.Lat)))
+		return
+	}
+
+	if count := len(clusters.Vehicles); count != 1 || clusters.Vehicles[0].PlateID != "vehicle3" {
+		t.Error(errorMsg("Vehicles", "[vehicle3]", fmt.Sprintf("%v", clusters.Vehicles)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/cluster?bbox=26,37,30,42&zoom=18", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	clusters = VehicleClusters{}
+	err = json.Unmarshal([]byte(res.Body.String()), &clusters)
+	if err != nil {
+		t.Error(errorMsg("Clusters", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(clusters.Vehicles); count != 4 {
+		t.Error(errorMsg("len(Vehicles)", "4", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/cluster?bbox=26,37,30&zoom=8", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
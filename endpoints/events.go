@@ -0,0 +1,136 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// Returns the event bus counters
+// swagger:response
+type EventSuccessStatsResponse struct {
+	// Stats
+	// in: body
+	Body event.Stats
+}
+
+// swagger:route GET /events/stats Events GetEventStats
+// Get the published and dropped event counts, per kind and subscription.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: EventSuccessStatsResponse
+func GetEventStats(w http.ResponseWriter, req *http.Request) {
+	j, err := json.Marshal(event.GetStats())
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// EVENT_LIMIT is the default number of events of a page of the log, and
+// EVENT_LIMIT_MAX the most.
+const (
+	EVENT_LIMIT     = 100
+	EVENT_LIMIT_MAX = 1000
+)
+
+// EventLogPage is a page of the event log. Cursor is the id to ask for
+// the next page after: that of the last event, or the given one when the
+// page is empty.
+type EventLogPage struct {
+	Events []repository.StoredEvent `json:"events"`
+	Cursor uint                     `json:"cursor"`
+}
+
+// Returns a page of the event log
+// swagger:response
+type EventSuccessLogResponse struct {
+	// Page
+	// in: body
+	Body EventLogPage
+}
+
+// swagger:parameters GetEvents
+type GetEventsParams struct {
+
+	// After
+	//
+	// Id of the last event received, 0 to start from the first
+	//
+	// in: query
+	// required: false
+	After uint `json:"after"`
+
+	// Kind
+	//
+	// Only the events of the kinds, e.g. "VEHICLE-CREATED"
+	//
+	// in: query
+	// required: false
+	// collectionFormat: csv
+	Kind []string `json:"kind"`
+
+	// Limit
+	//
+	// Number of events, 100 by default and 1000 at most
+	//
+	// in: query
+	// required: false
+	Limit int `json:"limit"`
+}
+
+// swagger:route GET /events Events GetEvents
+// Get the events of the log after a cursor, oldest first.
+//
+// Every change is logged along with its events, so consumers catching up
+// after downtime by asking for the events after the cursor of the last
+// page they got miss none of them.
+//
+// The log holds the changes of every user and vehicle, so it's only read
+// by the users who manage the users.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: EventSuccessLogResponse
+func GetEvents(w http.ResponseWriter, req *http.Request) {
+	params := GetEventsParams{Kind: queryList(req, "kind")}
+
+	if s := req.URL.Query().Get("after"); s != "" {
+		after, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			sendErrorMessage(w, "after should be an event id", http.StatusBadRequest)
+			return
+		}
+		params.After = uint(after)
+	}
+	var err error
+	if params.Limit, err = queryLimit(req, EVENT_LIMIT); err != nil {
+		sendErrorMessage(w, "limit should be a positive int", http.StatusBadRequest)
+		return
+	}
+	if params.Limit > EVENT_LIMIT_MAX {
+		params.Limit = EVENT_LIMIT_MAX
+	}
+
+	page := EventLogPage{
+		Events: repository.GetStoredEvents(params.After, params.Kind, params.Limit),
+		Cursor: params.After,
+	}
+	if len(page.Events) > 0 {
+		page.Cursor = page.Events[len(page.Events)-1].ID
+	}
+
+	j, err := json.Marshal(page)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
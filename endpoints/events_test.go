@@ -0,0 +1,465 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/broker"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestGetEventStatsEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	// The counters of the default bus add up over the runs of the test,
+	// so the stats are compared with the ones before emitting.
+	slowStats := func() (event.KindStats, error) {
+		req, _ := http.NewRequest("GET", "/events/stats", nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		res := httptest.NewRecorder()
+		GetRouter().ServeHTTP(res, req)
+		var stats event.Stats
+		if err := json.Unmarshal(res.Body.Bytes(), &stats); err != nil {
+			return event.KindStats{}, fmt.Errorf("unmarshallable: %s", res.Body.String())
+		}
+		for _, kind := range stats.Kinds {
+			if kind.Kind == "TEST-SLOW" {
+				return kind, nil
+			}
+		}
+		return event.KindStats{}, nil
+	}
+	before, err := slowStats()
+	if err != nil {
+		t.Error(errorMsg("Stats", "Unmarshallable", err.Error()))
+		return
+	}
+	release := make(chan struct{})
+	subscription := event.Subscribe([]string{"TEST-SLOW"}, event.Options{Name: "slow", Buffer: 1}, func(e *event.Event) {
+		<-release
+	})
+	defer subscription.Cancel()
+	slow := event.MakeKind("TEST-SLOW")
+	for i := 0; i < 4; i++ {
+		slow.Emit(i)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Execute
+	after, err := slowStats()
+	close(release)
+
+	// Test
+	if err != nil {
+		t.Error(errorMsg("Stats", "Unmarshallable", err.Error()))
+		return
+	}
+	if after.Kind != "TEST-SLOW" {
+		t.Error(errorMsg("Kinds", "TEST-SLOW", "missing"))
+		return
+	}
+
+	// One event is being handled and one is queued, the others dropped.
+	published, dropped := after.Published-before.Published, after.Dropped-before.Dropped
+	if published != 4 || dropped != 2 {
+		t.Error(errorMsg("TEST-SLOW", "4 published, 2 dropped", fmt.Sprintf("%d published, %d dropped", published, dropped)))
+		return
+	}
+}
+
+func TestEventOrderingAndShutdown(t *testing.T) {
+	// Init
+	bus := event.NewBus()
+	bus.Run()
+
+	// Prepare
+	var lock sync.Mutex
+	received := map[string][]string{}
+	bus.Subscribe([]string{"TEST-POSITION"}, event.Options{Workers: 4}, func(e *event.Event) {
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		defer lock.Unlock()
+		received[e.Key] = append(received[e.Key], e.Payload.(string))
+	})
+
+	// Execute
+	for i := 0; i < 20; i++ {
+		for _, agent := range []string{"agent1", "agent2", "agent3"} {
+			bus.Publish("TEST-POSITION", agent, fmt.Sprintf("%d", i))
+		}
+	}
+	err := bus.Shutdown(context.Background())
+	bus.Publish("TEST-POSITION", "agent1", "late")
+
+	// Test
+	if err != nil {
+		t.Error(errorMsg("Shutdown", "Drained", err.Error()))
+		return
+	}
+
+	for _, agent := range []string{"agent1", "agent2", "agent3"} {
+		want := make([]string, 20)
+		for i := range want {
+			want[i] = fmt.Sprintf("%d", i)
+		}
+		if got := strings.Join(received[agent], ","); got != strings.Join(want, ",") {
+			t.Error(errorMsg(agent, strings.Join(want, ","), got))
+			return
+		}
+	}
+
+	// Execute
+	bus.Run()
+	bus.Subscribe([]string{"TEST-POSITION"}, event.Options{}, func(e *event.Event) {
+		time.Sleep(time.Second)
+	})
+	bus.Publish("TEST-POSITION", "agent1", "slow")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = bus.Shutdown(ctx)
+
+	// Test
+	if err != context.DeadlineExceeded {
+		t.Error(errorMsg("Shutdown", context.DeadlineExceeded.Error(), fmt.Sprintf("%v", err)))
+		return
+	}
+}
+
+func TestChangeEvents(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	_, _ = repository.CreateNewAgent("agent1")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("car1", "", []int{}, "SOLAR-CAR")
+	changes := make(chan *event.Event, 10)
+	subscription := event.Subscribe(repository.CHANGE_KINDS, event.Options{Name: "changes"}, func(e *event.Event) {
+		changes <- e
+	})
+	defer subscription.Cancel()
+
+	// Execute
+	req, _ := http.NewRequest("POST", "/vehicle/car1/agent", strings.NewReader(`{"uuid": "agent1"}`))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	received := map[string]repository.Change{}
+	for len(received) < 2 {
+		select {
+		case e := <-changes:
+			received[e.Kind+" "+e.Key] = e.Payload.(repository.Change)
+		case <-time.After(time.Second):
+			t.Error(errorMsg("Changes", "2", fmt.Sprintf("%d", len(received))))
+			return
+		}
+	}
+
+	unset, ok := received[repository.VEHICLE_AGENT_UNSET+" bus1"]
+	if !ok || unset.Actor != user.UUID || unset.Before.(repository.Vehicle).Agent.UUID != "agent1" || unset.After.(repository.Vehicle).Agent != nil {
+		t.Error(errorMsg("Unset bus1", "agent1 to none by "+user.UUID, fmt.Sprintf("%+v", unset)))
+		return
+	}
+
+	set, ok := received[repository.VEHICLE_AGENT_SET+" car1"]
+	if !ok || set.Actor != user.UUID || set.Before.(repository.Vehicle).Agent != nil || set.After.(repository.Vehicle).Agent.UUID != "agent1" {
+		t.Error(errorMsg("Set car1", "none to agent1 by "+user.UUID, fmt.Sprintf("%+v", set)))
+		return
+	}
+}
+
+func TestGetEventsEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	authorized("POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, token)
+	// Fails, so logs nothing.
+	authorized("POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, token)
+	authorized("DELETE", "/vehicle/bus1", "", token)
+
+	// Execute
+	res := authorized("GET", "/events?kind=VEHICLE-CREATED,VEHICLE-DELETED&limit=1", "", token)
+
+	// Test
+	var page EventLogPage
+	if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+		t.Error(errorMsg("Page", "Unmarshallable", res.Body.String()))
+		return
+	}
+
+	var change repository.Change
+	if len(page.Events) != 1 || page.Events[0].Kind != repository.VEHICLE_CREATED || page.Cursor != page.Events[0].ID {
+		t.Error(errorMsg("Page", "VEHICLE-CREATED", res.Body.String()))
+		return
+	}
+
+	if err := json.Unmarshal(page.Events[0].Data, &change); err != nil || change.Actor != user.UUID || change.Before != nil {
+		t.Error(errorMsg("Change", "created by "+user.UUID, string(page.Events[0].Data)))
+		return
+	}
+
+	// Execute
+	res = authorized("GET", fmt.Sprintf("/events?kind=VEHICLE-CREATED,VEHICLE-DELETED&after=%d", page.Cursor), "", token)
+	json.Unmarshal(res.Body.Bytes(), &page)
+
+	// Test
+	if len(page.Events) != 1 || page.Events[0].Kind != repository.VEHICLE_DELETED {
+		t.Error(errorMsg("Next page", "VEHICLE-DELETED", res.Body.String()))
+		return
+	}
+
+	// Execute
+	cursor := page.Cursor
+	res = authorized("GET", fmt.Sprintf("/events?kind=VEHICLE-CREATED,VEHICLE-DELETED&after=%d", cursor), "", token)
+	json.Unmarshal(res.Body.Bytes(), &page)
+
+	// Test
+	if len(page.Events) != 0 || page.Cursor != cursor {
+		t.Error(errorMsg("Last page", fmt.Sprintf("empty at %d", cursor), res.Body.String()))
+		return
+	}
+
+	// Prepare
+	groupID, _ := repository.CreateNewGroup("north")
+	viewer, _ := repository.CreateNewUser("viewer@test.com", "1234", repository.ROLE_VIEWER)
+	_, _ = repository.SetUserGroups(viewer.UUID, []int{int(groupID)})
+	viewerToken, _ := viewer.RenewToken()
+
+	// Execute
+	res = authorized("GET", "/events", "", viewerToken)
+
+	// Test
+	if res.Code != http.StatusForbidden {
+		t.Error(errorMsg("StatusCode of a restricted viewer", "403", fmt.Sprintf("%d %s", res.Code, res.Body.String())))
+		return
+	}
+}
+
+func TestEventBroker(t *testing.T) {
+	// Init
+	broker := event.NewLocalBroker()
+	local, remote := event.NewBus(), event.NewBus()
+	local.SetBroker(broker)
+	remote.SetBroker(broker)
+	local.Run()
+	remote.Run()
+
+	// Prepare
+	received := make(chan *event.Event, 10)
+	local.Subscribe([]string{"TEST-BROKER"}, event.Options{}, func(e *event.Event) { received <- e })
+	remote.Subscribe([]string{"TEST-BROKER"}, event.Options{}, func(e *event.Event) { received <- e })
+
+	// Execute
+	local.Publish("TEST-BROKER", "key", "payload")
+	local.Shutdown(context.Background())
+	remote.Shutdown(context.Background())
+	close(received)
+
+	// Test
+	var events []*event.Event
+	for e := range received {
+		events = append(events, e)
+	}
+	if len(events) != 2 {
+		t.Error(errorMsg("Events", "2", fmt.Sprintf("%d", len(events))))
+		return
+	}
+
+	remotes := 0
+	for _, e := range events {
+		if e.Remote {
+			remotes++
+		}
+	}
+	if remotes != 1 {
+		t.Error(errorMsg("Remote events", "1", fmt.Sprintf("%d", remotes)))
+		return
+	}
+}
+
+func TestNotifyBroker(t *testing.T) {
+	// Init
+	notifier := broker.NewLocalNotifier()
+	defer notifier.Close()
+	local, remote := event.NewBus(), event.NewBus()
+	local.SetBroker(broker.New(notifier))
+	remote.SetBroker(broker.New(notifier))
+	local.Run()
+	remote.Run()
+	defer local.Shutdown(context.Background())
+	defer remote.Shutdown(context.Background())
+
+	// Prepare
+	received := make(chan *event.Event, 1)
+	remote.Subscribe([]string{repository.VEHICLE_CREATED}, event.Options{}, func(e *event.Event) { received <- e })
+
+	// Execute
+	local.Publish(repository.VEHICLE_CREATED, "bus1", repository.Change{Actor: "user", After: repository.Vehicle{PlateID: "bus1"}})
+
+	// Test
+	var e *event.Event
+	select {
+	case e = <-received:
+	case <-time.After(2 * time.Second):
+		t.Error(errorMsg("Event", "Received", "Timeout"))
+		return
+	}
+
+	change, ok := e.Payload.(repository.Change)
+	if !ok || !e.Remote {
+		t.Error(errorMsg("Payload", "Remote change", fmt.Sprintf("%#v", e)))
+		return
+	}
+
+	if vehicle, ok := change.After.(repository.Vehicle); !ok || vehicle.PlateID != "bus1" || change.Before != nil || change.Actor != "user" {
+		t.Error(errorMsg("Change", "bus1 created by user", fmt.Sprintf("%#v", change)))
+		return
+	}
+}
+
+// loggedGroupEvent creates a group and returns the event published for
+// it, which is logged.
+func loggedGroupEvent(name string) (*event.Event, error) {
+	created := make(chan *event.Event, 1)
+	subscription := event.Subscribe([]string{repository.GROUP_CREATED}, event.Options{}, func(e *event.Event) { created <- e })
+	defer subscription.Cancel()
+	if _, err := repository.CreateNewGroup(name); err != nil {
+		return nil, err
+	}
+	select {
+	case e := <-created:
+		return e, nil
+	case <-time.After(2 * time.Second):
+		return nil, fmt.Errorf("timeout")
+	}
+}
+
+// testBrokerCarries publishes the events on a bus with a broker from
+// newBroker, and checks that a bus with another one receives them.
+func testBrokerCarries(t *testing.T, newBroker func() (*broker.NotifyBroker, error), events ...*event.Event) {
+	local, remote := event.NewBus(), event.NewBus()
+	for _, bus := range []*event.Bus{local, remote} {
+		b, err := newBroker()
+		if err != nil {
+			t.Error(errorMsg("Broker", "Connected", err.Error()))
+			return
+		}
+		defer b.Close()
+		bus.SetBroker(b)
+		bus.Run()
+		defer bus.Shutdown(context.Background())
+	}
+	received := make(chan *event.Event, len(events))
+	remote.Subscribe([]string{repository.GROUP_CREATED}, event.Options{}, func(e *event.Event) { received <- e })
+
+	for _, e := range events {
+		// Execute
+		local.PublishLogged(e.Kind, e.Key, e.LogID, e.Payload)
+
+		// Test
+		var got *event.Event
+		select {
+		case got = <-received:
+		case <-time.After(2 * time.Second):
+			t.Error(errorMsg("Event", "Received", "Timeout"))
+			return
+		}
+
+		change, ok := got.Payload.(repository.Change)
+		if !ok || !got.Remote {
+			t.Error(errorMsg("Payload", "Remote change", fmt.Sprintf("%#v", got)))
+			return
+		}
+
+		want := e.Payload.(repository.Change).After.(repository.Group).Name
+		if group, ok := change.After.(repository.Group); !ok || group.Name != want {
+			t.Error(errorMsg("Group", fmt.Sprintf("%.20s...", want), fmt.Sprintf("%.20s...", fmt.Sprintf("%#v", change.After))))
+			return
+		}
+	}
+}
+
+func TestNotifyBrokerLargeEvent(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	notifier := broker.NewLocalNotifier()
+	defer notifier.Close()
+
+	// Prepare
+	large, err := loggedGroupEvent(strings.Repeat("g", 2*broker.MAX_PAYLOAD))
+	if err != nil {
+		t.Error(errorMsg("Group", "Created", err.Error()))
+		return
+	}
+
+	// Execute, Test
+	testBrokerCarries(t, func() (*broker.NotifyBroker, error) { return broker.New(notifier), nil }, large)
+}
+
+// TestPostgresNotifier runs against the Postgres database at
+// TEST_POSTGRES_URL, when it's set.
+func TestPostgresNotifier(t *testing.T) {
+	// Init
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	repository.ConnectDB("postgres", url)
+	defer repository.CloseDB()
+	event.Run()
+	defer event.Shutdown(context.Background())
+
+	// Prepare
+	small, err := loggedGroupEvent("group1")
+	if err != nil {
+		t.Error(errorMsg("Group", "Created", err.Error()))
+		return
+	}
+	large, err := loggedGroupEvent(strings.Repeat("g", 2*broker.MAX_PAYLOAD))
+	if err != nil {
+		t.Error(errorMsg("Group", "Created", err.Error()))
+		return
+	}
+
+	// Execute, Test
+	testBrokerCarries(t, func() (*broker.NotifyBroker, error) { return broker.NewPostgres(url, "test_events") }, small, large)
+}
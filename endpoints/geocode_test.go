@@ -0,0 +1,103 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+const testGazetteer = `name,lat,lon
+# comment
+Main St & 5th,41.0000,29.0000
+Main St & 6th,41.0050,29.0000
+Harbour,41.1000,29.1000
+`
+
+func TestReverseGeocodedPlaces(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	ioutil.WriteFile("/tmp/test_gazetteer.csv", []byte(testGazetteer), 0644)
+	defer os.Remove("/tmp/test_gazetteer.csv")
+	gazetteer, err := geocode.LoadGazetteer("/tmp/test_gazetteer.csv", 1000)
+	if err != nil {
+		t.Error(errorMsg("Gazetteer", "Loaded", err.Error()))
+		return
+	}
+	geocode.Default = geocode.NewCache(gazetteer, 2)
+	defer func() { geocode.Default = nil }()
+	config.C.Speeding.TypeLimits = map[string]float64{"SCHOOL-BUS": 50}
+	defer func() { config.C.Speeding.TypeLimits = nil }()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	syncAgent("test", GPSData{Lat: "41.0001", Lon: "29.0001", TS: "1000", Speed: "70"})
+	syncAgent("test", GPSData{Lat: "41.0049", Lon: "29.0001", TS: "1010", Speed: "30"})
+	syncAgent("test", GPSData{Lat: "45", Lon: "45", TS: "1020", Speed: "30"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/track?to=1010", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var track Track
+	err = json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if track.StartPlace != "near Main St & 5th" {
+		t.Error(errorMsg("StartPlace", "near Main St & 5th", track.StartPlace))
+		return
+	}
+
+	if track.EndPlace != "near Main St & 6th" {
+		t.Error(errorMsg("EndPlace", "near Main St & 6th", track.EndPlace))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/overspeed", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var overspeeds []repository.Overspeed
+	err = json.Unmarshal([]byte(res.Body.String()), &overspeeds)
+	if err != nil {
+		t.Error(errorMsg("Overspeeds", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(overspeeds); count != 1 {
+		t.Error(errorMsg("len(overspeeds)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if overspeeds[0].Place != "near Main St & 5th" {
+		t.Error(errorMsg("Place", "near Main St & 5th", overspeeds[0].Place))
+		return
+	}
+
+	// Far from every place in the gazetteer
+	vehicle, _ := repository.GetVehicleByPlateID("test")
+	if vehicle.Agent.Place != "" {
+		t.Error(errorMsg("Agent.Place", "", vehicle.Agent.Place))
+		return
+	}
+}
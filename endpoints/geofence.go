@@ -0,0 +1,169 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+// swagger:parameters GetAllGeofences
+type GetAllGeofencesParams struct {
+
+	// Format
+	//
+	// "geojson" returns a GeoJSON FeatureCollection of Polygons, as does
+	// `Accept: application/geo+json`.
+	//
+	// in: query
+	// required: false
+	// enum: json,geojson
+	Format string `json:"format"`
+}
+
+// swagger:route GET /geofence/ Geofences GetAllGeofences
+// Get all geofences in the database.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: GeofenceSuccessGeofencesResponse
+func GetAllGeofences(w http.ResponseWriter, req *http.Request) {
+	var geofences []repository.Geofence
+	geofences = repository.GetAllGeofences()
+
+	if wantsGeoJSON(req) {
+		sendGeoJSON(w, geofenceFeatureCollection(geofences))
+		return
+	}
+
+	j, err := json.Marshal(geofences)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters CreateNewGeofence
+type CreateNewGeofenceParams struct {
+
+	// Geofence
+	// in: body
+	// required: true
+	Geofence struct {
+
+		// Name
+		//
+		// required: true
+		Name string `json:"name" valid:"required"`
+
+		// Kind
+		//
+		// "ZONE" or "DEPOT"
+		//
+		// required: true
+		Kind string `json:"kind" valid:"required"`
+
+		// SpeedLimit in km/h, 0 for none
+		//
+		// required: false
+		SpeedLimit float64 `json:"speed_limit"`
+
+		// Polygon
+		//
+		// required: true
+		Polygon []geo.Point `json:"polygon"`
+	}
+}
+
+// swagger:route POST /geofence/ Geofences CreateNewGeofence
+// Create a new geofence.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: GeofenceSuccessGeofenceResponse
+func CreateNewGeofence(w http.ResponseWriter, req *http.Request) {
+	var params CreateNewGeofenceParams
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&params.Geofence); err != nil {
+		sendErrorMessage(w, "Error decoding the input", http.StatusBadRequest)
+		return
+	}
+	_, err := valid.ValidateStruct(params)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	geofence, err := repository.CreateGeofence(
+		params.Geofence.Name,
+		params.Geofence.Kind,
+		params.Geofence.SpeedLimit,
+		params.Geofence.Polygon,
+	)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(geofence)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters DeleteGeofence
+type DeleteGeofenceParams struct {
+
+	// GeofenceID
+	// in: path
+	// required: true
+	ID string `json:"geofence_id"`
+}
+
+// swagger:route DELETE /geofence/{geofence_id} Geofences DeleteGeofence
+// Delete a geofence.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: GeofenceSuccessGeofenceResponse
+func DeleteGeofence(w http.ResponseWriter, req *http.Request) {
+	params := DeleteGeofenceParams{ID: mux.Vars(req)["geofence_id"]}
+
+	geofenceID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "geofence_id should be int", http.StatusBadRequest)
+		return
+	}
+
+	geofence, err := repository.GetGeofenceByID(uint(geofenceID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = repository.DeleteGeofence(uint(geofenceID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(geofence)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
@@ -0,0 +1,122 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestCreateGeofenceEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	body := bytes.NewBufferString(`{
+		"name": "depot",
+		"kind": "DEPOT",
+		"polygon": [{"lat": 0, "lon": 0}, {"lat": 0, "lon": 1}, {"lat": 1, "lon": 1}]
+	}`)
+
+	// Execute
+	req, _ := http.NewRequest("POST", "/geofence/", body)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/geofence/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var geofences []repository.Geofence
+	err := json.Unmarshal([]byte(res.Body.String()), &geofences)
+	if err != nil {
+		t.Error(errorMsg("Geofences", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if geofences[0].Name != "depot" {
+		t.Error(errorMsg("Name", "depot", geofences[0].Name))
+		return
+	}
+
+	if count := len(geofences[0].Polygon); count != 3 {
+		t.Error(errorMsg("len(Polygon)", "3", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	body = bytes.NewBufferString(`{"name": "line", "kind": "ZONE", "polygon": [{"lat": 0, "lon": 0}]}`)
+	req, _ = http.NewRequest("POST", "/geofence/", body)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestDeleteGeofenceEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	geofence, _ := repository.CreateGeofence("zone", "ZONE", 0, []geo.Point{
+		{Lat: 0, Lon: 0}, {Lat: 0, Lon: 1}, {Lat: 1, Lon: 1},
+	})
+
+	// Execute
+	req, _ := http.NewRequest("DELETE", fmt.Sprintf("/geofence/%d", geofence.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if count := len(repository.GetAllGeofences()); count != 0 {
+		t.Error(errorMsg("len(geofences)", "0", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("DELETE", fmt.Sprintf("/geofence/%d", geofence.ID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 404 {
+		t.Error(errorMsg("StatusCode", "404", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
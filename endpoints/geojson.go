@@ -0,0 +1,122 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+const geoJSONContentType = "application/geo+json"
+
+// GeoJSON geometry, RFC 7946.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSON feature. Geometry is null for features without a location,
+// e.g. vehicles without an agent.
+type Feature struct {
+	Type       string      `json:"type"`
+	ID         interface{} `json:"id,omitempty"`
+	Geometry   *Geometry   `json:"geometry"`
+	Properties interface{} `json:"properties"`
+}
+
+// GeoJSON feature collection.
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// wantsGeoJSON reports whether the client asked for GeoJSON either with
+// `?format=geojson` or with an `Accept: application/geo+json` header.
+func wantsGeoJSON(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "geojson" {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), geoJSONContentType)
+}
+
+func sendGeoJSON(w http.ResponseWriter, v interface{}) {
+	j, err := json.Marshal(v)
+	checkErr(w, err)
+	sendContentType(w, geoJSONContentType)
+	w.Write(j)
+}
+
+func vehicleFeature(vehicle repository.Vehicle) Feature {
+	feature := Feature{
+		Type:       "Feature",
+		ID:         vehicle.PlateID,
+		Properties: vehicle,
+	}
+	if vehicle.Agent == nil {
+		return feature
+	}
+	p, ok := vehicle.Agent.Point()
+	if !ok {
+		return feature
+	}
+	feature.Geometry = &Geometry{Type: "Point", Coordinates: []float64{p.Lon, p.Lat}}
+	return feature
+}
+
+func vehicleFeatureCollection(vehicles []repository.Vehicle) FeatureCollection {
+	collection := FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, 0)}
+	for _, vehicle := range vehicles {
+		collection.Features = append(collection.Features, vehicleFeature(vehicle))
+	}
+	return collection
+}
+
+func trackFeature(track Track, positions []repository.Position) Feature {
+	coordinates := make([][]float64, 0)
+	timestamps := make([]string, 0)
+	for _, position := range positions {
+		coordinates = append(coordinates, []float64{position.Lon, position.Lat})
+		timestamps = append(timestamps, position.TS.Format(time.RFC3339))
+	}
+	return Feature{
+		Type:     "Feature",
+		Geometry: &Geometry{Type: "LineString", Coordinates: coordinates},
+		Properties: map[string]interface{}{
+			"plate_id":    track.PlateID,
+			"agent_uuid":  track.AgentUUID,
+			"start_place": track.StartPlace,
+			"end_place":   track.EndPlace,
+			"timestamps":  timestamps,
+		},
+	}
+}
+
+func geofenceFeature(geofence repository.Geofence) Feature {
+	ring := make([][]float64, 0)
+	for _, p := range geofence.Polygon {
+		ring = append(ring, []float64{p.Lon, p.Lat})
+	}
+	if len(ring) > 0 && (ring[0][0] != ring[len(ring)-1][0] || ring[0][1] != ring[len(ring)-1][1]) {
+		ring = append(ring, ring[0])
+	}
+	return Feature{
+		Type:     "Feature",
+		ID:       geofence.ID,
+		Geometry: &Geometry{Type: "Polygon", Coordinates: [][][]float64{ring}},
+		Properties: map[string]interface{}{
+			"name":        geofence.Name,
+			"kind":        geofence.Kind,
+			"speed_limit": geofence.SpeedLimit,
+		},
+	}
+}
+
+func geofenceFeatureCollection(geofences []repository.Geofence) FeatureCollection {
+	collection := FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, 0)}
+	for _, geofence := range geofences {
+		collection.Features = append(collection.Features, geofenceFeature(geofence))
+	}
+	return collection
+}
@@ -0,0 +1,189 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestGetAllVehiclesGeoJSON(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("test2", "", []int{}, "SOLAR-CAR")
+	syncAgent("test", GPSData{Lat: "41", Lon: "29", TS: "1000"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/geo+json")
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/geo+json" {
+		t.Error(errorMsg("Content-Type", "application/geo+json", contentType))
+		return
+	}
+
+	var collection struct {
+		Type     string
+		Features []struct {
+			Type     string
+			ID       string
+			Geometry *struct {
+				Type        string
+				Coordinates []float64
+			}
+			Properties repository.Vehicle
+		}
+	}
+	err := json.Unmarshal([]byte(res.Body.String()), &collection)
+	if err != nil {
+		t.Error(errorMsg("FeatureCollection", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if collection.Type != "FeatureCollection" {
+		t.Error(errorMsg("Type", "FeatureCollection", collection.Type))
+		return
+	}
+
+	if count := len(collection.Features); count != 2 {
+		t.Error(errorMsg("len(Features)", "2", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	feature := collection.Features[0]
+	if feature.Geometry == nil || feature.Geometry.Type != "Point" {
+		t.Error(errorMsg("Geometry.Type", "Point", fmt.Sprintf("%v", feature.Geometry)))
+		return
+	}
+
+	if c := feature.Geometry.Coordinates; c[0] != 29 || c[1] != 41 {
+		t.Error(errorMsg("Coordinates", "[29 41]", fmt.Sprintf("%v", c)))
+		return
+	}
+
+	if feature.Properties.Type != "SCHOOL-BUS" {
+		t.Error(errorMsg("Properties.Type", "SCHOOL-BUS", feature.Properties.Type))
+		return
+	}
+
+	if collection.Features[1].Geometry != nil {
+		t.Error(errorMsg("Geometry", "null", fmt.Sprintf("%v", collection.Features[1].Geometry)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/filter?vehicle_type=SOLAR-CAR&format=geojson", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	err = json.Unmarshal([]byte(res.Body.String()), &collection)
+	if err != nil {
+		t.Error(errorMsg("FeatureCollection", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(collection.Features); count != 1 {
+		t.Error(errorMsg("len(Features)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if collection.Features[0].ID != "test2" {
+		t.Error(errorMsg("ID", "test2", collection.Features[0].ID))
+		return
+	}
+}
+
+func TestGeofenceAndTrackGeoJSON(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateGeofence("zone", "ZONE", 30, []geo.Point{
+		{Lat: 0, Lon: 0}, {Lat: 0, Lon: 1}, {Lat: 1, Lon: 1},
+	})
+	syncAgent("test", GPSData{Lat: "41", Lon: "29", TS: "1000"})
+	syncAgent("test", GPSData{Lat: "42", Lon: "30", TS: "1010"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/geofence/?format=geojson", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var polygons struct {
+		Features []struct {
+			Geometry struct {
+				Type        string
+				Coordinates [][][]float64
+			}
+		}
+	}
+	err := json.Unmarshal([]byte(res.Body.String()), &polygons)
+	if err != nil {
+		t.Error(errorMsg("FeatureCollection", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	geometry := polygons.Features[0].Geometry
+	if geometry.Type != "Polygon" {
+		t.Error(errorMsg("Geometry.Type", "Polygon", geometry.Type))
+		return
+	}
+
+	if count := len(geometry.Coordinates[0]); count != 4 {
+		t.Error(errorMsg("len(ring)", "4", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/agent/test/track", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/geo+json")
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var line struct {
+		Geometry struct {
+			Type        string
+			Coordinates [][]float64
+		}
+	}
+	err = json.Unmarshal([]byte(res.Body.String()), &line)
+	if err != nil {
+		t.Error(errorMsg("Feature", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if line.Geometry.Type != "LineString" {
+		t.Error(errorMsg("Geometry.Type", "LineString", line.Geometry.Type))
+		return
+	}
+
+	if count := len(line.Geometry.Coordinates); count != 2 {
+		t.Error(errorMsg("len(Coordinates)", "2", fmt.Sprintf("%d", count)))
+		return
+	}
+}
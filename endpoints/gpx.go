@@ -0,0 +1,150 @@
+package endpoints
+
+import (
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+const gpxContentType = "application/gpx+xml"
+
+// maxTrackImportSize is the size of the largest GPX document imported,
+// about a week of fixes taken every second.
+const maxTrackImportSize = 64 << 20
+
+// GPX 1.1 document, only the parts used for tracks. The namespace isn't
+// enforced so that GPX 1.0 files can be imported too.
+type GPX struct {
+	XMLName xml.Name   `xml:"gpx"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Version string     `xml:"version,attr"`
+	Creator string     `xml:"creator,attr"`
+	Tracks  []GPXTrack `xml:"trk"`
+}
+
+type GPXTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Segments []GPXSegment `xml:"trkseg"`
+}
+
+type GPXSegment struct {
+	Points []GPXPoint `xml:"trkpt"`
+}
+
+type GPXPoint struct {
+	Lat  float64    `xml:"lat,attr"`
+	Lon  float64    `xml:"lon,attr"`
+	Time *time.Time `xml:"time"`
+}
+
+func trackGPX(track Track, positions []repository.Position) GPX {
+	name := track.PlateID
+	if name == "" {
+		name = track.AgentUUID
+	}
+	segment := GPXSegment{Points: make([]GPXPoint, 0)}
+	for i := range positions {
+		segment.Points = append(segment.Points, GPXPoint{
+			Lat:  positions[i].Lat,
+			Lon:  positions[i].Lon,
+			Time: &positions[i].TS,
+		})
+	}
+	return GPX{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "vehicle-tracker-api",
+		Tracks:  []GPXTrack{{Name: name, Segments: []GPXSegment{segment}}},
+	}
+}
+
+func sendXML(w http.ResponseWriter, contentType string, v interface{}) {
+	j, err := xml.MarshalIndent(v, "", "  ")
+	checkErr(w, err)
+	sendContentType(w, contentType)
+	w.Write([]byte(xml.Header))
+	w.Write(j)
+}
+
+// swagger:parameters ImportAgentTrack
+type ImportAgentTrackParams struct {
+
+	// UUID is an unique identifier across agents
+	// in: path
+	// required: true
+	UUID string `json:"uuid"`
+
+	// GPX 1.1 document, every track point must have a time.
+	// in: body
+	// required: true
+	GPX string
+}
+
+// swagger:route POST /agent/{uuid}/track Agents ImportAgentTrack
+// Import a GPX track into the history of an agent.
+//
+// Rejected with 409 if a track point collides with a recorded position,
+// and with 413 if the document is larger than 64 MiB.
+//
+//   Consumes:
+//   - application/gpx+xml
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: AgentSuccessEmptyResponse
+func ImportAgentTrack(w http.ResponseWriter, req *http.Request) {
+	params := ImportAgentTrackParams{UUID: mux.Vars(req)["uuid"]}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxTrackImportSize+1))
+	if err != nil {
+		sendErrorMessage(w, "Error reading the input", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxTrackImportSize {
+		sendErrorMessage(w, "The track is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var gpx GPX
+	if err := xml.Unmarshal(body, &gpx); err != nil {
+		sendErrorMessage(w, "Error decoding the input", http.StatusBadRequest)
+		return
+	}
+
+	positions := make([]repository.Position, 0)
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			for _, point := range segment.Points {
+				if point.Time == nil {
+					sendErrorMessage(w, "Every track point should have a time", http.StatusBadRequest)
+					return
+				}
+				positions = append(positions, repository.Position{
+					Lat: point.Lat,
+					Lon: point.Lon,
+					TS:  *point.Time,
+				})
+			}
+		}
+	}
+
+	err = repository.ImportAgentPositions(params.UUID, positions)
+	if err != nil {
+		status := http.StatusBadRequest
+		if agentErr, ok := err.(*repository.AgentError); ok && agentErr.Type == "Already-Exists" {
+			status = http.StatusConflict
+		}
+		sendErrorMessage(w, err.Error(), status)
+		return
+	}
+
+	sendContentType(w, "application/json")
+}
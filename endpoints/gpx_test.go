@@ -0,0 +1,228 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="logger" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><trkseg>
+    <trkpt lat="41.0" lon="29.0"><time>2017-09-01T08:00:00Z</time></trkpt>
+    <trkpt lat="41.1" lon="29.1"><time>2017-09-01T08:01:00Z</time></trkpt>
+    <trkpt lat="41.2" lon="29.2"><time>2017-09-01T08:02:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`
+
+func TestExportTrackGPXAndKML(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	syncAgent("test", GPSData{Lat: "41", Lon: "29", TS: "1504252800"})
+	syncAgent("test", GPSData{Lat: "41.5", Lon: "29.5", TS: "1504252860"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/track?format=gpx", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/gpx+xml" {
+		t.Error(errorMsg("Content-Type", "application/gpx+xml", contentType))
+		return
+	}
+
+	var gpx GPX
+	err := xml.Unmarshal(res.Body.Bytes(), &gpx)
+	if err != nil {
+		t.Error(errorMsg("GPX", "Unmarshallable", err.Error()))
+		return
+	}
+
+	if gpx.Xmlns != "http://www.topografix.com/GPX/1/1" {
+		t.Error(errorMsg("xmlns", "http://www.topografix.com/GPX/1/1", gpx.Xmlns))
+		return
+	}
+
+	points := gpx.Tracks[0].Segments[0].Points
+	if count := len(points); count != 2 {
+		t.Error(errorMsg("len(Points)", "2", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if !points[1].Time.Equal(time.Unix(1504252860, 0)) {
+		t.Error(errorMsg("Time", "2017-09-01T08:01:00Z", points[1].Time.String()))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/track?format=kml", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var kml KML
+	err = xml.Unmarshal(res.Body.Bytes(), &kml)
+	if err != nil {
+		t.Error(errorMsg("KML", "Unmarshallable", err.Error()))
+		return
+	}
+
+	if coordinates := kml.Document.Placemark.LineString.Coordinates; coordinates != "29,41 29.5,41.5" {
+		t.Error(errorMsg("Coordinates", "29,41 29.5,41.5", coordinates))
+		return
+	}
+}
+
+func TestImportAgentTrackEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	_, _ = repository.CreateNewAgent("logger")
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/agent/logger/track", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("Content-Type", "application/gpx+xml")
+		res := httptest.NewRecorder()
+		GetRouter().ServeHTTP(res, req)
+		return res
+	}
+
+	// Execute
+	res := post(testGPX)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	agent, _ := repository.GetAgentByUUID("logger")
+	positions := repository.GetAgentPositions(agent.ID, nil, time.Time{}, time.Time{})
+	if count := len(positions); count != 3 {
+		t.Error(errorMsg("len(positions)", "3", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if positions[1].Speed == 0 {
+		t.Error(errorMsg("Speed", "derived", "0"))
+		return
+	}
+
+	// Execute
+	res = post(testGPX)
+
+	// Test
+	if res.Code != 409 {
+		t.Error(errorMsg("StatusCode", "409", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = post(strings.Replace(testGPX, "08:02:00", "08:01:00", 1))
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = post(strings.Replace(testGPX, "<time>2017-09-01T08:00:00Z</time>", "", 1))
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if count := len(repository.GetAgentPositions(agent.ID, nil, time.Time{}, time.Time{})); count != 3 {
+		t.Error(errorMsg("len(positions)", "3", fmt.Sprintf("%d", count)))
+		return
+	}
+}
+
+func TestImportLargeAgentTrack(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/agent/logger/track", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		req.Header.Set("Content-Type", "application/gpx+xml")
+		res := httptest.NewRecorder()
+		GetRouter().ServeHTTP(res, req)
+		return res
+	}
+	track := func(start time.Time, count int) string {
+		var b bytes.Buffer
+		b.WriteString(`<gpx version="1.1"><trk><trkseg>`)
+		for i := 0; i < count; i++ {
+			ts := start.Add(time.Duration(i) * time.Second).Format(time.RFC3339)
+			fmt.Fprintf(&b, `<trkpt lat="41.0" lon="29.%05d"><time>%s</time></trkpt>`, i, ts)
+		}
+		b.WriteString(`</trkseg></trk></gpx>`)
+		return b.String()
+	}
+	start := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	// Execute
+	res := post(track(start, 40000))
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = post(track(start.Add(39999*time.Second), 2))
+
+	// Test
+	if res.Code != 409 {
+		t.Error(errorMsg("StatusCode", "409", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = post(strings.Repeat(" ", maxTrackImportSize+1))
+
+	// Test
+	if res.Code != 413 {
+		t.Error(errorMsg("StatusCode", "413", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	agent, _ := repository.GetAgentByUUID("logger")
+	if count := len(repository.GetAgentPositions(agent.ID, nil, time.Time{}, time.Time{})); count != 40000 {
+		t.Error(errorMsg("len(positions)", "40000", fmt.Sprintf("%d", count)))
+		return
+	}
+}
@@ -0,0 +1,71 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+// swagger:parameters GetVehicleIdles
+type GetVehicleIdlesParams struct {
+
+	// PlateID is a unique identifier across the vehicles
+	// in: path
+	// required: true
+	PlateID string `json:"plate_id"`
+
+	// From
+	//
+	// Only idles started at or after this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// Only idles started at or before this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+}
+
+// swagger:route GET /vehicle/{plate_id}/idle Vehicles GetVehicleIdles
+// Get idles of a vehicle.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessIdlesResponse
+func GetVehicleIdles(w http.ResponseWriter, req *http.Request) {
+	params := GetVehicleIdlesParams{PlateID: mux.Vars(req)["plate_id"]}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := visibleVehicle(w, req, params.PlateID); !ok {
+		return
+	}
+
+	idles, err := repository.GetIdlesByPlateID(params.PlateID, from, to)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(idles)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
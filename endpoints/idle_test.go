@@ -0,0 +1,124 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestVehicleIdleEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	for _, fix := range [][2]string{
+		{"1000", "40"}, {"1100", "0"}, {"1300", "0"}, {"1500", "0"}, {"1600", "30"},
+		{"1700", "0"}, {"1800", "40"},
+	} {
+		syncAgent("test", GPSData{Lat: "40", Lon: "40", TS: fix[0], Speed: fix[1]})
+	}
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/idle", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var idles []repository.Idle
+	err := json.Unmarshal([]byte(res.Body.String()), &idles)
+	if err != nil {
+		t.Error(errorMsg("Idles", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(idles); count != 1 {
+		t.Error(errorMsg("len(idles)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if idles[0].Duration != 500 {
+		t.Error(errorMsg("Duration", "500", fmt.Sprintf("%v", idles[0].Duration)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/stats", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var stats repository.VehicleStats
+	err = json.Unmarshal([]byte(res.Body.String()), &stats)
+	if err != nil {
+		t.Error(errorMsg("Stats", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if stats.IdleCount != 1 {
+		t.Error(errorMsg("IdleCount", "1", fmt.Sprintf("%d", stats.IdleCount)))
+		return
+	}
+
+	if stats.IdleDuration != 500 {
+		t.Error(errorMsg("IdleDuration", "500", fmt.Sprintf("%v", stats.IdleDuration)))
+		return
+	}
+
+	if stats.MaxSpeed != 40 {
+		t.Error(errorMsg("MaxSpeed", "40", fmt.Sprintf("%v", stats.MaxSpeed)))
+		return
+	}
+}
+
+func TestVehicleIdleInsideDepot(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	config.C.Idle.OutsideDepotsOnly = true
+	defer func() { config.C.Idle.OutsideDepotsOnly = false }()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	_, _ = repository.CreateGeofence("depot", "DEPOT", 0, []geo.Point{
+		{Lat: 39, Lon: 39}, {Lat: 41, Lon: 39}, {Lat: 41, Lon: 41}, {Lat: 39, Lon: 41},
+	})
+	for _, ts := range []string{"1000", "1500", "2000"} {
+		syncAgent("test", GPSData{Lat: "40", Lon: "40", TS: ts, Speed: "0"})
+	}
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/idle", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var idles []repository.Idle
+	err := json.Unmarshal([]byte(res.Body.String()), &idles)
+	if err != nil {
+		t.Error(errorMsg("Idles", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(idles); count != 0 {
+		t.Error(errorMsg("len(idles)", "0", fmt.Sprintf("%d", count)))
+		return
+	}
+}
@@ -0,0 +1,68 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+const kmlContentType = "application/vnd.google-earth.kml+xml"
+
+// KML 2.2 document with a single track placemark.
+type KML struct {
+	XMLName  xml.Name    `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Document KMLDocument `xml:"Document"`
+}
+
+type KMLDocument struct {
+	Name      string       `xml:"name"`
+	Placemark KMLPlacemark `xml:"Placemark"`
+}
+
+type KMLPlacemark struct {
+	Name       string         `xml:"name"`
+	TimeSpan   *KMLTimeSpan   `xml:"TimeSpan,omitempty"`
+	LineString *KMLLineString `xml:"LineString"`
+}
+
+type KMLTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type KMLLineString struct {
+	Tessellate  int    `xml:"tessellate"`
+	Coordinates string `xml:"coordinates"`
+}
+
+func trackKML(track Track, positions []repository.Position) KML {
+	name := track.PlateID
+	if name == "" {
+		name = track.AgentUUID
+	}
+
+	var coordinates bytes.Buffer
+	for i, position := range positions {
+		if i > 0 {
+			coordinates.WriteByte(' ')
+		}
+		coordinates.WriteString(strconv.FormatFloat(position.Lon, 'f', -1, 64))
+		coordinates.WriteByte(',')
+		coordinates.WriteString(strconv.FormatFloat(position.Lat, 'f', -1, 64))
+	}
+
+	placemark := KMLPlacemark{
+		Name:       name,
+		LineString: &KMLLineString{Tessellate: 1, Coordinates: coordinates.String()},
+	}
+	if len(positions) > 0 {
+		placemark.TimeSpan = &KMLTimeSpan{
+			Begin: positions[0].TS.Format(time.RFC3339),
+			End:   positions[len(positions)-1].TS.Format(time.RFC3339),
+		}
+	}
+	return KML{Document: KMLDocument{Name: name, Placemark: placemark}}
+}
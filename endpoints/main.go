@@ -28,8 +28,12 @@ package endpoints
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
 )
 
 type GenericError struct {
@@ -69,3 +73,29 @@ func checkErr(w http.ResponseWriter, err error) {
 		sendErrorMessage(w, err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// ParamError reports a query parameter that couldn't be parsed.
+type ParamError struct {
+	Name string
+	Type string
+}
+
+func (e ParamError) Error() string {
+	return fmt.Sprintf("%s should be %s", e.Name, e.Type)
+}
+
+// parseTimeRange reads the optional `from` and `to` query parameters.
+// Unset parameters are returned as zero times.
+func parseTimeRange(req *http.Request) (from time.Time, to time.Time, err error) {
+	if s := req.URL.Query().Get("from"); s != "" {
+		if from, err = repository.ParseTS(s); err != nil {
+			return
+		}
+	}
+	if s := req.URL.Query().Get("to"); s != "" {
+		if to, err = repository.ParseTS(s); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+// swagger:parameters GetVehicleOverspeeds
+type GetVehicleOverspeedsParams struct {
+
+	// PlateID is a unique identifier across the vehicles
+	// in: path
+	// required: true
+	PlateID string `json:"plate_id"`
+
+	// From
+	//
+	// Only overspeeds started at or after this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// Only overspeeds started at or before this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+}
+
+// swagger:route GET /vehicle/{plate_id}/overspeed Vehicles GetVehicleOverspeeds
+// Get overspeeds of a vehicle.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessOverspeedsResponse
+func GetVehicleOverspeeds(w http.ResponseWriter, req *http.Request) {
+	params := GetVehicleOverspeedsParams{PlateID: mux.Vars(req)["plate_id"]}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := visibleVehicle(w, req, params.PlateID); !ok {
+		return
+	}
+
+	overspeeds, err := repository.GetOverspeedsByPlateID(params.PlateID, from, to)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(overspeeds)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters GetGroupOverspeeds
+type GetGroupOverspeedsParams struct {
+
+	// GroupID
+	// in: path
+	// required: true
+	ID string `json:"group_id"`
+
+	// From
+	//
+	// Only overspeeds started at or after this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// Only overspeeds started at or before this time.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+}
+
+// swagger:route GET /vehicle/group/{group_id}/overspeed Vehicles GetGroupOverspeeds
+// Get overspeeds of every vehicle in a group.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessOverspeedsResponse
+func GetGroupOverspeeds(w http.ResponseWriter, req *http.Request) {
+	params := GetGroupOverspeedsParams{ID: mux.Vars(req)["group_id"]}
+
+	groupID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "group_id should be int", http.StatusBadRequest)
+		return
+	}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	overspeeds, err := repository.GetOverspeedsByGroupID(uint(groupID), restriction, from, to)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(overspeeds)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
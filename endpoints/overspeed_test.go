@@ -0,0 +1,234 @@
+package endpoints
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func syncAgent(uuid string, data GPSData) *httptest.ResponseRecorder {
+	j, _ := json.Marshal(&data)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/agent/%s/sync", uuid), bytes.NewBuffer(j))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+	return res
+}
+
+func TestVehicleOverspeedEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	config.C.Speeding.TypeLimits = map[string]float64{"SCHOOL-BUS": 50}
+	defer func() { config.C.Speeding.TypeLimits = nil }()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	for i, speed := range []string{"30", "70", "90", "40"} {
+		syncAgent("test", GPSData{Lat: "40", Lon: fmt.Sprintf("%d", 40+i), TS: fmt.Sprintf("%d", 1000+i*10), Speed: speed})
+	}
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/overspeed", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var overspeeds []repository.Overspeed
+	err := json.Unmarshal([]byte(res.Body.String()), &overspeeds)
+	if err != nil {
+		t.Error(errorMsg("Overspeeds", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(overspeeds); count != 1 {
+		t.Error(errorMsg("len(overspeeds)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if overspeeds[0].PeakSpeed != 90 {
+		t.Error(errorMsg("PeakSpeed", "90", fmt.Sprintf("%v", overspeeds[0].PeakSpeed)))
+		return
+	}
+
+	if overspeeds[0].Lon != 42 {
+		t.Error(errorMsg("Lon", "42", fmt.Sprintf("%v", overspeeds[0].Lon)))
+		return
+	}
+
+	if overspeeds[0].Duration != 20 {
+		t.Error(errorMsg("Duration", "20", fmt.Sprintf("%v", overspeeds[0].Duration)))
+		return
+	}
+
+	if overspeeds[0].EndedAt == nil {
+		t.Error(errorMsg("EndedAt", "not nil", "nil"))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/overspeed?from=1015", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	err = json.Unmarshal([]byte(res.Body.String()), &overspeeds)
+	if err != nil {
+		t.Error(errorMsg("Overspeeds", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(overspeeds); count != 0 {
+		t.Error(errorMsg("len(overspeeds)", "0", fmt.Sprintf("%d", count)))
+		return
+	}
+}
+
+func TestDerivedSpeedOverspeed(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	config.C.Speeding.TypeLimits = map[string]float64{"SCHOOL-BUS": 50}
+	defer func() { config.C.Speeding.TypeLimits = nil }()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	groupID, _ := repository.CreateNewGroup("test")
+	_ = repository.CreateVehicle("test", "test", []int{int(groupID)}, "SCHOOL-BUS")
+
+	// ~1.1km in 60s is ~67km/h
+	syncAgent("test", GPSData{Lat: "40.00", Lon: "40", TS: "1000"})
+	syncAgent("test", GPSData{Lat: "40.01", Lon: "40", TS: "1060"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/vehicle/group/%d/overspeed", groupID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var overspeeds []repository.Overspeed
+	err := json.Unmarshal([]byte(res.Body.String()), &overspeeds)
+	if err != nil {
+		t.Error(errorMsg("Overspeeds", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(overspeeds); count != 1 {
+		t.Error(errorMsg("len(overspeeds)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if peak := overspeeds[0].PeakSpeed; peak < 60 || peak > 70 {
+		t.Error(errorMsg("PeakSpeed", "~67", fmt.Sprintf("%v", peak)))
+		return
+	}
+
+	if overspeeds[0].EndedAt != nil {
+		t.Error(errorMsg("EndedAt", "nil", "not nil"))
+		return
+	}
+}
+
+func TestGeofenceOverspeed(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SOLAR-CAR")
+	geofence, _ := repository.CreateGeofence("school", "ZONE", 20, []geo.Point{
+		{Lat: 39, Lon: 39}, {Lat: 41, Lon: 39}, {Lat: 41, Lon: 41}, {Lat: 39, Lon: 41},
+	})
+	syncAgent("test", GPSData{Lat: "50", Lon: "50", TS: "1000", Speed: "30"})
+	syncAgent("test", GPSData{Lat: "40", Lon: "40", TS: "1010", Speed: "30"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/overspeed", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var overspeeds []repository.Overspeed
+	err := json.Unmarshal([]byte(res.Body.String()), &overspeeds)
+	if err != nil {
+		t.Error(errorMsg("Overspeeds", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(overspeeds); count != 1 {
+		t.Error(errorMsg("len(overspeeds)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if overspeeds[0].GeofenceID != geofence.ID {
+		t.Error(errorMsg("GeofenceID", fmt.Sprintf("%d", geofence.ID), fmt.Sprintf("%d", overspeeds[0].GeofenceID)))
+		return
+	}
+
+	if overspeeds[0].SpeedLimit != 20 {
+		t.Error(errorMsg("SpeedLimit", "20", fmt.Sprintf("%v", overspeeds[0].SpeedLimit)))
+		return
+	}
+}
+
+func TestStricterGeofenceOverspeed(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	config.C.Speeding.TypeLimits = map[string]float64{"SCHOOL-BUS": 90}
+	defer func() { config.C.Speeding.TypeLimits = nil }()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	geofence, _ := repository.CreateGeofence("school", "ZONE", 30, []geo.Point{
+		{Lat: 39, Lon: 39}, {Lat: 41, Lon: 39}, {Lat: 41, Lon: 41}, {Lat: 39, Lon: 41},
+	})
+	syncAgent("test", GPSData{Lat: "50", Lon: "50", TS: "1000", Speed: "100"})
+	syncAgent("test", GPSData{Lat: "40", Lon: "40", TS: "1010", Speed: "95"})
+	syncAgent("test", GPSData{Lat: "40", Lon: "40", TS: "1020", Speed: "20"})
+	token := viewerToken()
+
+	// Execute
+	res := authorized("GET", "/vehicle/test/overspeed", "", token)
+
+	// Test
+	var overspeeds []repository.Overspeed
+	if err := json.Unmarshal(res.Body.Bytes(), &overspeeds); err != nil || len(overspeeds) != 2 {
+		t.Error(errorMsg("Overspeeds", "2", res.Body.String()))
+		return
+	}
+
+	sort.Slice(overspeeds, func(i, j int) bool { return overspeeds[i].StartedAt.Before(overspeeds[j].StartedAt) })
+	before, inside := overspeeds[0], overspeeds[1]
+	if before.SpeedLimit != 90 || before.GeofenceID != 0 || before.EndedAt == nil || before.EndedAt.Unix() != 1010 {
+		t.Error(errorMsg("Overspeed before the school zone", "limit 90 ended at 1010", fmt.Sprintf("%+v", before)))
+		return
+	}
+
+	if inside.SpeedLimit != 30 || inside.GeofenceID != geofence.ID || inside.StartedAt.Unix() != 1010 || inside.EndedAt == nil || inside.PeakSpeed != 95 {
+		t.Error(errorMsg("Overspeed in the school zone", fmt.Sprintf("limit 30 of geofence %d from 1010", geofence.ID), fmt.Sprintf("%+v", inside)))
+		return
+	}
+}
@@ -0,0 +1,109 @@
+package endpoints
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/cad/vehicle-tracker-api/staticmap"
+)
+
+const pngContentType = "image/png"
+
+// MaxImageSize bounds the width and height of rendered images.
+const MaxImageSize = 2048
+
+var (
+	trackColor      = color.RGBA{33, 102, 172, 255}
+	startColor      = color.RGBA{26, 152, 80, 255}
+	endColor        = color.RGBA{215, 48, 39, 255}
+	stopColor       = color.RGBA{253, 174, 97, 255}
+	outlineColor    = color.RGBA{255, 255, 255, 255}
+	zoneFillColor   = color.RGBA{116, 169, 207, 64}
+	zoneStrokeColor = color.RGBA{54, 144, 192, 255}
+	depotFillColor  = color.RGBA{158, 154, 200, 64}
+	depotStroke     = color.RGBA{106, 81, 163, 255}
+)
+
+// stop is a period during which the track stood still.
+type stop struct {
+	geo.Point
+	StartedAt time.Time
+	EndedAt   time.Time
+}
+
+// findStops returns the periods of positions slower than the configured
+// idle speed that last at least the idle threshold.
+func findStops(positions []repository.Position) []stop {
+	params := config.C.Idle
+	stops := make([]stop, 0)
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if positions[end].TS.Sub(positions[start].TS).Seconds() >= params.Threshold {
+			stops = append(stops, stop{
+				Point:     positions[start].Point(),
+				StartedAt: positions[start].TS,
+				EndedAt:   positions[end].TS,
+			})
+		}
+		start = -1
+	}
+	for i, position := range positions {
+		if position.Speed <= params.Speed {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			flush(i)
+		}
+	}
+	if start >= 0 {
+		flush(len(positions) - 1)
+	}
+	return stops
+}
+
+// renderTrack draws the track with start and end markers, its stops and
+// the given geofences.
+func renderTrack(positions []repository.Position, stops []stop, geofences []repository.Geofence, width, height int) image.Image {
+	points := make([]geo.Point, len(positions))
+	for i := range positions {
+		points[i] = positions[i].Point()
+	}
+	m := staticmap.New(width, height, 24, geo.Bounds(points))
+
+	for _, geofence := range geofences {
+		if geofence.Kind == repository.GEOFENCE_DEPOT {
+			m.Polygon(geofence.Polygon, depotFillColor, depotStroke)
+		} else {
+			m.Polygon(geofence.Polygon, zoneFillColor, zoneStrokeColor)
+		}
+	}
+
+	m.Line(points, 5, outlineColor)
+	m.Line(points, 3, trackColor)
+	for _, stop := range stops {
+		m.Marker(stop.Point, 5, stopColor, outlineColor)
+	}
+	if len(points) > 0 {
+		m.Marker(points[0], 7, startColor, outlineColor)
+		m.Marker(points[len(points)-1], 7, endColor, outlineColor)
+	}
+	return m.Image()
+}
+
+func sendPNG(w http.ResponseWriter, img image.Image) {
+	sendContentType(w, pngContentType)
+	err := png.Encode(w, img)
+	checkErr(w, err)
+}
@@ -0,0 +1,406 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	REPLAY_PAUSE  = "pause"
+	REPLAY_RESUME = "resume"
+	REPLAY_SEEK   = "seek"
+	REPLAY_SPEED  = "speed"
+)
+
+const (
+	REPLAY_FINISHED = "finished"
+	REPLAY_ERROR    = "error"
+)
+
+// swagger:parameters ReplayVehiclesWS
+type ReplayVehiclesWSParams struct {
+
+	// PlateID
+	//
+	// Comma separated plate ids of the vehicles to replay.
+	//
+	// in: query
+	// required: true
+	PlateID string `json:"plate_id"`
+
+	// From
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: true
+	From string `json:"from"`
+
+	// To
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: true
+	To string `json:"to"`
+
+	// Speed
+	//
+	// Playback speed multiplier, 1 by default.
+	//
+	// in: query
+	// required: false
+	Speed float64 `json:"speed"`
+
+	// Token
+	//
+	// User token, for clients that can't send the Authorization header.
+	//
+	// in: query
+	// required: false
+	Token string `json:"token"`
+}
+
+// ReplayCommand is sent by the client to control a replay.
+//
+// e.g. {"command": "seek", "ts": "1504252800"}
+type ReplayCommand struct {
+	// pause, resume, seek or speed
+	Command string `json:"command"`
+
+	// Replay time to seek to, for seek.
+	TS string `json:"ts"`
+
+	// New playback speed multiplier, for speed.
+	Speed float64 `json:"speed"`
+}
+
+// ReplayStatus is sent to the client when the replay reaches the end of
+// the positions, and when a command can't be carried out.
+//
+// e.g. {"status": "error", "message": "ts should be a unix timestamp or RFC3339"}
+type ReplayStatus struct {
+	// finished or error
+	Status string `json:"status"`
+
+	// Why the command failed, for error.
+	Message string `json:"message,omitempty"`
+}
+
+// replayFrame is a stored position along with the vehicle and agent it
+// was recorded for.
+type replayFrame struct {
+	position repository.Position
+	vehicle  repository.Vehicle
+	agent    repository.Agent
+}
+
+// message returns the frame in the shape of a live vehicle update.
+func (f replayFrame) message() repository.Vehicle {
+	vehicle := f.vehicle
+	agent := f.agent
+	agent.Lat = strconv.FormatFloat(f.position.Lat, 'f', -1, 64)
+	agent.Lon = strconv.FormatFloat(f.position.Lon, 'f', -1, 64)
+	agent.TS = strconv.FormatInt(f.position.TS.Unix(), 10)
+	agent.UpdatedAt = f.position.TS
+	agent.Place = geocode.Describe(f.position.Point())
+	vehicle.Agent = &agent
+	return vehicle
+}
+
+type byFrameTS []replayFrame
+
+func (f byFrameTS) Len() int           { return len(f) }
+func (f byFrameTS) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byFrameTS) Less(i, j int) bool { return f[i].position.TS.Before(f[j].position.TS) }
+
+// loadReplayFrames returns the positions of the vehicles between from and
+// to, oldest first. Vehicles the restriction doesn't let through are not
+// found.
+func loadReplayFrames(plateIDs []string, from, to time.Time, restriction repository.VehicleFilter) ([]replayFrame, error) {
+	frames := make([]replayFrame, 0)
+	agents := map[uint]repository.Agent{}
+	for _, plateID := range plateIDs {
+		vehicle, err := repository.GetVehicleByPlateID(plateID)
+		if err != nil {
+			return nil, err
+		}
+		if !restriction.Match(vehicle) {
+			return nil, &repository.VehicleError{What: "Vehicle", Type: "Not-Found", Arg: plateID}
+		}
+		for _, position := range repository.GetVehiclePositions(vehicle.ID, from, to) {
+			agent, ok := agents[position.AgentID]
+			if !ok {
+				agent, _ = repository.GetAgentByID(position.AgentID)
+				agents[position.AgentID] = agent
+			}
+			frames = append(frames, replayFrame{position: position, vehicle: vehicle, agent: agent})
+		}
+	}
+	sort.Stable(byFrameTS(frames))
+	return frames, nil
+}
+
+// replay plays frames back on c at speed times real time, following the
+// commands read from the connection.
+type replay struct {
+	c      *websocket.Conn
+	frames []replayFrame
+	speed  float64
+	paused bool
+
+	// index of the next frame to send and the replay time.
+	index int
+	clock time.Time
+
+	// sessionID is the sign-in of the user, checked on every tick of
+	// checks.
+	sessionID    uint
+	checks       <-chan time.Time
+	writeTimeout time.Duration
+}
+
+func (r *replay) send(frame replayFrame) error {
+	return r.c.WriteJSON(frame.message())
+}
+
+func (r *replay) sendStatus(status string, message string) error {
+	return r.c.WriteJSON(ReplayStatus{Status: status, Message: message})
+}
+
+// seek moves the replay to ts and sends the last position of every
+// vehicle at that time so the client can redraw the map.
+func (r *replay) seek(ts time.Time) error {
+	r.clock = ts
+	r.index = sort.Search(len(r.frames), func(i int) bool {
+		return r.frames[i].position.TS.After(ts)
+	})
+
+	latest := map[uint]int{}
+	order := make([]uint, 0)
+	for i := 0; i < r.index; i++ {
+		vehicleID := r.frames[i].vehicle.ID
+		if _, ok := latest[vehicleID]; !ok {
+			order = append(order, vehicleID)
+		}
+		latest[vehicleID] = i
+	}
+	for _, vehicleID := range order {
+		if err := r.send(r.frames[latest[vehicleID]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validSpeed reports whether speed is a positive number, which NaN and
+// infinities, accepted by strconv.ParseFloat, aren't.
+func validSpeed(speed float64) bool {
+	return speed > 0 && !math.IsNaN(speed) && !math.IsInf(speed, 0)
+}
+
+func (r *replay) handle(command ReplayCommand) error {
+	switch command.Command {
+	case REPLAY_PAUSE:
+		r.paused = true
+	case REPLAY_RESUME:
+		r.paused = false
+	case REPLAY_SPEED:
+		if !validSpeed(command.Speed) {
+			return r.sendStatus(REPLAY_ERROR, (&ParamError{Name: "speed", Type: "a positive number"}).Error())
+		}
+		r.speed = command.Speed
+	case REPLAY_SEEK:
+		ts, err := repository.ParseTS(command.TS)
+		if err != nil {
+			return r.sendStatus(REPLAY_ERROR, (&ParamError{Name: "ts", Type: "a unix timestamp or RFC3339"}).Error())
+		}
+		return r.seek(ts)
+	default:
+		return r.sendStatus(REPLAY_ERROR, fmt.Sprintf("command should be %s, %s, %s or %s", REPLAY_PAUSE, REPLAY_RESUME, REPLAY_SEEK, REPLAY_SPEED))
+	}
+	return nil
+}
+
+// advance moves the replay time by the time played since started, up to
+// the next frame.
+func (r *replay) advance(started time.Time) {
+	elapsed := time.Duration(float64(time.Since(started)) * r.speed)
+	if next := r.frames[r.index].position.TS; r.clock.Add(elapsed).After(next) {
+		r.clock = next
+	} else {
+		r.clock = r.clock.Add(elapsed)
+	}
+}
+
+// run plays the frames until the client disconnects. Once the last frame
+// is sent the replay waits for commands, so that the client can seek back.
+func (r *replay) run(commands <-chan ReplayCommand) {
+	if len(r.frames) == 0 {
+		if err := r.sendStatus(REPLAY_FINISHED, ""); err != nil {
+			return
+		}
+	}
+	for {
+		var timer <-chan time.Time
+		started := time.Now()
+		playing := !r.paused && r.index < len(r.frames)
+		if playing {
+			wait := r.frames[r.index].position.TS.Sub(r.clock)
+			timer = time.After(time.Duration(float64(wait) / r.speed))
+		}
+
+		select {
+		case command, ok := <-commands:
+			if !ok {
+				return
+			}
+			if playing {
+				r.advance(started)
+			}
+			if err := r.handle(command); err != nil {
+				log.Println("[WS-REPLAY] Can't write to WS Connection!. Stopping.")
+				return
+			}
+		case <-r.checks:
+			if playing {
+				r.advance(started)
+			}
+			if err := checkSession(r.sessionID); err != nil {
+				log.Println("[WS-REPLAY] Session ended. Stopping.")
+				rejectWS(r.c, err.Error(), r.writeTimeout)
+				return
+			}
+		case <-timer:
+			frame := r.frames[r.index]
+			r.clock = frame.position.TS
+			r.index++
+			err := r.send(frame)
+			if err == nil && r.index == len(r.frames) {
+				err = r.sendStatus(REPLAY_FINISHED, "")
+			}
+			if err != nil {
+				log.Println("[WS-REPLAY] Can't write to WS Connection!. Stopping.")
+				return
+			}
+		}
+	}
+}
+
+// swagger:route GET /ws/vehicle/replay WebSocket ReplayVehiclesWS
+// WebSocket Endpoint for replaying the stored positions of vehicles.
+//
+// Positions are streamed in the same shape as FilterVehiclesWS updates.
+// The client controls the replay by sending ReplayCommand messages, and
+// is sent a ReplayStatus when a command fails. Once the last position is
+// sent a finished ReplayStatus follows, and the replay stays open for the
+// client to seek back until it closes the connection.
+//
+// Clients authenticate with a user token in the Authorization header, the
+// token query parameter or the bearer subprotocol. Users restricted to
+// groups can only replay the vehicles of those groups. The replay is
+// closed once the session of the token is signed out or expires.
+//
+// e.g. wss://api.vehicles.neu.edu.tr/ws/vehicle/replay?plate_id=34AB123,34CD456&from=1504252800&to=1504256400&speed=10
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessVehicleResponse
+//
+func ReplayVehiclesWS(w http.ResponseWriter, req *http.Request) {
+	user, sessionID, ok := wsUser(w, req)
+	if !ok {
+		return
+	}
+	restriction := repository.VehicleFilter{}
+	if user != nil {
+		restriction = user.VehicleFilter()
+	} else if !config.C.Server.AnonymousRead {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	q := req.URL.Query()
+	if q.Get("plate_id") == "" {
+		sendErrorMessage(w, "plate_id is required", http.StatusBadRequest)
+		return
+	}
+	if q.Get("from") == "" || q.Get("to") == "" {
+		sendErrorMessage(w, "from and to are required", http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	speed := 1.0
+	if s := q.Get("speed"); s != "" {
+		if speed, err = strconv.ParseFloat(s, 64); err != nil || !validSpeed(speed) {
+			sendErrorMessage(w, (&ParamError{Name: "speed", Type: "a positive number"}).Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	frames, err := loadReplayFrames(queryList(req, "plate_id"), from, to, restriction)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	r := replay{frames: frames, speed: speed, clock: from, sessionID: sessionID, writeTimeout: seconds(config.C.WebSocket.WriteTimeout)}
+	if len(frames) > 0 {
+		r.clock = frames[0].position.TS
+	}
+	if sessionID != 0 {
+		checks, stopChecks := streamChecks()
+		defer stopChecks()
+		r.checks = checks
+	}
+
+	c, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	defer c.Close()
+	r.c = c
+
+	commands := make(chan ReplayCommand)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(commands)
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				log.Println("Client disconnected")
+				return
+			}
+			// Commands that can't be parsed are sent on empty, to be
+			// answered with an error like unknown ones.
+			var command ReplayCommand
+			if err := json.Unmarshal(message, &command); err != nil {
+				command = ReplayCommand{}
+			}
+			select {
+			case commands <- command:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	r.run(commands)
+}
@@ -0,0 +1,205 @@
+package endpoints
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
+)
+
+func prepareReplay() {
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("vehicle1", "agent1", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("vehicle2", "agent2", []int{}, "SCHOOL-BUS")
+	syncAgent("agent1", GPSData{Lat: "41", Lon: "29", TS: "1000"})
+	syncAgent("agent2", GPSData{Lat: "40", Lon: "28", TS: "1001"})
+	syncAgent("agent1", GPSData{Lat: "41.01", Lon: "29.01", TS: "1002"})
+}
+
+func dialReplay(server *httptest.Server, query string) (*websocket.Conn, error) {
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/vehicle/replay?token=" + viewerToken() + "&" + query
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	return c, err
+}
+
+// readReplay reads the frames sent until the replay is finished.
+func readReplay(c *websocket.Conn) []string {
+	received := make([]string, 0)
+	for {
+		var message struct {
+			repository.Vehicle
+			ReplayStatus
+		}
+		if err := c.ReadJSON(&message); err != nil || message.Status == REPLAY_FINISHED {
+			return received
+		}
+		received = append(received, fmt.Sprintf("%s@%s", message.PlateID, message.Agent.TS))
+	}
+}
+
+func TestReplayVehiclesWS(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	prepareReplay()
+	c, err := dialReplay(server, "plate_id=vehicle1,vehicle2&from=0&to=2000&speed=1000")
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Execute
+	received := readReplay(c)
+
+	// Test
+	if order := strings.Join(received, " "); order != "vehicle1@1000 vehicle2@1001 vehicle1@1002" {
+		t.Error(errorMsg("Replay", "vehicle1@1000 vehicle2@1001 vehicle1@1002", order))
+		return
+	}
+}
+
+func TestReplayVehiclesWSSeek(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	prepareReplay()
+	c, err := dialReplay(server, "plate_id=vehicle1,vehicle2&from=0&to=2000&speed=0.0001")
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Execute
+	var start repository.Vehicle
+	c.ReadJSON(&start)
+	c.WriteJSON(ReplayCommand{Command: REPLAY_SEEK, TS: "1001"})
+	var first, second repository.Vehicle
+	c.ReadJSON(&first)
+	c.ReadJSON(&second)
+
+	// Test
+	if first.PlateID != "vehicle1" || first.Agent.Lat != "41" {
+		t.Error(errorMsg("First", "vehicle1 at 41", fmt.Sprintf("%s at %s", first.PlateID, first.Agent.Lat)))
+		return
+	}
+
+	if second.PlateID != "vehicle2" || second.Agent.TS != "1001" {
+		t.Error(errorMsg("Second", "vehicle2@1001", fmt.Sprintf("%s@%s", second.PlateID, second.Agent.TS)))
+		return
+	}
+
+	// Execute
+	c.WriteJSON(ReplayCommand{Command: REPLAY_SPEED, Speed: 1000})
+	var third repository.Vehicle
+	err = c.ReadJSON(&third)
+
+	// Test
+	if err != nil || third.Agent.TS != "1002" {
+		t.Error(errorMsg("Third", "vehicle1@1002", fmt.Sprintf("%s@%s", third.PlateID, third.Agent.TS)))
+		return
+	}
+}
+
+func TestReplayVehiclesWSNotFound(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Execute
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/vehicle/replay?plate_id=none&from=0&to=2000&token=" + viewerToken()
+	_, res, err := websocket.DefaultDialer.Dial(url, nil)
+
+	// Test
+	if err == nil || res.StatusCode != 404 {
+		t.Error(errorMsg("StatusCode", "404", fmt.Sprintf("%v", err)))
+		return
+	}
+}
+
+func TestReplayVehiclesWSInvalidSpeed(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	prepareReplay()
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/vehicle/replay?plate_id=vehicle1&from=0&to=2000&token=" + viewerToken()
+
+	for _, speed := range []string{"0", "-1", "NaN", "Inf", "-Inf"} {
+		// Execute
+		_, res, err := websocket.DefaultDialer.Dial(url+"&speed="+speed, nil)
+
+		// Test
+		if err == nil || res.StatusCode != 400 {
+			t.Error(errorMsg("StatusCode for speed "+speed, "400", fmt.Sprintf("%v", err)))
+			return
+		}
+	}
+}
+
+func TestReplayVehiclesWSAfterEnd(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	prepareReplay()
+	c, err := dialReplay(server, "plate_id=vehicle1&from=0&to=2000&speed=1000")
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readReplay(c)
+
+	// Execute
+	c.WriteMessage(websocket.TextMessage, []byte(`{"command": "seek", "ts":`))
+	var status ReplayStatus
+	err = c.ReadJSON(&status)
+
+	// Test
+	if err != nil || status.Status != REPLAY_ERROR {
+		t.Error(errorMsg("Status", REPLAY_ERROR, fmt.Sprintf("%+v %v", status, err)))
+		return
+	}
+
+	// Execute
+	c.WriteJSON(ReplayCommand{Command: REPLAY_SEEK, TS: "999"})
+	received := readReplay(c)
+
+	// Test
+	if order := strings.Join(received, " "); order != "vehicle1@1000 vehicle1@1002" {
+		t.Error(errorMsg("Replay", "vehicle1@1000 vehicle1@1002", order))
+		return
+	}
+}
@@ -28,3 +28,19 @@ type AuthSuccessOKResponse struct {
 	// in: body
 	Body AuthorizationCheckResponsePayload
 }
+
+// Returns a session
+// swagger:response
+type SessionSuccessSessionResponse struct {
+	// Session
+	// in: body
+	Body repository.Session
+}
+
+// Returns sessions
+// swagger:response
+type SessionSuccessSessionsResponse struct {
+	// Sessions
+	// in: body
+	Body []repository.Session
+}
@@ -0,0 +1,21 @@
+package endpoints
+
+import (
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// Returns a geofence
+// swagger:response
+type GeofenceSuccessGeofenceResponse struct {
+	// Geofence
+	// in: body
+	Body repository.Geofence
+}
+
+// Returns list of geofences
+// swagger:response
+type GeofenceSuccessGeofencesResponse struct {
+	// Geofences
+	// in: body
+	Body []repository.Geofence
+}
@@ -0,0 +1,9 @@
+package endpoints
+
+// Returns a track
+// swagger:response
+type TrackSuccessTrackResponse struct {
+	// Track
+	// in: body
+	Body Track
+}
@@ -43,3 +43,35 @@ type VehicleSuccessVehicleTypesResponse struct {
 	// in: body
 	Body []string
 }
+
+// Returns list of overspeeds
+// swagger:response
+type VehicleSuccessOverspeedsResponse struct {
+	// Overspeeds
+	// in: body
+	Body []repository.Overspeed
+}
+
+// Returns list of idles
+// swagger:response
+type VehicleSuccessIdlesResponse struct {
+	// Idles
+	// in: body
+	Body []repository.Idle
+}
+
+// Returns vehicle stats
+// swagger:response
+type VehicleSuccessStatsResponse struct {
+	// Stats
+	// in: body
+	Body repository.VehicleStats
+}
+
+// Returns vehicle clusters
+// swagger:response
+type VehicleSuccessClustersResponse struct {
+	// Clusters
+	// in: body
+	Body VehicleClusters
+}
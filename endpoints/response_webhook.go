@@ -0,0 +1,54 @@
+package endpoints
+
+import (
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// WebhookCreated is a new webhook with its secret, which isn't returned
+// afterwards.
+type WebhookCreated struct {
+	repository.Webhook
+
+	// Secret the payloads are signed with
+	Secret string `json:"secret"`
+}
+
+// Returns a new webhook
+// swagger:response
+type WebhookSuccessCreatedResponse struct {
+	// Webhook
+	// in: body
+	Body WebhookCreated
+}
+
+// Returns a webhook
+// swagger:response
+type WebhookSuccessWebhookResponse struct {
+	// Webhook
+	// in: body
+	Body repository.Webhook
+}
+
+// Returns list of webhooks
+// swagger:response
+type WebhookSuccessWebhooksResponse struct {
+	// Webhooks
+	// in: body
+	Body []repository.Webhook
+}
+
+// Returns a webhook delivery
+// swagger:response
+type WebhookSuccessDeliveryResponse struct {
+	// Delivery
+	// in: body
+	Body repository.WebhookDelivery
+}
+
+// Returns list of webhook deliveries
+// swagger:response
+type WebhookSuccessDeliveriesResponse struct {
+	// Deliveries
+	// in: body
+	Body []repository.WebhookDelivery
+}
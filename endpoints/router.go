@@ -8,6 +8,7 @@ import (
 
 	"github.com/cad/statik/fs"
 	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/repository"
 	_ "github.com/cad/vehicle-tracker-api/statik"
 	"github.com/gorilla/mux"
 )
@@ -23,42 +24,89 @@ func use(h http.HandlerFunc, middleware ...func(http.HandlerFunc) http.HandlerFu
 func GetRouter() http.Handler {
 	router := mux.NewRouter()
 
+	// Permissions of the routes that need a token, checked once it's
+	// authenticated. The routes that read need the read permission, see
+	// ReadAuthMiddleware, and the streams check it themselves.
+	read := RequirePermission(repository.PERMISSION_READ)
+	manageFleet := RequirePermission(repository.PERMISSION_MANAGE_FLEET)
+	manageUsers := RequirePermission(repository.PERMISSION_MANAGE_USERS)
+	manageWebhooks := RequirePermission(repository.PERMISSION_MANAGE_WEBHOOKS)
+
 	// Apply CORS to all preflight (OPTIONS) request.
 	router.Methods("OPTIONS").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		doCORS(w, r)
 	})
 
 	// Users
-	router.HandleFunc("/user/", use(GetAllUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/user/", use(CreateNewUser, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
-	router.HandleFunc("/user/{uuid}", use(GetUser, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/user/{uuid}", use(DeleteUser, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/user/", use(GetAllUsers, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/user/", use(CreateNewUser, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/user/{uuid}", use(GetUser, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/user/{uuid}", use(DeleteUser, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/user/{uuid}/groups", use(SetUserGroups, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("PUT")
+	router.HandleFunc("/user/{uuid}/role", use(SetUserRole, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("PUT")
 
 	// Auth
-	router.HandleFunc("/auth/", use(CheckAuth, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/auth/", use(CheckAuth, read, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
 	router.HandleFunc("/auth/", use(Authorize, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/auth/", use(Logout, read, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/auth/session/", use(GetSessions, read, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/auth/session/{session_id}", use(RevokeSession, read, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
 
 	// Agents
-	router.HandleFunc("/agent/", use(FilterAgents, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/agent/", use(FilterAgents, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
 	router.HandleFunc("/agent/{uuid}/sync", use(SyncAgent, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/agent/{uuid}/track", use(GetAgentTrack, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/agent/{uuid}/track", use(ImportAgentTrack, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
 	router.HandleFunc("/agents/{uuid}/sync", use(SyncAgent, CORSMiddleware)).Methods("POST") // NOTE(cad): this line added for backwards compatibility
 
 	// Vehicles
-	router.HandleFunc("/vehicle/", use(GetAllVehicles, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/vehicle/filter", use(FilterVehicles, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/vehicle/", use(CreateNewVehicle, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
-	router.HandleFunc("/vehicle/group/", use(GetAllGroups, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/vehicle/group/", use(CreateNewGroup, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
-	router.HandleFunc("/vehicle/group/{group_id}", use(DeleteGroup, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
-	router.HandleFunc("/vehicle/{plate_id}/agent", use(VehicleSetAgent, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
-	router.HandleFunc("/vehicle/{plate_id}/agent", use(VehicleUnsetAgent, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
-	router.HandleFunc("/vehicle/{plate_id}/groups", use(SetVehicleGroups, TokenAuthMiddleware, CORSMiddleware)).Methods("PUT")
-
-	router.HandleFunc("/vehicle/{plate_id}", use(GetVehicle, CORSMiddleware)).Methods("GET")
-	router.HandleFunc("/vehicle/{plate_id}", use(DeleteVehicle, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
-	router.HandleFunc("/vehicle/type/", use(GetAllTypes, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/", use(GetAllVehicles, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/filter", use(FilterVehicles, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/cluster", use(ClusterVehicles, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/", use(CreateNewVehicle, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/vehicle/group/", use(GetAllGroups, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/group/", use(CreateNewGroup, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/vehicle/group/{group_id}", use(DeleteGroup, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/vehicle/group/{group_id}/overspeed", use(GetGroupOverspeeds, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/{plate_id}/agent", use(VehicleSetAgent, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/vehicle/{plate_id}/agent", use(VehicleUnsetAgent, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/vehicle/{plate_id}/groups", use(SetVehicleGroups, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("PUT")
+	router.HandleFunc("/vehicle/{plate_id}/overspeed", use(GetVehicleOverspeeds, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/{plate_id}/idle", use(GetVehicleIdles, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/{plate_id}/stats", use(GetVehicleStats, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/{plate_id}/track", use(GetVehicleTrack, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+
+	router.HandleFunc("/vehicle/{plate_id}", use(GetVehicle, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/vehicle/{plate_id}", use(DeleteVehicle, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/vehicle/type/", use(GetAllTypes, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+
+	// Geofences
+	router.HandleFunc("/geofence/", use(GetAllGeofences, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/geofence/", use(CreateNewGeofence, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/geofence/{geofence_id}", use(DeleteGeofence, manageFleet, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+
+	// Webhooks
+	router.HandleFunc("/webhook/", use(GetAllWebhooks, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/webhook/", use(CreateNewWebhook, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/webhook/delivery/dead", use(GetDeadWebhookDeliveries, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/webhook/delivery/{delivery_id}/retry", use(RetryWebhookDelivery, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("POST")
+	router.HandleFunc("/webhook/{webhook_id}", use(DeleteWebhook, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("DELETE")
+	router.HandleFunc("/webhook/{webhook_id}/delivery", use(GetWebhookDeliveries, manageWebhooks, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+
+	// Tiles
+	router.HandleFunc("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", use(GetTile, ReadAuthMiddleware, CORSMiddleware)).Methods("GET")
+
 	// WebSocket
 	router.HandleFunc("/ws/vehicle/filter", use(FilterVehiclesWS, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/ws/stream", use(Stream, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/ws/vehicle/replay", use(ReplayVehiclesWS, CORSMiddleware)).Methods("GET")
+
+	// Events
+	router.HandleFunc("/events", use(GetEvents, manageUsers, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+	router.HandleFunc("/events/stats", use(GetEventStats, read, TokenAuthMiddleware, CORSMiddleware)).Methods("GET")
+
+	// Server-Sent Events
+	router.HandleFunc("/sse/vehicle/filter", use(FilterVehiclesSSE, CORSMiddleware)).Methods("GET")
 
 	dataFS, err := fs.New("/")
 	if err != nil {
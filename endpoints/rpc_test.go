@@ -0,0 +1,202 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/cad/vehicle-tracker-api/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// rpcContext returns a context authenticating the calls with the token.
+func rpcContext(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+// rpcClient serves the gRPC API in memory and returns a client of it.
+func rpcClient() (rpc.TrackerClient, func()) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := rpc.NewServer()
+	go server.Serve(listener)
+	conn, _ := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	return rpc.NewTrackerClient(conn), func() {
+		conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}
+}
+
+func TestRPCQueries(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	client, stop := rpcClient()
+	defer stop()
+
+	// Prepare
+	syncAgent("agent1", GPSData{Lat: "35.1", Lon: "33.9", TS: "1500000000"})
+	syncAgent("agent2", GPSData{Lat: "35.2", Lon: "33.9", TS: "1500000000"})
+	groupID, _ := repository.CreateNewGroup("group1")
+	repository.CreateVehicle("bus1", "agent1", []int{int(groupID)}, repository.SCHOOL_BUS)
+	repository.CreateVehicle("car1", "agent2", nil, repository.SOLAR_CAR)
+	admin, _ := repository.CreateNewUser("admin@test.com", "1234", repository.ROLE_ADMIN)
+	adminToken, _ := admin.RenewToken()
+
+	// Execute
+	_, err := client.ListVehicles(context.Background(), &rpc.VehicleFilter{})
+
+	// Test
+	if status.Code(err) != codes.Unauthenticated {
+		t.Error(errorMsg("Anonymous ListVehicles", "Unauthenticated", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	vehicles, err := client.ListVehicles(rpcContext(adminToken), &rpc.VehicleFilter{VehicleGroupId: []uint32{uint32(groupID)}})
+
+	// Test
+	if err != nil || len(vehicles.Vehicles) != 1 {
+		t.Error(errorMsg("Vehicles", "bus1", fmt.Sprintf("%v %v", vehicles, err)))
+		return
+	}
+
+	if vehicle := vehicles.Vehicles[0]; vehicle.PlateId != "bus1" || vehicle.Agent.GetUuid() != "agent1" || len(vehicle.Groups) != 1 || vehicle.Groups[0].Name != "group1" {
+		t.Error(errorMsg("Vehicle", "bus1 of agent1 in group1", vehicle.String()))
+		return
+	}
+
+	// Execute
+	_, err = client.GetVehicle(rpcContext(adminToken), &rpc.GetVehicleRequest{PlateId: "van1"})
+
+	// Test
+	if err == nil {
+		t.Error(errorMsg("GetVehicle", "Not found", "Found"))
+		return
+	}
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	token, _ := user.RenewToken()
+	_, _ = repository.SetUserGroups(user.UUID, []int{int(groupID)})
+
+	// Execute
+	vehicles, err = client.ListVehicles(rpcContext(token), &rpc.VehicleFilter{})
+
+	// Test
+	if err != nil || len(vehicles.Vehicles) != 1 || vehicles.Vehicles[0].PlateId != "bus1" {
+		t.Error(errorMsg("Restricted vehicles", "bus1", fmt.Sprintf("%v %v", vehicles, err)))
+		return
+	}
+
+	// Execute
+	_, err = client.GetVehicle(rpcContext(token), &rpc.GetVehicleRequest{PlateId: "car1"})
+
+	// Test
+	if status.Code(err) != codes.NotFound {
+		t.Error(errorMsg("Restricted GetVehicle", "NotFound", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	agents, err := client.ListAgents(rpcContext(token), &rpc.ListAgentsRequest{AgentState: repository.AGENT_STATE_ASSIGNED})
+
+	// Test
+	if err != nil || len(agents.Agents) != 1 || agents.Agents[0].Lat != "35.1" {
+		t.Error(errorMsg("Agents", "agent1", fmt.Sprintf("%v %v", agents, err)))
+		return
+	}
+
+	// Execute
+	_, visibleErr := client.GetAgent(rpcContext(token), &rpc.GetAgentRequest{Uuid: "agent1"})
+	_, hiddenErr := client.GetAgent(rpcContext(token), &rpc.GetAgentRequest{Uuid: "agent2"})
+
+	// Test
+	if visibleErr != nil || status.Code(hiddenErr) != codes.NotFound {
+		t.Error(errorMsg("Restricted GetAgent", "agent1, NotFound for agent2", fmt.Sprintf("%v, %v", visibleErr, hiddenErr)))
+		return
+	}
+}
+
+func TestRPCSyncAndWatch(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	client, stop := rpcClient()
+	defer stop()
+
+	// Prepare
+	syncAgent("agent1", GPSData{Lat: "35.1", Lon: "33.9", TS: "1500000000"})
+	repository.CreateVehicle("bus1", "agent1", nil, repository.SCHOOL_BUS)
+	repository.CreateVehicle("car1", "", nil, repository.SOLAR_CAR)
+	ctx, cancel := context.WithCancel(rpcContext(viewerToken()))
+	defer cancel()
+	watch, _ := client.WatchVehicles(ctx, &rpc.VehicleFilter{PlateId: []string{"bus1"}})
+
+	// Test
+	update, err := watch.Recv()
+	if err != nil || update.GetVehicle().GetPlateId() != "bus1" {
+		t.Error(errorMsg("Snapshot", "bus1", fmt.Sprintf("%v %v", update, err)))
+		return
+	}
+
+	// Execute
+	viewerSync, _ := client.SyncAgent(ctx)
+	_, err = viewerSync.CloseAndRecv()
+
+	// Test
+	if status.Code(err) != codes.PermissionDenied {
+		t.Error(errorMsg("Viewer SyncAgent", "PermissionDenied", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	dispatcher, _ := repository.CreateNewUser("dispatcher@test.com", "1234", repository.ROLE_DISPATCHER)
+	dispatcherToken, _ := dispatcher.RenewToken()
+	sync, _ := client.SyncAgent(rpcContext(dispatcherToken))
+	sync.Send(&rpc.AgentPosition{Uuid: "agent1", Lat: "35.2", Lon: "33.9", GpsTs: "1500000010"})
+	sync.Send(&rpc.AgentPosition{Lat: "35.3", Lon: "33.9", GpsTs: "1500000020"})
+	summary, err := sync.CloseAndRecv()
+
+	// Test
+	if err != nil || summary.Accepted != 1 || summary.Rejected != 1 {
+		t.Error(errorMsg("Summary", "1 accepted, 1 rejected", fmt.Sprintf("%v %v", summary, err)))
+		return
+	}
+
+	update, err = watch.Recv()
+	if err != nil || update.GetVehicle().GetAgent().GetLat() != "35.2" {
+		t.Error(errorMsg("Update", "bus1 at 35.2", fmt.Sprintf("%v %v", update, err)))
+		return
+	}
+
+	// Execute
+	repository.DeleteVehicleByPlateID("bus1")
+
+	// Test
+	update, err = watch.Recv()
+	if err != nil || update.GetRemoval().GetPlateId() != "bus1" {
+		t.Error(errorMsg("Removal", "bus1", fmt.Sprintf("%v %v", update, err)))
+		return
+	}
+}
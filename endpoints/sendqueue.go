@@ -0,0 +1,190 @@
+package endpoints
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// errSlowConsumer is returned once a client that doesn't keep up with its
+// messages is to be disconnected.
+var errSlowConsumer = fmt.Errorf("slow consumer")
+
+type queuedEnvelope struct {
+	envelope StreamEnvelope
+	key      string
+}
+
+// sendQueue is the bounded outbound queue of a connection. Event handlers
+// push to it and the writer of the connection pops from it, so that a slow
+// client only holds up itself.
+//
+// The queue never holds more than size messages. Only vehicle, alert and
+// geofence messages are ever dropped, to make room or by coalescing; when
+// a reply to the client or a removal doesn't fit, the client is
+// disconnected. Messages are numbered as they're pushed, so that the
+// dropped ones leave a gap in the seq numbers the client receives.
+type sendQueue struct {
+	lock   sync.Mutex
+	items  []*queuedEnvelope
+	size   int
+	policy string
+	seq    uint64
+
+	// latest holds the last queued message about each vehicle of a
+	// subscription, for coalescing.
+	latest map[string]*queuedEnvelope
+
+	dropped int
+	err     error
+
+	// ready is signalled when there's something to pop.
+	ready chan struct{}
+}
+
+func newSendQueue(size int, policy string) *sendQueue {
+	if size < 1 {
+		size = 1
+	}
+	return &sendQueue{
+		size:   size,
+		policy: policy,
+		latest: map[string]*queuedEnvelope{},
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// queueKey identifies the vehicle a message is about within its
+// subscription, empty for other messages.
+func queueKey(envelope StreamEnvelope) string {
+	switch data := envelope.Data.(type) {
+	case repository.Vehicle:
+		if envelope.Type == STREAM_VEHICLE {
+			return envelope.Subscription + "/" + data.PlateID
+		}
+	case StreamRemoval:
+		return envelope.Subscription + "/" + data.PlateID
+	}
+	return ""
+}
+
+func droppable(envelope StreamEnvelope) bool {
+	switch envelope.Type {
+	case STREAM_VEHICLE, STREAM_ALERT, STREAM_GEOFENCE:
+		return true
+	}
+	return false
+}
+
+// push queues the envelope, applying the slow consumer policy when the
+// queue is full. It fails once the client is to be disconnected.
+//
+// Under the coalesce policy a vehicle update or removal replaces the one
+// queued for the same vehicle, as only the last state of a vehicle
+// matters. The update of a vehicle is never dropped for another one: when
+// the queue is full of them, the client is disconnected.
+func (q *sendQueue) push(envelope StreamEnvelope) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.err != nil {
+		return q.err
+	}
+
+	key := queueKey(envelope)
+	coalesced := q.policy == config.SLOW_CONSUMER_COALESCE && key != ""
+	if item, ok := q.latest[key]; ok && coalesced {
+		q.drop(item)
+	}
+
+	if len(q.items) >= q.size {
+		switch {
+		case q.policy == config.SLOW_CONSUMER_DISCONNECT:
+			return q.disconnect()
+		case q.dropOldest():
+		case droppable(envelope) && !coalesced:
+			// The message is dropped, its number left unused.
+			q.seq++
+			q.dropped++
+			return nil
+		default:
+			return q.disconnect()
+		}
+	}
+
+	q.seq++
+	envelope.Seq = q.seq
+	item := &queuedEnvelope{envelope: envelope, key: key}
+	q.items = append(q.items, item)
+	if key != "" {
+		q.latest[key] = item
+	}
+	q.signal()
+	return nil
+}
+
+func (q *sendQueue) disconnect() error {
+	q.err = errSlowConsumer
+	q.signal()
+	return q.err
+}
+
+// dropOldest drops the oldest message the policy allows to drop, if any:
+// any droppable message, but only alerts and geofence events when vehicle
+// updates are coalesced.
+func (q *sendQueue) dropOldest() bool {
+	for _, item := range q.items {
+		if !droppable(item.envelope) {
+			continue
+		}
+		if q.policy == config.SLOW_CONSUMER_COALESCE && item.key != "" {
+			continue
+		}
+		q.drop(item)
+		return true
+	}
+	return false
+}
+
+// drop removes a queued message.
+func (q *sendQueue) drop(item *queuedEnvelope) {
+	for i := range q.items {
+		if q.items[i] == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	if q.latest[item.key] == item {
+		delete(q.latest, item.key)
+	}
+	q.dropped++
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop takes every queued message, oldest first. The error is set once the
+// client is to be disconnected.
+func (q *sendQueue) pop() ([]StreamEnvelope, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	envelopes := make([]StreamEnvelope, len(q.items))
+	for i, item := range q.items {
+		envelopes[i] = item.envelope
+	}
+	q.items = nil
+	q.latest = map[string]*queuedEnvelope{}
+	return envelopes, q.err
+}
+
+// dropCount returns the number of messages dropped so far.
+func (q *sendQueue) dropCount() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped
+}
@@ -0,0 +1,336 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// Names of the events sent over SSE.
+const (
+	SSE_VEHICLE = "vehicle"
+	SSE_REMOVE  = "remove"
+)
+
+type vehicleLogEntry struct {
+	id      uint64
+	vehicle repository.Vehicle
+	deleted bool
+}
+
+// vehicleLog keeps the latest vehicle updates for SSE clients to resume
+// from. Entry ids are prefixed with the epoch of the log, so that ids
+// handed out before a restart aren't mistaken for current ones.
+type vehicleLog struct {
+	lock    sync.Mutex
+	epoch   int64
+	entries []vehicleLogEntry
+	size    int
+	last    uint64
+
+	// changed is closed and replaced on every update, waking up every
+	// client waiting on it.
+	changed chan struct{}
+}
+
+var (
+	sseLog     *vehicleLog
+	sseLogOnce sync.Once
+)
+
+// startVehicleLog returns the vehicle log, recording the vehicle events
+// into it from the first call on.
+func startVehicleLog() *vehicleLog {
+	sseLogOnce.Do(func() {
+		sseLog = newVehicleLog(config.C.SSE.ReplayBuffer)
+		kinds := []string{repository.NEW_AGENT, repository.VEHICLE_UPDATE, repository.VEHICLE_DELETE}
+		event.Subscribe(kinds, event.Options{Name: "sse"}, func(e *event.Event) {
+			sseLog.record(e.Kind, e.Payload)
+		})
+	})
+	return sseLog
+}
+
+func newVehicleLog(size int) *vehicleLog {
+	if size < 1 {
+		size = 1
+	}
+	return &vehicleLog{
+		epoch:   time.Now().UnixNano(),
+		size:    size,
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *vehicleLog) record(kind string, payload interface{}) {
+	var vehicle repository.Vehicle
+	switch payload := payload.(type) {
+	case repository.Agent:
+		var err error
+		if vehicle, err = repository.GetVehicleByAgentUUID(payload.UUID); err != nil {
+			return
+		}
+	case repository.Vehicle:
+		vehicle = payload
+	default:
+		log.Println("[SSE] Unknown payload. Ignoring.", "Event", kind)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.last++
+	l.entries = append(l.entries, vehicleLogEntry{id: l.last, vehicle: vehicle, deleted: kind == repository.VEHICLE_DELETE})
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// wait returns a channel closed on the next update.
+func (l *vehicleLog) wait() <-chan struct{} {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.changed
+}
+
+// head returns the id of the latest entry.
+func (l *vehicleLog) head() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.last
+}
+
+// since returns the entries after id, oldest first. ok is false when some
+// of them were dropped from the log already.
+func (l *vehicleLog) since(id uint64) (entries []vehicleLogEntry, ok bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if id > l.last {
+		return nil, false
+	}
+	if id == l.last {
+		return nil, true
+	}
+	if len(l.entries) == 0 || l.entries[0].id > id+1 {
+		return nil, false
+	}
+	start := len(l.entries) - int(l.last-id)
+	return append([]vehicleLogEntry{}, l.entries[start:]...), true
+}
+
+func (l *vehicleLog) formatID(id uint64) string {
+	return fmt.Sprintf("%d-%d", l.epoch, id)
+}
+
+// parseID parses an id handed out by the log, ok is false for ids of
+// other epochs.
+func (l *vehicleLog) parseID(s string) (id uint64, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 || parts[0] != strconv.FormatInt(l.epoch, 10) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	return id, err == nil
+}
+
+// sseStream writes the vehicle updates matching a filter to a client.
+type sseStream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	log     *vehicleLog
+
+	filter      repository.VehicleFilter
+	restriction repository.VehicleFilter
+
+	// known holds the plate ids of the vehicles sent to the client.
+	known map[string]bool
+}
+
+func (s *sseStream) send(id uint64, name string, data interface{}) error {
+	j, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "id: %s\nevent: %s\ndata: %s\n\n", s.log.formatID(id), name, j)
+	return err
+}
+
+// snapshot sends every matching vehicle as of the entry id, and removals
+// of the vehicles sent before that don't match any more.
+func (s *sseStream) snapshot(id uint64) error {
+	known := map[string]bool{}
+	for _, vehicle := range repository.FilterVehiclesBy(s.filter) {
+		if !s.restriction.Match(vehicle) {
+			continue
+		}
+		known[vehicle.PlateID] = true
+		if err := s.send(id, SSE_VEHICLE, vehicle); err != nil {
+			return err
+		}
+	}
+	for plateID := range s.known {
+		if known[plateID] {
+			continue
+		}
+		if err := s.send(id, SSE_REMOVE, StreamRemoval{PlateID: plateID}); err != nil {
+			return err
+		}
+	}
+	s.known = known
+	return nil
+}
+
+// replay sends the entries, as updates of the vehicles matching and
+// removals of those that don't any more. When resuming, the vehicles the
+// client knows of are unknown, so every vehicle that doesn't match is
+// removed.
+func (s *sseStream) replay(entries []vehicleLogEntry, resuming bool) error {
+	for _, entry := range entries {
+		vehicle := entry.vehicle
+		if !entry.deleted && s.restriction.Match(vehicle) && s.filter.Match(vehicle) {
+			s.known[vehicle.PlateID] = true
+			if err := s.send(entry.id, SSE_VEHICLE, vehicle); err != nil {
+				return err
+			}
+		} else if s.known[vehicle.PlateID] || resuming {
+			delete(s.known, vehicle.PlateID)
+			if err := s.send(entry.id, SSE_REMOVE, StreamRemoval{PlateID: vehicle.PlateID}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// swagger:parameters FilterVehiclesSSE
+type FilterVehiclesSSEParams struct {
+	FilterVehiclesWSParams
+
+	// LastEventID
+	//
+	// Id of the last event received, to resume from. Browsers send it
+	// when reconnecting.
+	//
+	// in: header
+	// required: false
+	LastEventID string `json:"Last-Event-ID"`
+}
+
+// swagger:route GET /sse/vehicle/filter SSE FilterVehiclesSSE
+// Server-Sent Events Endpoint for filter vehicles.
+//
+// The alternative to FilterVehiclesWS for clients that can't use
+// WebSockets, with the same filters and authentication, but for the
+// auth message. The matching vehicles are sent as vehicle events on
+// connect, then each update. Vehicles that stop matching or are deleted
+// are sent as remove events.
+//
+// Clients reconnecting with Last-Event-ID are sent the updates they
+// missed if they're still kept, the matching vehicles otherwise.
+//
+// The stream ends once the session of the token is signed out or
+// expires, reconnecting is then answered with 401.
+//
+// e.g. https://api.vehicles.neu.edu.tr/sse/vehicle/filter?vehicle_type=SCHOOL-BUS&token=6ba7b810-9dad-11d1-80b4-00c04fd430c8
+//
+//   Produces:
+//   - text/event-stream
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessVehicleResponse
+//
+func FilterVehiclesSSE(w http.ResponseWriter, req *http.Request) {
+	params, err := parseFilterVehiclesParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	user, sessionID, ok := wsUser(w, req)
+	if !ok {
+		return
+	}
+	restriction := repository.VehicleFilter{}
+	if user != nil {
+		restriction = user.VehicleFilter()
+	} else if !config.C.Server.AnonymousRead {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendErrorMessage(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	vehicleLog := startVehicleLog()
+	s := sseStream{
+		w:           w,
+		flusher:     flusher,
+		log:         vehicleLog,
+		filter:      params.filter(),
+		restriction: restriction,
+		known:       map[string]bool{},
+	}
+	cursor, resumed := vehicleLog.parseID(req.Header.Get("Last-Event-ID"))
+	synced := resumed
+
+	sendContentType(w, "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(seconds(config.C.SSE.KeepAlive))
+	defer keepAlive.Stop()
+	var checks <-chan time.Time
+	if sessionID != 0 {
+		var stopChecks func()
+		checks, stopChecks = streamChecks()
+		defer stopChecks()
+	}
+	for {
+		changed := vehicleLog.wait()
+		entries, ok := vehicleLog.since(cursor)
+		if !synced || !ok {
+			cursor = vehicleLog.head()
+			err = s.snapshot(cursor)
+		} else if len(entries) > 0 {
+			cursor = entries[len(entries)-1].id
+			err = s.replay(entries, resumed)
+		}
+		synced, resumed = true, false
+		if err != nil {
+			log.Println("[SSE] Can't write to the client!. Closing.")
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-req.Context().Done():
+			log.Println("Client disconnected")
+			return
+		case <-changed:
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-checks:
+			if err := checkSession(sessionID); err != nil {
+				log.Println("[SSE] Session ended. Closing.")
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package endpoints
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+type sseMessage struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readSSE reads the next event of a stream, skipping comments.
+func readSSE(r *bufio.Reader) (sseMessage, error) {
+	var message sseMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return message, err
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && message.Event != "":
+			return message, nil
+		case strings.HasPrefix(line, "id: "):
+			message.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			message.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			message.Data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func openSSE(server *httptest.Server, token string, query string, lastEventID string) (*http.Response, *bufio.Reader, error) {
+	req, _ := http.NewRequest("GET", server.URL+"/sse/vehicle/filter?"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	return res, bufio.NewReader(res.Body), nil
+}
+
+// waitForVehicleLog waits until the vehicle log has recorded n more
+// entries than at start.
+func waitForVehicleLog(start uint64, n uint64) {
+	for i := 0; i < 100 && startVehicleLog().head() < start+n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFilterVehiclesSSEEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("car1", "agent2", []int{}, "SOLAR-CAR")
+	token := viewerToken()
+	time.Sleep(50 * time.Millisecond)
+
+	// Execute
+	res, r, err := openSSE(server, token, "vehicle_type=SCHOOL-BUS", "")
+	if err != nil {
+		t.Error(errorMsg("Connect", "Connected", err.Error()))
+		return
+	}
+	snapshot, err := readSSE(r)
+
+	// Test
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Error(errorMsg("Content-Type", "text/event-stream", ct))
+		return
+	}
+
+	var vehicle repository.Vehicle
+	json.Unmarshal([]byte(snapshot.Data), &vehicle)
+	if err != nil || snapshot.Event != SSE_VEHICLE || vehicle.PlateID != "bus1" {
+		t.Error(errorMsg("Snapshot", "vehicle bus1", fmt.Sprintf("%s %s", snapshot.Event, vehicle.PlateID)))
+		return
+	}
+
+	// Execute
+	head := startVehicleLog().head()
+	syncAgent("agent2", GPSData{Lat: "41.1", Lon: "29.1", TS: "900"})
+	waitForVehicleLog(head, 1)
+	syncAgent("agent1", GPSData{Lat: "41.2", Lon: "29.2", TS: "1000"})
+	update, err := readSSE(r)
+	res.Body.Close()
+
+	// Test
+	json.Unmarshal([]byte(update.Data), &vehicle)
+	if err != nil || update.Event != SSE_VEHICLE || vehicle.PlateID != "bus1" || vehicle.Agent.TS != "1000" {
+		t.Error(errorMsg("Update", "vehicle bus1 at 1000", update.Data))
+		return
+	}
+
+	// Execute
+	head = startVehicleLog().head()
+	syncAgent("agent1", GPSData{Lat: "41.3", Lon: "29.3", TS: "1100"})
+	waitForVehicleLog(head, 1)
+	res, r, err = openSSE(server, token, "vehicle_type=SCHOOL-BUS", update.ID)
+	if err != nil {
+		t.Error(errorMsg("Reconnect", "Connected", err.Error()))
+		return
+	}
+	defer res.Body.Close()
+	missed, err := readSSE(r)
+
+	// Test
+	json.Unmarshal([]byte(missed.Data), &vehicle)
+	if err != nil || missed.Event != SSE_VEHICLE || vehicle.Agent.TS != "1100" {
+		t.Error(errorMsg("Missed update", "vehicle bus1 at 1100", missed.Data))
+		return
+	}
+
+	if missed.ID == update.ID {
+		t.Error(errorMsg("Missed update ID", "after "+update.ID, missed.ID))
+		return
+	}
+}
+
+func TestVehicleLog(t *testing.T) {
+	// Prepare
+	l := newVehicleLog(2)
+	for _, plateID := range []string{"bus1", "bus2", "bus3"} {
+		l.record(repository.VEHICLE_UPDATE, repository.Vehicle{PlateID: plateID})
+	}
+
+	// Execute
+	entries, ok := l.since(1)
+
+	// Test
+	if !ok || len(entries) != 2 || entries[0].vehicle.PlateID != "bus2" {
+		t.Error(errorMsg("since(1)", "bus2 bus3", fmt.Sprintf("%v %d", ok, len(entries))))
+		return
+	}
+
+	if _, ok := l.since(0); ok {
+		t.Error(errorMsg("since(0)", "dropped", "kept"))
+		return
+	}
+
+	if id, ok := l.parseID(l.formatID(3)); !ok || id != 3 {
+		t.Error(errorMsg("parseID", "3", fmt.Sprintf("%d", id)))
+		return
+	}
+
+	if _, ok := l.parseID("1-3"); ok {
+		t.Error(errorMsg("parseID(1-3)", "other epoch", "parsed"))
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+// swagger:parameters GetVehicleStats
+type GetVehicleStatsParams struct {
+
+	// PlateID is a unique identifier across the vehicles
+	// in: path
+	// required: true
+	PlateID string `json:"plate_id"`
+
+	// From
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+}
+
+// swagger:route GET /vehicle/{plate_id}/stats Vehicles GetVehicleStats
+// Get distance, overspeed and idle totals of a vehicle.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: VehicleSuccessStatsResponse
+func GetVehicleStats(w http.ResponseWriter, req *http.Request) {
+	params := GetVehicleStatsParams{PlateID: mux.Vars(req)["plate_id"]}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := visibleVehicle(w, req, params.PlateID); !ok {
+		return
+	}
+
+	stats, err := repository.GetVehicleStats(params.PlateID, from, to)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	j, err := json.Marshal(stats)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
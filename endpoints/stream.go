@@ -0,0 +1,649 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
+)
+
+// Channels a stream client can subscribe to.
+const (
+	STREAM_VEHICLES  = "vehicles"
+	STREAM_ALERTS    = "alerts"
+	STREAM_GEOFENCES = "geofences"
+)
+
+// Types of the messages sent and received over a stream.
+const (
+	STREAM_AUTH          = "auth"
+	STREAM_SUBSCRIBE     = "subscribe"
+	STREAM_UNSUBSCRIBE   = "unsubscribe"
+	STREAM_AUTHENTICATED = "authenticated"
+	STREAM_SUBSCRIBED    = "subscribed"
+	STREAM_UNSUBSCRIBED  = "unsubscribed"
+	STREAM_SNAPSHOT      = "snapshot"
+	STREAM_VEHICLE       = "vehicle"
+	STREAM_REMOVE        = "remove"
+	STREAM_ALERT         = "alert"
+	STREAM_GEOFENCE      = "geofence"
+	STREAM_ERROR         = "error"
+)
+
+// streamChannels maps the event kinds to the channel they're sent on and
+// the type of their messages.
+var streamChannels = map[string][2]string{
+	repository.NEW_AGENT:       {STREAM_VEHICLES, STREAM_VEHICLE},
+	repository.VEHICLE_UPDATE:  {STREAM_VEHICLES, STREAM_VEHICLE},
+	repository.VEHICLE_DELETE:  {STREAM_VEHICLES, STREAM_REMOVE},
+	repository.OVERSPEED_START: {STREAM_ALERTS, STREAM_ALERT},
+	repository.OVERSPEED_END:   {STREAM_ALERTS, STREAM_ALERT},
+	repository.IDLE_START:      {STREAM_ALERTS, STREAM_ALERT},
+	repository.IDLE_END:        {STREAM_ALERTS, STREAM_ALERT},
+	repository.GEOFENCE_ENTER:  {STREAM_GEOFENCES, STREAM_GEOFENCE},
+	repository.GEOFENCE_EXIT:   {STREAM_GEOFENCES, STREAM_GEOFENCE},
+}
+
+// StreamFilter selects the vehicles a subscription is about, with the
+// semantics of FilterVehicles.
+type StreamFilter struct {
+	VehicleType    []string `json:"vehicle_type,omitempty"`
+	VehicleGroupID []int    `json:"vehicle_group_id,omitempty"`
+	PlateID        []string `json:"plate_id,omitempty"`
+	AgentState     string   `json:"agent_state,omitempty"`
+}
+
+func (f StreamFilter) filter() repository.VehicleFilter {
+	return FilterVehiclesParams{
+		VehicleType:    f.VehicleType,
+		VehicleGroupID: f.VehicleGroupID,
+		PlateID:        f.PlateID,
+		AgentState:     f.AgentState,
+	}.filter()
+}
+
+// StreamRequest is sent by the client to authenticate and to manage its
+// subscriptions.
+//
+// e.g. {"type": "subscribe", "id": "buses", "channel": "vehicles", "filter": {"vehicle_type": ["SCHOOL-BUS"]}}
+//
+// Subscribing again with the id of an existing subscription replaces its
+// channel and filter.
+//
+// e.g. {"type": "auth", "token": "6ba7b810-9dad-11d1-80b4-00c04fd430c8"}
+//
+// Authenticating restricts the vehicles sent to those the user may see,
+// and the current subscriptions start over with a new snapshot.
+//
+// swagger:model
+type StreamRequest struct {
+	// auth, subscribe or unsubscribe
+	Type string `json:"type"`
+
+	// User token, for auth.
+	Token string `json:"token,omitempty"`
+
+	// ID of the subscription, generated when subscribing without one.
+	ID string `json:"id"`
+
+	// vehicles, alerts or geofences
+	Channel string `json:"channel"`
+
+	Filter StreamFilter `json:"filter"`
+}
+
+// StreamEnvelope wraps every message sent over a stream. Seq numbers the
+// messages of a connection from 1. A gap in the numbers tells that the
+// messages in between were dropped because the client didn't keep up.
+//
+// A subscription to vehicles starts with a snapshot of every matching
+// vehicle. It's followed by a vehicle message whenever a matching vehicle
+// changes and a remove message when a vehicle the client was sent stops
+// matching or is deleted.
+//
+// swagger:model
+type StreamEnvelope struct {
+	Seq  uint64 `json:"seq"`
+	Type string `json:"type"`
+
+	Channel      string `json:"channel,omitempty"`
+	Subscription string `json:"subscription,omitempty"`
+
+	// Event kind for alerts and geofence events, e.g. "OVERSPEED-START".
+	Event string `json:"event,omitempty"`
+
+	Data interface{} `json:"data,omitempty"`
+}
+
+// StreamRemoval is the data of a remove message.
+type StreamRemoval struct {
+	PlateID string `json:"plate_id"`
+}
+
+type streamSubscription struct {
+	ID      string       `json:"id"`
+	Channel string       `json:"channel"`
+	Filter  StreamFilter `json:"filter"`
+
+	filter repository.VehicleFilter
+
+	// known holds the plate ids of the vehicles sent to the client.
+	known map[string]bool
+}
+
+// streamSession is a client connected to a stream.
+type streamSession struct {
+	c *websocket.Conn
+
+	// Legacy sessions send the data of vehicle messages without envelope,
+	// as FilterVehiclesWS always did.
+	legacy bool
+
+	// Messages are queued and written by the writer goroutine of the
+	// session.
+	queue *sendQueue
+	done  chan struct{}
+
+	lock          sync.Mutex
+	subscriptions map[string]*streamSubscription
+	nextID        int
+
+	// user is nil for anonymous sessions, which see every vehicle.
+	// restriction selects the vehicles the user may see. sessionID is the
+	// sign-in of the user, checked on every tick of checks.
+	user        *repository.User
+	restriction repository.VehicleFilter
+	sessionID   uint
+	checks      <-chan time.Time
+	stopChecks  func()
+
+	// params is the WebSocket configuration as it was when the client
+	// connected, so that the session doesn't read it while it changes.
+	params config.WebSocketParams
+
+	events *event.Subscription
+}
+
+func newStreamSession(c *websocket.Conn, legacy bool, user *repository.User, sessionID uint, params config.WebSocketParams) *streamSession {
+	s := &streamSession{
+		c:             c,
+		legacy:        legacy,
+		subscriptions: map[string]*streamSubscription{},
+		queue:         newSendQueue(params.QueueSize, params.SlowConsumer),
+		done:          make(chan struct{}),
+		params:        params,
+	}
+	if user != nil {
+		s.user = user
+		s.restriction = user.VehicleFilter()
+		s.sessionID = sessionID
+	}
+	s.checks, s.stopChecks = streamChecks()
+	kinds := make([]string, 0, len(streamChannels))
+	for kind := range streamChannels {
+		kinds = append(kinds, kind)
+	}
+	s.events = event.Subscribe(kinds, event.Options{Name: "stream " + c.RemoteAddr().String()}, func(e *event.Event) {
+		s.dispatch(e.Kind, e.Payload)
+	})
+	go s.write()
+	return s
+}
+
+// close cancels the event subscription of the session and stops its
+// writer.
+func (s *streamSession) close() {
+	s.events.Cancel()
+	close(s.done)
+	if dropped := s.queue.dropCount(); dropped > 0 {
+		log.Println("[WS-STREAM] Slow consumer.", "Dropped", dropped)
+	}
+}
+
+// send queues the envelope. It fails once the client is being
+// disconnected for not keeping up.
+func (s *streamSession) send(envelope StreamEnvelope) error {
+	if s.legacy && envelope.Type != STREAM_VEHICLE {
+		return nil
+	}
+	return s.queue.push(envelope)
+}
+
+// write writes the queued messages, pings the client and checks its
+// session until the connection fails, the session is signed out or the
+// stream is closed. The connection is closed on failure so that serve
+// returns too.
+func (s *streamSession) write() {
+	writeTimeout := seconds(s.params.WriteTimeout)
+	ping := time.NewTicker(seconds(s.params.PingInterval))
+	defer ping.Stop()
+	defer s.stopChecks()
+	for {
+		select {
+		case <-s.queue.ready:
+			envelopes, err := s.queue.pop()
+			if err != nil {
+				log.Println("[WS-STREAM] Slow consumer. Disconnecting.")
+				s.reject(err.Error())
+				s.c.Close()
+				return
+			}
+			for _, envelope := range envelopes {
+				s.c.SetWriteDeadline(time.Now().Add(writeTimeout))
+				if err := s.writeEnvelope(envelope); err != nil {
+					log.Println("[WS-STREAM] Can't write to WS Connection!. Closing.")
+					s.c.Close()
+					return
+				}
+			}
+		case <-ping.C:
+			if err := s.c.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				log.Println("[WS-STREAM] Can't ping WS Connection!. Closing.")
+				s.c.Close()
+				return
+			}
+		case <-s.checks:
+			if err := s.checkSession(); err != nil {
+				log.Println("[WS-STREAM] Session ended. Closing.")
+				s.reject(err.Error())
+				s.c.Close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *streamSession) writeEnvelope(envelope StreamEnvelope) error {
+	if s.legacy {
+		return s.c.WriteJSON(envelope.Data)
+	}
+	return s.c.WriteJSON(envelope)
+}
+
+// keepAlive expects a message or a pong from the client within the pong
+// timeout.
+func (s *streamSession) keepAlive() {
+	s.c.SetReadDeadline(time.Now().Add(seconds(s.params.PongTimeout)))
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// checkSession checks the session of an authenticated client.
+func (s *streamSession) checkSession() error {
+	s.lock.Lock()
+	sessionID := s.sessionID
+	s.lock.Unlock()
+	if sessionID == 0 {
+		return nil
+	}
+	return checkSession(sessionID)
+}
+
+func (s *streamSession) sendError(message string) error {
+	return s.send(StreamEnvelope{Type: STREAM_ERROR, Data: GenericError{Message: message}})
+}
+
+// reject closes the connection with a policy violation.
+func (s *streamSession) reject(reason string) {
+	rejectWS(s.c, reason, seconds(s.params.WriteTimeout))
+}
+
+// authenticate makes the session the user's the token was issued to and
+// starts the current subscriptions over for the vehicles the user may see.
+func (s *streamSession) authenticate(token string) error {
+	user, session, err := sessionByToken(token)
+	if err != nil {
+		return err
+	}
+	if !repository.RolePermits(user.Role, repository.PERMISSION_READ) {
+		return forbidden(user.Role, repository.PERMISSION_READ)
+	}
+
+	s.lock.Lock()
+	s.user = &user
+	s.restriction = user.VehicleFilter()
+	s.sessionID = session.ID
+	subscriptions := make([]*streamSubscription, 0, len(s.subscriptions))
+	for _, subscription := range s.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	s.lock.Unlock()
+
+	if err := s.send(StreamEnvelope{Type: STREAM_AUTHENTICATED, Data: user}); err != nil {
+		return err
+	}
+	for _, subscription := range subscriptions {
+		if err := s.add(subscription); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitAuth waits for the client to authenticate with its first message,
+// for sessions that can't be anonymous. The connection is closed when it
+// doesn't.
+func (s *streamSession) awaitAuth() bool {
+	s.c.SetReadDeadline(time.Now().Add(seconds(s.params.AuthTimeout)))
+
+	_, message, err := s.c.ReadMessage()
+	if err != nil {
+		s.reject("authentication required")
+		return false
+	}
+	var request StreamRequest
+	if err := json.Unmarshal(message, &request); err != nil || request.Type != STREAM_AUTH {
+		s.reject("authentication required")
+		return false
+	}
+	if err := s.authenticate(request.Token); err != nil {
+		s.reject(err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *streamSession) subscribe(request StreamRequest) error {
+	if _, ok := map[string]bool{STREAM_VEHICLES: true, STREAM_ALERTS: true, STREAM_GEOFENCES: true}[request.Channel]; !ok {
+		return s.sendError(fmt.Sprintf("channel should be one of %s, %s, %s", STREAM_VEHICLES, STREAM_ALERTS, STREAM_GEOFENCES))
+	}
+
+	s.lock.Lock()
+	if request.ID == "" {
+		s.nextID++
+		request.ID = fmt.Sprintf("sub-%d", s.nextID)
+	}
+	s.lock.Unlock()
+
+	return s.add(&streamSubscription{
+		ID:      request.ID,
+		Channel: request.Channel,
+		Filter:  request.Filter,
+		filter:  request.Filter.filter(),
+	})
+}
+
+// add registers the subscription, replacing the one with the same id, and
+// acknowledges it. Subscriptions to vehicles are then sent a snapshot of
+// the matching vehicles; the lock is held meanwhile so that no update
+// overtakes the snapshot.
+func (s *streamSession) add(subscription *streamSubscription) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	subscription.known = map[string]bool{}
+	s.subscriptions[subscription.ID] = subscription
+
+	err := s.send(StreamEnvelope{
+		Type:         STREAM_SUBSCRIBED,
+		Channel:      subscription.Channel,
+		Subscription: subscription.ID,
+		Data:         subscription,
+	})
+	if err != nil || subscription.Channel != STREAM_VEHICLES {
+		return err
+	}
+
+	vehicles := make([]repository.Vehicle, 0)
+	for _, vehicle := range repository.FilterVehiclesBy(subscription.filter) {
+		if s.restriction.Match(vehicle) {
+			vehicles = append(vehicles, vehicle)
+			subscription.known[vehicle.PlateID] = true
+		}
+	}
+	if s.legacy {
+		for _, vehicle := range vehicles {
+			if err := s.send(StreamEnvelope{Type: STREAM_VEHICLE, Data: vehicle}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.send(StreamEnvelope{
+		Type:         STREAM_SNAPSHOT,
+		Channel:      subscription.Channel,
+		Subscription: subscription.ID,
+		Data:         vehicles,
+	})
+}
+
+func (s *streamSession) unsubscribe(request StreamRequest) error {
+	s.lock.Lock()
+	subscription, ok := s.subscriptions[request.ID]
+	delete(s.subscriptions, request.ID)
+	s.lock.Unlock()
+
+	if !ok {
+		return s.sendError(fmt.Sprintf("unknown subscription %q", request.ID))
+	}
+	return s.send(StreamEnvelope{
+		Type:         STREAM_UNSUBSCRIBED,
+		Channel:      subscription.Channel,
+		Subscription: subscription.ID,
+	})
+}
+
+// matching returns the subscriptions to channel whose filter lets vehicle
+// through, if the user may see it. The vehicle is only loaded when a
+// subscription needs it.
+func (s *streamSession) matching(channel string, vehicle func() (repository.Vehicle, error)) []*streamSubscription {
+	s.lock.Lock()
+	candidates := make([]*streamSubscription, 0)
+	for _, subscription := range s.subscriptions {
+		if subscription.Channel == channel {
+			candidates = append(candidates, subscription)
+		}
+	}
+	restriction := s.restriction
+	s.lock.Unlock()
+	if len(candidates) == 0 {
+		return candidates
+	}
+
+	v, err := vehicle()
+	if err != nil || !restriction.Match(v) {
+		return nil
+	}
+	matching := make([]*streamSubscription, 0)
+	for _, subscription := range candidates {
+		if subscription.filter.Match(v) {
+			matching = append(matching, subscription)
+		}
+	}
+	return matching
+}
+
+// dispatch sends an event to the subscriptions it matches, with the
+// payload of the event as data.
+func (s *streamSession) dispatch(kind string, payload interface{}) {
+	route := streamChannels[kind]
+	channel, messageType := route[0], route[1]
+	if channel == STREAM_VEHICLES {
+		s.dispatchVehicle(kind, payload)
+		return
+	}
+
+	var load func() (repository.Vehicle, error)
+	switch payload := payload.(type) {
+	case repository.Overspeed:
+		load = plateVehicle(payload.PlateID)
+	case repository.Idle:
+		load = plateVehicle(payload.PlateID)
+	case repository.GeofenceCrossing:
+		load = plateVehicle(payload.PlateID)
+	default:
+		log.Println("[WS-STREAM] Unknown payload. Ignoring.", "Event", kind)
+		return
+	}
+
+	envelope := StreamEnvelope{Type: messageType, Channel: channel, Event: kind, Data: payload}
+	for _, subscription := range s.matching(channel, load) {
+		envelope.Subscription = subscription.ID
+		s.deliver(envelope)
+	}
+}
+
+// dispatchVehicle sends the vehicle of a vehicle event to the
+// subscriptions it matches and a removal to those it no longer matches.
+func (s *streamSession) dispatchVehicle(kind string, payload interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	subscriptions := make([]*streamSubscription, 0)
+	for _, subscription := range s.subscriptions {
+		if subscription.Channel == STREAM_VEHICLES {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	var vehicle repository.Vehicle
+	switch payload := payload.(type) {
+	case repository.Agent:
+		var err error
+		if vehicle, err = repository.GetVehicleByAgentUUID(payload.UUID); err != nil {
+			return
+		}
+	case repository.Vehicle:
+		vehicle = payload
+	default:
+		log.Println("[WS-STREAM] Unknown payload. Ignoring.", "Event", kind)
+		return
+	}
+
+	visible := kind != repository.VEHICLE_DELETE && s.restriction.Match(vehicle)
+	for _, subscription := range subscriptions {
+		if visible && subscription.filter.Match(vehicle) {
+			subscription.known[vehicle.PlateID] = true
+			s.deliver(StreamEnvelope{Type: STREAM_VEHICLE, Channel: STREAM_VEHICLES, Subscription: subscription.ID, Data: vehicle})
+		} else if subscription.known[vehicle.PlateID] {
+			delete(subscription.known, vehicle.PlateID)
+			s.deliver(StreamEnvelope{Type: STREAM_REMOVE, Channel: STREAM_VEHICLES, Subscription: subscription.ID, Data: StreamRemoval{PlateID: vehicle.PlateID}})
+		}
+	}
+}
+
+// deliver sends an event message. It can only fail for slow consumers,
+// which the writer disconnects.
+func (s *streamSession) deliver(envelope StreamEnvelope) {
+	s.send(envelope)
+}
+
+func plateVehicle(plateID string) func() (repository.Vehicle, error) {
+	return func() (repository.Vehicle, error) {
+		return repository.GetVehicleByPlateID(plateID)
+	}
+}
+
+// serve handles the requests of the client until it disconnects or
+// doesn't answer the pings.
+func (s *streamSession) serve() {
+	s.c.SetPongHandler(func(string) error {
+		s.keepAlive()
+		return nil
+	})
+	for {
+		s.keepAlive()
+		_, message, err := s.c.ReadMessage()
+		if err != nil {
+			log.Println("Client disconnected")
+			return
+		}
+
+		var request StreamRequest
+		if err := json.Unmarshal(message, &request); err != nil {
+			err = s.sendError("message should be a JSON StreamRequest")
+		} else if request.Type == STREAM_AUTH {
+			if err = s.authenticate(request.Token); err != nil {
+				s.reject(err.Error())
+				return
+			}
+		} else if s.legacy {
+			// Legacy clients only authenticate.
+			continue
+		} else {
+			switch request.Type {
+			case STREAM_SUBSCRIBE:
+				err = s.subscribe(request)
+			case STREAM_UNSUBSCRIBE:
+				err = s.unsubscribe(request)
+			default:
+				err = s.sendError(fmt.Sprintf("type should be %s, %s or %s", STREAM_AUTH, STREAM_SUBSCRIBE, STREAM_UNSUBSCRIBE))
+			}
+		}
+		if err != nil {
+			log.Println("[WS-STREAM] Slow consumer. Closing.")
+			return
+		}
+	}
+}
+
+// swagger:parameters Stream
+type StreamParams struct {
+
+	// Token
+	//
+	// User token, for clients that can't send the Authorization header.
+	//
+	// in: query
+	// required: false
+	Token string `json:"token"`
+}
+
+// swagger:route GET /ws/stream WebSocket Stream
+// WebSocket Endpoint streaming vehicles, alerts and geofence events.
+//
+// The client sends StreamRequest messages to subscribe to channels with
+// a filter, change the filter or unsubscribe, without reconnecting.
+// Every message sent by the server is a StreamEnvelope.
+//
+// Clients authenticate with a user token in the Authorization header, the
+// token query parameter, the bearer subprotocol or an auth message. Users
+// restricted to groups are only sent the vehicles of those groups and
+// their events. Unless anonymous connections are allowed, the connection
+// is closed when the first message doesn't authenticate. It's closed too
+// once the session of the token is signed out or expires.
+//
+// Clients that don't keep up miss vehicle updates, alerts and geofence
+// events or are disconnected, following the slow consumer policy. They
+// are pinged and have to answer in time.
+//
+// e.g. wss://api.vehicles.neu.edu.tr/ws/stream?token=6ba7b810-9dad-11d1-80b4-00c04fd430c8
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: StreamEnvelope
+//
+func Stream(w http.ResponseWriter, req *http.Request) {
+	user, sessionID, ok := wsUser(w, req)
+	if !ok {
+		return
+	}
+
+	wsParams, anonymous := config.C.WebSocket, config.C.Server.AnonymousRead
+	c, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	defer c.Close()
+
+	session := newStreamSession(c, false, user, sessionID, wsParams)
+	defer session.close()
+	if user == nil && !anonymous && !session.awaitAuth() {
+		return
+	}
+	session.serve()
+}
@@ -0,0 +1,525 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
+)
+
+// dialStream connects to the stream, authenticated with the token unless
+// it's empty.
+func dialStream(server *httptest.Server, token string) (*websocket.Conn, error) {
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/stream"
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	c, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err == nil {
+		c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	}
+	return c, err
+}
+
+func TestStreamEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("car1", "agent2", []int{}, "SOLAR-CAR")
+	_, _ = repository.CreateGeofence("zone", "ZONE", 0, []geo.Point{
+		{Lat: 41, Lon: 29}, {Lat: 41, Lon: 29.01}, {Lat: 41.01, Lon: 29.01}, {Lat: 41.01, Lon: 29},
+	})
+	c, err := dialStream(server, viewerToken())
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+
+	// Execute
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, ID: "buses", Channel: STREAM_VEHICLES, Filter: StreamFilter{VehicleType: []string{"SCHOOL-BUS"}}})
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_GEOFENCES})
+	var first, snapshot, second StreamEnvelope
+	c.ReadJSON(&first)
+	c.ReadJSON(&snapshot)
+	c.ReadJSON(&second)
+
+	// Test
+	if first.Seq != 1 || first.Type != STREAM_SUBSCRIBED || first.Subscription != "buses" {
+		t.Error(errorMsg("First", "subscribed buses #1", fmt.Sprintf("%s %s #%d", first.Type, first.Subscription, first.Seq)))
+		return
+	}
+
+	if vehicles, ok := snapshot.Data.([]interface{}); snapshot.Type != STREAM_SNAPSHOT || !ok || len(vehicles) != 1 {
+		t.Error(errorMsg("Snapshot", "bus1", fmt.Sprintf("%s %v", snapshot.Type, snapshot.Data)))
+		return
+	}
+
+	if second.Seq != 3 || second.Subscription != "sub-1" {
+		t.Error(errorMsg("Second", "subscribed sub-1 #3", fmt.Sprintf("%s %s #%d", second.Type, second.Subscription, second.Seq)))
+		return
+	}
+
+	// Execute
+	syncAgent("agent2", GPSData{Lat: "41.005", Lon: "29.005", TS: "900"})
+	syncAgent("agent1", GPSData{Lat: "40.99", Lon: "28.99", TS: "1000"})
+	syncAgent("agent1", GPSData{Lat: "41.005", Lon: "29.005", TS: "1100"})
+	received := make([]string, 0)
+	for i := uint64(4); i <= 6; i++ {
+		var envelope map[string]interface{}
+		if err := c.ReadJSON(&envelope); err != nil {
+			t.Error(errorMsg("Envelope", "Received", err.Error()))
+			return
+		}
+		if seq := envelope["seq"].(float64); uint64(seq) != i {
+			t.Error(errorMsg("Seq", fmt.Sprintf("%d", i), fmt.Sprintf("%v", seq)))
+			return
+		}
+		data := envelope["data"].(map[string]interface{})
+		received = append(received, fmt.Sprintf("%s:%s:%v", envelope["type"], envelope["subscription"], data["plate_id"]))
+	}
+	sort.Strings(received)
+
+	// Test
+	if got := strings.Join(received, " "); got != "geofence:sub-1:bus1 vehicle:buses:bus1 vehicle:buses:bus1" {
+		t.Error(errorMsg("Messages", "geofence:sub-1:bus1 vehicle:buses:bus1 vehicle:buses:bus1", got))
+		return
+	}
+
+	// Execute
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, ID: "buses", Channel: STREAM_VEHICLES, Filter: StreamFilter{PlateID: []string{"car1"}}})
+	c.WriteJSON(StreamRequest{Type: STREAM_UNSUBSCRIBE, ID: "sub-1"})
+	c.WriteJSON(StreamRequest{Type: STREAM_UNSUBSCRIBE, ID: "sub-1"})
+	var changed, unsubscribed, failed StreamEnvelope
+	c.ReadJSON(&changed)
+	c.ReadJSON(&snapshot)
+	c.ReadJSON(&unsubscribed)
+	c.ReadJSON(&failed)
+	syncAgent("agent1", GPSData{Lat: "40.99", Lon: "28.99", TS: "1200"})
+	syncAgent("agent2", GPSData{Lat: "41.006", Lon: "29.006", TS: "1300"})
+	var update StreamEnvelope
+	c.ReadJSON(&update)
+
+	// Test
+	if changed.Type != STREAM_SUBSCRIBED || unsubscribed.Type != STREAM_UNSUBSCRIBED || failed.Type != STREAM_ERROR {
+		t.Error(errorMsg("Replies", "subscribed unsubscribed error", fmt.Sprintf("%s %s %s", changed.Type, unsubscribed.Type, failed.Type)))
+		return
+	}
+
+	if vehicle, ok := update.Data.(map[string]interface{}); !ok || vehicle["plate_id"] != "car1" || update.Seq != 11 {
+		t.Error(errorMsg("Update", "car1 #11", fmt.Sprintf("%v #%d", update.Data, update.Seq)))
+		return
+	}
+}
+
+func TestStreamEndpointRemovals(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	groupID, _ := repository.CreateNewGroup("north")
+	_ = repository.CreateVehicle("bus1", "", []int{int(groupID)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "", []int{int(groupID)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus3", "", []int{}, "SCHOOL-BUS")
+	c, err := dialStream(server, viewerToken())
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, ID: "north", Channel: STREAM_VEHICLES, Filter: StreamFilter{VehicleGroupID: []int{int(groupID)}}})
+	var subscribed StreamEnvelope
+	var snapshot struct {
+		Data []repository.Vehicle `json:"data"`
+	}
+	c.ReadJSON(&subscribed)
+	c.ReadJSON(&snapshot)
+
+	// Test
+	if count := len(snapshot.Data); count != 2 {
+		t.Error(errorMsg("len(Snapshot)", "2", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	_ = repository.SetVehicleGroups("bus1", []int{})
+	var removal struct {
+		Type string        `json:"type"`
+		Data StreamRemoval `json:"data"`
+	}
+	c.ReadJSON(&removal)
+
+	// Test
+	if removal.Type != STREAM_REMOVE || removal.Data.PlateID != "bus1" {
+		t.Error(errorMsg("Removal", "remove bus1", fmt.Sprintf("%s %s", removal.Type, removal.Data.PlateID)))
+		return
+	}
+
+	// Execute
+	_ = repository.SetVehicleGroups("bus3", []int{int(groupID)})
+	var added struct {
+		Type string             `json:"type"`
+		Data repository.Vehicle `json:"data"`
+	}
+	c.ReadJSON(&added)
+
+	// Test
+	if added.Type != STREAM_VEHICLE || added.Data.PlateID != "bus3" {
+		t.Error(errorMsg("Update", "vehicle bus3", fmt.Sprintf("%s %s", added.Type, added.Data.PlateID)))
+		return
+	}
+
+	// Execute
+	_ = repository.DeleteVehicleByPlateID("bus2")
+	c.ReadJSON(&removal)
+
+	// Test
+	if removal.Type != STREAM_REMOVE || removal.Data.PlateID != "bus2" {
+		t.Error(errorMsg("Removal", "remove bus2", fmt.Sprintf("%s %s", removal.Type, removal.Data.PlateID)))
+		return
+	}
+}
+
+func TestStreamEndpointAuth(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	defer func(ws config.WebSocketParams) { config.C.WebSocket = ws }(config.C.WebSocket)
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+	config.C.WebSocket.AuthTimeout = 1
+
+	// Prepare
+	groupID, _ := repository.CreateNewGroup("north")
+	_ = repository.CreateVehicle("bus1", "", []int{int(groupID)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "", []int{}, "SCHOOL-BUS")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	_, _ = repository.SetUserGroups(user.UUID, []int{int(groupID)})
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/stream"
+
+	// Execute
+	_, res, err := websocket.DefaultDialer.Dial(url+"?token=wrong", nil)
+
+	// Test
+	if err == nil || res.StatusCode != 401 {
+		t.Error(errorMsg("Wrong token", "401", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	c, err := dialStream(server, "")
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_VEHICLES})
+	_, _, err = c.ReadMessage()
+
+	// Test
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Error(errorMsg("Anonymous", "closed", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	dialer := websocket.Dialer{Subprotocols: []string{WS_BEARER, token}}
+	c, _, err = dialer.Dial(url, nil)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_VEHICLES})
+	var subscribed StreamEnvelope
+	var snapshot struct {
+		Data []repository.Vehicle `json:"data"`
+	}
+	c.ReadJSON(&subscribed)
+	c.ReadJSON(&snapshot)
+
+	// Test
+	if c.Subprotocol() != WS_BEARER {
+		t.Error(errorMsg("Subprotocol", WS_BEARER, c.Subprotocol()))
+		return
+	}
+
+	if len(snapshot.Data) != 1 || snapshot.Data[0].PlateID != "bus1" {
+		t.Error(errorMsg("Snapshot", "bus1", fmt.Sprintf("%v", snapshot.Data)))
+		return
+	}
+
+	// Execute
+	c, err = dialStream(server, "")
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+	c.WriteJSON(StreamRequest{Type: STREAM_AUTH, Token: token})
+	c.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_VEHICLES})
+	var authenticated StreamEnvelope
+	c.ReadJSON(&authenticated)
+	c.ReadJSON(&subscribed)
+	c.ReadJSON(&snapshot)
+	_ = repository.SetVehicleGroups("bus2", []int{int(groupID)})
+	var added struct {
+		Type string             `json:"type"`
+		Data repository.Vehicle `json:"data"`
+	}
+	c.ReadJSON(&added)
+
+	// Test
+	if authenticated.Type != STREAM_AUTHENTICATED {
+		t.Error(errorMsg("Auth", STREAM_AUTHENTICATED, authenticated.Type))
+		return
+	}
+
+	if len(snapshot.Data) != 1 || snapshot.Data[0].PlateID != "bus1" {
+		t.Error(errorMsg("Snapshot", "bus1", fmt.Sprintf("%v", snapshot.Data)))
+		return
+	}
+
+	if added.Type != STREAM_VEHICLE || added.Data.PlateID != "bus2" {
+		t.Error(errorMsg("Update", "vehicle bus2", fmt.Sprintf("%s %s", added.Type, added.Data.PlateID)))
+		return
+	}
+}
+
+func TestStreamEndpointOrigin(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	// Restored once the server is closed, as its handlers read it.
+	defer func(ws config.WebSocketParams) { config.C.WebSocket = ws }(config.C.WebSocket)
+	config.C.WebSocket.AllowedOrigins = []string{"https://map.example.com"}
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/vehicle/filter"
+
+	// Execute
+	header := http.Header{"Authorization": {"Bearer " + viewerToken()}}
+	header.Set("Origin", "https://evil.example.com")
+	_, res, err := websocket.DefaultDialer.Dial(url, header)
+
+	// Test
+	if err == nil || res.StatusCode != 403 {
+		t.Error(errorMsg("Foreign origin", "403", fmt.Sprintf("%v", err)))
+		return
+	}
+
+	// Execute
+	header.Set("Origin", "https://map.example.com")
+	c, _, err := websocket.DefaultDialer.Dial(url, header)
+
+	// Test
+	if err != nil {
+		t.Error(errorMsg("Allowed origin", "Connected", err.Error()))
+		return
+	}
+	c.Close()
+
+	// Execute
+	header.Set("Origin", server.URL)
+	c, _, err = websocket.DefaultDialer.Dial(url, header)
+
+	// Test
+	if err != nil {
+		t.Error(errorMsg("Same origin", "Connected", err.Error()))
+		return
+	}
+	c.Close()
+}
+
+func TestStreamEndpointKeepAlive(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	defer func(ws config.WebSocketParams) { config.C.WebSocket = ws }(config.C.WebSocket)
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+	config.C.WebSocket.PingInterval = 0.05
+	config.C.WebSocket.PongTimeout = 0.2
+
+	// Prepare
+	token := viewerToken()
+	dead, err := dialStream(server, token)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer dead.Close()
+	alive, err := dialStream(server, token)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer alive.Close()
+	replies := make(chan StreamEnvelope)
+	go func() {
+		for {
+			var envelope StreamEnvelope
+			if err := alive.ReadJSON(&envelope); err != nil {
+				close(replies)
+				return
+			}
+			replies <- envelope
+		}
+	}()
+
+	// Execute
+	time.Sleep(500 * time.Millisecond)
+	alive.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_ALERTS})
+	reply, ok := <-replies
+
+	// Test
+	if !ok || reply.Type != STREAM_SUBSCRIBED {
+		t.Error(errorMsg("Answering client", "subscribed", fmt.Sprintf("%v", reply)))
+		return
+	}
+
+	// Execute
+	dead.WriteJSON(StreamRequest{Type: STREAM_SUBSCRIBE, Channel: STREAM_ALERTS})
+	var envelope StreamEnvelope
+	for err == nil {
+		err = dead.ReadJSON(&envelope)
+	}
+
+	// Test
+	if envelope.Type == STREAM_SUBSCRIBED {
+		t.Error(errorMsg("Silent client", "Disconnected", "subscribed"))
+		return
+	}
+}
+
+func TestSendQueue(t *testing.T) {
+	// Prepare
+	vehicle := func(plateID string, ts string) StreamEnvelope {
+		agent := repository.Agent{TS: ts}
+		return StreamEnvelope{Type: STREAM_VEHICLE, Subscription: "all", Data: repository.Vehicle{PlateID: plateID, Agent: &agent}}
+	}
+	removal := StreamEnvelope{Type: STREAM_REMOVE, Subscription: "all", Data: StreamRemoval{PlateID: "bus1"}}
+	describe := func(envelopes []StreamEnvelope) string {
+		described := make([]string, 0)
+		for _, envelope := range envelopes {
+			switch data := envelope.Data.(type) {
+			case repository.Vehicle:
+				described = append(described, fmt.Sprintf("%d:%s@%s", envelope.Seq, data.PlateID, data.Agent.TS))
+			case StreamRemoval:
+				described = append(described, fmt.Sprintf("%d:-%s", envelope.Seq, data.PlateID))
+			default:
+				described = append(described, fmt.Sprintf("%d:%s", envelope.Seq, envelope.Type))
+			}
+		}
+		return strings.Join(described, " ")
+	}
+	reply := StreamEnvelope{Type: STREAM_SUBSCRIBED}
+
+	// Execute
+	q := newSendQueue(2, config.SLOW_CONSUMER_DROP_OLDEST)
+	q.push(vehicle("bus1", "1"))
+	q.push(vehicle("bus2", "1"))
+	q.push(removal)
+	q.push(vehicle("bus1", "2"))
+	envelopes, err := q.pop()
+
+	// Test
+	if got := describe(envelopes); err != nil || got != "3:-bus1 4:bus1@2" || q.dropCount() != 2 {
+		t.Error(errorMsg("drop_oldest", "3:-bus1 4:bus1@2, 2 dropped", fmt.Sprintf("%s, %d dropped", got, q.dropCount())))
+		return
+	}
+
+	// Execute
+	q.push(reply)
+	q.push(reply)
+	q.push(vehicle("bus1", "3"))
+	err = q.push(reply)
+	envelopes, popErr := q.pop()
+
+	// Test
+	if got := describe(envelopes); err != errSlowConsumer || popErr != errSlowConsumer || got != "5:subscribed 6:subscribed" {
+		t.Error(errorMsg("drop_oldest overflow", "5:subscribed 6:subscribed, slow consumer", fmt.Sprintf("%s, %v %v", got, err, popErr)))
+		return
+	}
+
+	// Execute
+	q = newSendQueue(2, config.SLOW_CONSUMER_COALESCE)
+	q.push(vehicle("bus1", "1"))
+	q.push(vehicle("bus2", "1"))
+	q.push(vehicle("bus1", "2"))
+	q.push(removal)
+	q.push(vehicle("bus1", "3"))
+	q.push(vehicle("bus1", "4"))
+	envelopes, err = q.pop()
+
+	// Test
+	if got := describe(envelopes); err != nil || got != "2:bus2@1 6:bus1@4" {
+		t.Error(errorMsg("coalesce", "2:bus2@1 6:bus1@4", got))
+		return
+	}
+
+	// Execute
+	q.push(vehicle("bus1", "5"))
+	q.push(StreamEnvelope{Type: STREAM_ALERT})
+	q.push(vehicle("bus2", "2"))
+	err = q.push(vehicle("bus3", "1"))
+	_, popErr = q.pop()
+
+	// Test
+	if err != errSlowConsumer || popErr != errSlowConsumer {
+		t.Error(errorMsg("coalesce overflow", errSlowConsumer.Error(), fmt.Sprintf("%v %v", err, popErr)))
+		return
+	}
+
+	// Execute
+	q = newSendQueue(2, config.SLOW_CONSUMER_DISCONNECT)
+	q.push(vehicle("bus1", "1"))
+	q.push(vehicle("bus2", "1"))
+	err = q.push(vehicle("bus3", "1"))
+	_, popErr = q.pop()
+
+	// Test
+	if err != errSlowConsumer || popErr != errSlowConsumer {
+		t.Error(errorMsg("disconnect", errSlowConsumer.Error(), fmt.Sprintf("%v %v", err, popErr)))
+		return
+	}
+}
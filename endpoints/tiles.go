@@ -0,0 +1,314 @@
+package endpoints
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/mvt"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+const mvtContentType = "application/vnd.mapbox-vector-tile"
+
+// MaxTileZoom is the deepest zoom level tiles are served for.
+const MaxTileZoom = 22
+
+// densityCellSize is the size of a density cell in tile coordinates.
+const densityCellSize = mvt.DefaultExtent / 64
+
+// tileBuffer is how far outside of the tile, in tile coordinates,
+// geometries are clipped.
+const tileBuffer = 64
+
+var tileLayers = []string{"vehicles", "geofences", "density"}
+
+// swagger:parameters GetTile
+type GetTileParams struct {
+
+	// Z
+	//
+	// in: path
+	// required: true
+	Z int `json:"z"`
+
+	// X
+	//
+	// in: path
+	// required: true
+	X int `json:"x"`
+
+	// Y
+	//
+	// in: path
+	// required: true
+	Y int `json:"y"`
+
+	// Layers
+	//
+	// Comma separated layers to include, all by default.
+	//
+	// in: query
+	// required: false
+	// enum: vehicles,geofences,density
+	Layers string `json:"layers"`
+
+	// From
+	//
+	// Start of the history used by the density layer.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// End of the history used by the density layer.
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+
+	FilterVehiclesParams
+}
+
+// tile locates a web map tile and converts points to its coordinates.
+type tile struct {
+	z, x, y int
+	bbox    geo.BBox
+}
+
+func (t tile) point(p geo.Point) (float64, float64) {
+	px, py := geo.Project(p, float64(t.z))
+	scale := float64(mvt.DefaultExtent) / geo.TileSize
+	return (px - float64(t.x*geo.TileSize)) * scale, (py - float64(t.y*geo.TileSize)) * scale
+}
+
+func (t tile) coords(p geo.Point) (int32, int32) {
+	x, y := t.point(p)
+	return int32(math.Floor(x + 0.5)), int32(math.Floor(y + 0.5))
+}
+
+// densityGrid returns the longitudes and the latitudes splitting the tile
+// into density cells, west to east and north to south.
+func (t tile) densityGrid() ([]float64, []float64) {
+	n := mvt.DefaultExtent / densityCellSize
+	scale := geo.TileSize / float64(mvt.DefaultExtent)
+	cols := make([]float64, 0, n-1)
+	rows := make([]float64, 0, n-1)
+	for k := 1; k < n; k++ {
+		edge := float64(k*densityCellSize) * scale
+		p := geo.Unproject(float64(t.x*geo.TileSize)+edge, float64(t.y*geo.TileSize)+edge, float64(t.z))
+		cols = append(cols, p.Lon)
+		rows = append(rows, p.Lat)
+	}
+	return cols, rows
+}
+
+// clipRing clips a polygon ring in tile coordinates to the tile and its
+// buffer, one side after the other, so that the coordinates of large
+// polygons at deep zoom levels fit in the tile.
+func clipRing(ring [][2]float64) [][2]float64 {
+	const low, high = -tileBuffer, mvt.DefaultExtent + tileBuffer
+	for axis := 0; axis < 2; axis++ {
+		for _, bound := range []float64{low, high} {
+			inside := func(p [2]float64) bool {
+				if bound == low {
+					return p[axis] >= bound
+				}
+				return p[axis] <= bound
+			}
+			clipped := make([][2]float64, 0, len(ring)+1)
+			for i, p := range ring {
+				prev := ring[(i+len(ring)-1)%len(ring)]
+				if inside(p) != inside(prev) {
+					f := (bound - prev[axis]) / (p[axis] - prev[axis])
+					var cut [2]float64
+					cut[axis] = bound
+					cut[1-axis] = prev[1-axis] + f*(p[1-axis]-prev[1-axis])
+					clipped = append(clipped, cut)
+				}
+				if inside(p) {
+					clipped = append(clipped, p)
+				}
+			}
+			ring = clipped
+		}
+	}
+	return ring
+}
+
+func parseTile(req *http.Request) (tile, error) {
+	vars := mux.Vars(req)
+	z, err := strconv.Atoi(vars["z"])
+	if err != nil || z < 0 || z > MaxTileZoom {
+		return tile{}, &ParamError{Name: "z", Type: "between 0 and 22"}
+	}
+	n := 1 << uint(z)
+	x, err := strconv.Atoi(vars["x"])
+	if err != nil || x < 0 || x >= n {
+		return tile{}, &ParamError{Name: "x", Type: "a tile column at zoom z"}
+	}
+	y, err := strconv.Atoi(vars["y"])
+	if err != nil || y < 0 || y >= n {
+		return tile{}, &ParamError{Name: "y", Type: "a tile row at zoom z"}
+	}
+	return tile{z: z, x: x, y: y, bbox: geo.TileBBox(z, x, y)}, nil
+}
+
+func parseTileLayers(s string) (map[string]bool, error) {
+	layers := map[string]bool{}
+	if s == "" {
+		for _, name := range tileLayers {
+			layers[name] = true
+		}
+		return layers, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, layer := range tileLayers {
+			known = known || layer == name
+		}
+		if !known {
+			return nil, &ParamError{Name: "layers", Type: strings.Join(tileLayers, ", ")}
+		}
+		layers[name] = true
+	}
+	return layers, nil
+}
+
+func vehiclesLayer(t tile, vehicles []repository.Vehicle) *mvt.Layer {
+	layer := mvt.NewLayer("vehicles", mvt.DefaultExtent)
+	for _, vehicle := range vehicles {
+		if vehicle.Agent == nil {
+			continue
+		}
+		p, ok := vehicle.Agent.Point()
+		if !ok || !t.bbox.Contains(p) {
+			continue
+		}
+		groups := make([]string, 0, len(vehicle.Groups))
+		for _, group := range vehicle.Groups {
+			groups = append(groups, group.Name)
+		}
+		x, y := t.coords(p)
+		layer.AddPoint(uint64(vehicle.ID), x, y, map[string]interface{}{
+			"plate_id":   vehicle.PlateID,
+			"type":       vehicle.Type,
+			"groups":     strings.Join(groups, ","),
+			"agent_uuid": vehicle.Agent.UUID,
+			"label":      vehicle.Agent.Label,
+			"place":      vehicle.Agent.Place,
+			"gps_ts":     vehicle.Agent.TS,
+		})
+	}
+	return layer
+}
+
+func geofencesLayer(t tile, geofences []repository.Geofence) *mvt.Layer {
+	layer := mvt.NewLayer("geofences", mvt.DefaultExtent)
+	for _, geofence := range geofences {
+		if !t.bbox.Intersects(geo.Bounds(geofence.Polygon)) {
+			continue
+		}
+		points := make([][2]float64, 0, len(geofence.Polygon))
+		for _, p := range geofence.Polygon {
+			x, y := t.point(p)
+			points = append(points, [2]float64{x, y})
+		}
+		points = clipRing(points)
+		if len(points) < 3 {
+			continue
+		}
+		ring := make([][2]int32, 0, len(points))
+		for _, p := range points {
+			ring = append(ring, [2]int32{int32(math.Floor(p[0] + 0.5)), int32(math.Floor(p[1] + 0.5))})
+		}
+		layer.AddPolygon(uint64(geofence.ID), ring, map[string]interface{}{
+			"name":        geofence.Name,
+			"kind":        geofence.Kind,
+			"speed_limit": geofence.SpeedLimit,
+		})
+	}
+	return layer
+}
+
+// densityLayer adds a point at the center of every non empty density
+// cell of the tile, with the number of positions counted in it.
+func densityLayer(cells []repository.DensityCell) *mvt.Layer {
+	layer := mvt.NewLayer("density", mvt.DefaultExtent)
+	for _, cell := range cells {
+		x := int32(cell.Col)*densityCellSize + densityCellSize/2
+		y := int32(cell.Row)*densityCellSize + densityCellSize/2
+		layer.AddPoint(0, x, y, map[string]interface{}{"count": cell.Count})
+	}
+	return layer
+}
+
+// swagger:route GET /tiles/{z}/{x}/{y}.mvt Tiles GetTile
+// Get a Mapbox Vector Tile.
+//
+// The tile has a vehicles layer with the current vehicle positions, a
+// geofences layer and a density layer counting the recorded positions.
+// Accepts the filters of FilterVehicles for the vehicles layer.
+//
+//   Produces:
+//     - application/vnd.mapbox-vector-tile
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200:
+func GetTile(w http.ResponseWriter, req *http.Request) {
+	t, err := parseTile(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	layers, err := parseTileLayers(req.URL.Query().Get("layers"))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseFilterVehiclesParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var result mvt.Tile
+	if layers["vehicles"] {
+		vehicles := restrictVehicles(filterVehicles(filter), restriction)
+		result.Layers = append(result.Layers, vehiclesLayer(t, vehicles))
+	}
+	if layers["geofences"] {
+		result.Layers = append(result.Layers, geofencesLayer(t, repository.GetAllGeofences()))
+	}
+	if layers["density"] {
+		cols, rows := t.densityGrid()
+		cells := repository.CountPositionsInGrid(t.bbox, cols, rows, from, to, restriction.VehicleIDs())
+		result.Layers = append(result.Layers, densityLayer(cells))
+	}
+
+	sendContentType(w, mvtContentType)
+	w.Write(result.Marshal())
+}
@@ -0,0 +1,215 @@
+package endpoints
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// testLayer is the part of a decoded vector tile layer the tests look at.
+type testLayer struct {
+	features int
+	keys     []string
+	strings  []string
+}
+
+// readField reads one protobuf field from b and returns its number, its
+// value (varints and fixed numbers) or payload (length delimited) and the
+// rest of b.
+func readField(b []byte) (uint64, uint64, []byte, []byte) {
+	key, n := binary.Uvarint(b)
+	b = b[n:]
+	switch key & 0x7 {
+	case 0:
+		v, n := binary.Uvarint(b)
+		return key >> 3, v, nil, b[n:]
+	case 1:
+		return key >> 3, binary.LittleEndian.Uint64(b), nil, b[8:]
+	case 5:
+		return key >> 3, uint64(binary.LittleEndian.Uint32(b)), nil, b[4:]
+	default:
+		l, n := binary.Uvarint(b)
+		b = b[n:]
+		return key >> 3, 0, b[:l], b[l:]
+	}
+}
+
+func decodeTile(b []byte) map[string]testLayer {
+	layers := map[string]testLayer{}
+	for len(b) > 0 {
+		var payload []byte
+		_, _, payload, b = readField(b)
+
+		var name string
+		var layer testLayer
+		for len(payload) > 0 {
+			var field uint64
+			var data []byte
+			field, _, data, payload = readField(payload)
+			switch field {
+			case 1:
+				name = string(data)
+			case 2:
+				layer.features++
+			case 3:
+				layer.keys = append(layer.keys, string(data))
+			case 4:
+				if valueField, _, s, _ := readField(data); valueField == 1 {
+					layer.strings = append(layer.strings, string(s))
+				}
+			}
+		}
+		layers[name] = layer
+	}
+	return layers
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGetTileEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	syncAgent("test", GPSData{Lat: "41.0010", Lon: "29.0010", TS: "1000"})
+	syncAgent("test", GPSData{Lat: "41.0010", Lon: "29.0010", TS: "1060"})
+	syncAgent("test", GPSData{Lat: "41.0010", Lon: "29.0010", TS: "1120"})
+	_, _ = repository.CreateGeofence("zone", "ZONE", 30, []geo.Point{
+		{Lat: 41, Lon: 29}, {Lat: 41, Lon: 29.01}, {Lat: 41.01, Lon: 29.01}, {Lat: 41.01, Lon: 29},
+	})
+	_, _ = repository.CreateGeofence("far", "ZONE", 30, []geo.Point{
+		{Lat: 38, Lon: 27}, {Lat: 38, Lon: 27.01}, {Lat: 38.01, Lon: 27.01},
+	})
+
+	zoom := 12
+	px, py := geo.Project(geo.Point{Lat: 41.0010, Lon: 29.0010}, float64(zoom))
+	x, y := int(px)/geo.TileSize, int(py)/geo.TileSize
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/tiles/%d/%d/%d.mvt", zoom, x, y), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/vnd.mapbox-vector-tile" {
+		t.Error(errorMsg("Content-Type", "application/vnd.mapbox-vector-tile", contentType))
+		return
+	}
+
+	layers := decodeTile(res.Body.Bytes())
+	if vehicles := layers["vehicles"]; vehicles.features != 1 || !contains(vehicles.strings, "test") {
+		t.Error(errorMsg("vehicles", "1 feature with plate_id test", fmt.Sprintf("%+v", vehicles)))
+		return
+	}
+
+	if geofences := layers["geofences"]; geofences.features != 1 || !contains(geofences.strings, "zone") {
+		t.Error(errorMsg("geofences", "1 feature named zone", fmt.Sprintf("%+v", geofences)))
+		return
+	}
+
+	if density := layers["density"]; density.features != 1 || !contains(density.keys, "count") {
+		t.Error(errorMsg("density", "1 feature with a count", fmt.Sprintf("%+v", density)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/tiles/%d/%d/%d.mvt?layers=density&from=1100", zoom, x, y), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	layers = decodeTile(res.Body.Bytes())
+	if count := len(layers); count != 1 {
+		t.Error(errorMsg("len(layers)", "1", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if density := layers["density"]; density.features != 1 {
+		t.Error(errorMsg("density", "1 feature", fmt.Sprintf("%+v", density)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/tiles/2/4/0.mvt", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestGetTileLargeGeofence(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateGeofence("region", "ZONE", 30, []geo.Point{
+		{Lat: 35, Lon: 20}, {Lat: 35, Lon: 40}, {Lat: 45, Lon: 40}, {Lat: 45, Lon: 20},
+	})
+
+	zoom := MaxTileZoom
+	px, py := geo.Project(geo.Point{Lat: 41.0010, Lon: 29.0010}, float64(zoom))
+	x, y := int(px)/geo.TileSize, int(py)/geo.TileSize
+	tl := tile{z: zoom, x: x, y: y, bbox: geo.TileBBox(zoom, x, y)}
+
+	// Execute
+	layer := geofencesLayer(tl, repository.GetAllGeofences())
+
+	// Test
+	if count := layer.Len(); count != 1 {
+		t.Error(errorMsg("geofences", "1 feature", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	points := make([][2]float64, 0)
+	for _, p := range repository.GetAllGeofences()[0].Polygon {
+		px, py := tl.point(p)
+		points = append(points, [2]float64{px, py})
+	}
+	points = clipRing(points)
+
+	// Test
+	if count := len(points); count != 4 {
+		t.Error(errorMsg("len(points)", "4", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	for _, p := range points {
+		if p[0] != -tileBuffer && p[0] != 4096+tileBuffer || p[1] != -tileBuffer && p[1] != 4096+tileBuffer {
+			t.Error(errorMsg("point", "a corner of the buffered tile", fmt.Sprintf("%v", p)))
+			return
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/mux"
+)
+
+// Track is the time ordered path of a vehicle or an agent. Points are
+// returned as a list of positions, or as an encoded polyline when
+// `format=polyline` is requested. GeoJSON clients get a LineString
+// Feature instead, `format=gpx` and `format=kml` export the track and
+// `format=png` renders it as an image.
+type Track struct {
+	PlateID    string                `json:"plate_id,omitempty"`
+	AgentUUID  string                `json:"agent_uuid,omitempty"`
+	StartPlace string                `json:"start_place,omitempty"`
+	EndPlace   string                `json:"end_place,omitempty"`
+	Points     []repository.Position `json:"points,omitempty"`
+	Polyline   string                `json:"polyline,omitempty"`
+}
+
+// TrackParams are the query parameters shared by track endpoints.
+type TrackParams struct {
+
+	// From
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	From string `json:"from"`
+
+	// To
+	//
+	// Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	To string `json:"to"`
+
+	// Tolerance
+	//
+	// Douglas-Peucker tolerance in meters.
+	//
+	// in: query
+	// required: false
+	Tolerance float64 `json:"tolerance"`
+
+	// MaxPoints
+	//
+	// Maximum number of points to return.
+	//
+	// in: query
+	// required: false
+	MaxPoints int `json:"max_points"`
+
+	// Bucket
+	//
+	// Return one sample per bucket of this many seconds.
+	//
+	// in: query
+	// required: false
+	Bucket int `json:"bucket"`
+
+	// Format
+	//
+	// in: query
+	// required: false
+	// enum: json,polyline,geojson,gpx,kml,png
+	Format string `json:"format"`
+
+	// Width
+	//
+	// Width of the png image in pixels, 800 by default.
+	//
+	// in: query
+	// required: false
+	Width int `json:"width"`
+
+	// Height
+	//
+	// Height of the png image in pixels, 600 by default.
+	//
+	// in: query
+	// required: false
+	Height int `json:"height"`
+
+	// Geofences
+	//
+	// Draw the geofences on the png image.
+	//
+	// in: query
+	// required: false
+	Geofences bool `json:"geofences"`
+}
+
+func parseTrackParams(req *http.Request) (TrackParams, error) {
+	q := req.URL.Query()
+	params := TrackParams{
+		From:   q.Get("from"),
+		To:     q.Get("to"),
+		Format: q.Get("format"),
+		Width:  800,
+		Height: 600,
+	}
+	var err error
+	if s := q.Get("tolerance"); s != "" {
+		if params.Tolerance, err = strconv.ParseFloat(s, 64); err != nil {
+			return params, &ParamError{Name: "tolerance", Type: "float"}
+		}
+	}
+	if s := q.Get("max_points"); s != "" {
+		if params.MaxPoints, err = strconv.Atoi(s); err != nil {
+			return params, &ParamError{Name: "max_points", Type: "int"}
+		}
+	}
+	if s := q.Get("bucket"); s != "" {
+		if params.Bucket, err = strconv.Atoi(s); err != nil {
+			return params, &ParamError{Name: "bucket", Type: "int"}
+		}
+	}
+	if s := q.Get("width"); s != "" {
+		if params.Width, err = strconv.Atoi(s); err != nil || params.Width <= 0 || params.Width > MaxImageSize {
+			return params, &ParamError{Name: "width", Type: "between 1 and 2048"}
+		}
+	}
+	if s := q.Get("height"); s != "" {
+		if params.Height, err = strconv.Atoi(s); err != nil || params.Height <= 0 || params.Height > MaxImageSize {
+			return params, &ParamError{Name: "height", Type: "between 1 and 2048"}
+		}
+	}
+	if s := q.Get("geofences"); s != "" {
+		if params.Geofences, err = strconv.ParseBool(s); err != nil {
+			return params, &ParamError{Name: "geofences", Type: "bool"}
+		}
+	}
+	return params, nil
+}
+
+// downsample applies time bucketing and simplification to positions.
+func downsample(positions []repository.Position, params TrackParams) []repository.Position {
+	if params.Bucket > 0 && len(positions) > 0 {
+		bucketed := make([]repository.Position, 0)
+		bucket := int64(params.Bucket)
+		for i, position := range positions {
+			// Keep the last sample of each bucket.
+			if i+1 < len(positions) && positions[i+1].TS.Unix()/bucket == position.TS.Unix()/bucket {
+				continue
+			}
+			bucketed = append(bucketed, position)
+		}
+		positions = bucketed
+	}
+
+	if params.Tolerance > 0 || params.MaxPoints > 0 {
+		points := make([]geo.Point, len(positions))
+		for i := range positions {
+			points[i] = positions[i].Point()
+		}
+		simplified := make([]repository.Position, 0)
+		for _, i := range geo.Simplify(points, params.Tolerance, params.MaxPoints) {
+			simplified = append(simplified, positions[i])
+		}
+		positions = simplified
+	}
+	return positions
+}
+
+func sendTrack(w http.ResponseWriter, req *http.Request, track Track, positions []repository.Position, params TrackParams) {
+	stops := findStops(positions)
+	positions = downsample(positions, params)
+	if len(positions) > 0 {
+		track.StartPlace = geocode.Describe(positions[0].Point())
+		track.EndPlace = geocode.Describe(positions[len(positions)-1].Point())
+	}
+	if wantsGeoJSON(req) {
+		sendGeoJSON(w, trackFeature(track, positions))
+		return
+	}
+
+	switch params.Format {
+	case "", "json":
+		track.Points = positions
+	case "polyline":
+		points := make([]geo.Point, len(positions))
+		for i := range positions {
+			points[i] = positions[i].Point()
+		}
+		track.Polyline = geo.EncodePolyline(points)
+	case "gpx":
+		sendXML(w, gpxContentType, trackGPX(track, positions))
+		return
+	case "kml":
+		sendXML(w, kmlContentType, trackKML(track, positions))
+		return
+	case "png":
+		if len(positions) == 0 {
+			sendErrorMessage(w, "No positions in range", http.StatusNotFound)
+			return
+		}
+		var geofences []repository.Geofence
+		if params.Geofences {
+			geofences = repository.GetAllGeofences()
+		}
+		sendPNG(w, renderTrack(positions, stops, geofences, params.Width, params.Height))
+		return
+	default:
+		sendErrorMessage(w, "format should be one of json, polyline, geojson, gpx, kml, png", http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(track)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters GetVehicleTrack
+type GetVehicleTrackParams struct {
+
+	// PlateID is a unique identifier across the vehicles
+	// in: path
+	// required: true
+	PlateID string `json:"plate_id"`
+
+	TrackParams
+}
+
+// swagger:route GET /vehicle/{plate_id}/track Vehicles GetVehicleTrack
+// Get the track of a vehicle.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: TrackSuccessTrackResponse
+func GetVehicleTrack(w http.ResponseWriter, req *http.Request) {
+	trackParams, err := parseTrackParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := GetVehicleTrackParams{PlateID: mux.Vars(req)["plate_id"], TrackParams: trackParams}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	vehicle, ok := visibleVehicle(w, req, params.PlateID)
+	if !ok {
+		return
+	}
+
+	positions := repository.GetVehiclePositions(vehicle.ID, from, to)
+	sendTrack(w, req, Track{PlateID: vehicle.PlateID}, positions, params.TrackParams)
+}
+
+// swagger:parameters GetAgentTrack
+type GetAgentTrackParams struct {
+
+	// UUID is an unique identifier across agents
+	// in: path
+	// required: true
+	UUID string `json:"uuid"`
+
+	TrackParams
+}
+
+// swagger:route GET /agent/{uuid}/track Agents GetAgentTrack
+// Get the track of an agent.
+//
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: TrackSuccessTrackResponse
+func GetAgentTrack(w http.ResponseWriter, req *http.Request) {
+	trackParams, err := parseTrackParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := GetAgentTrackParams{UUID: mux.Vars(req)["uuid"], TrackParams: trackParams}
+
+	from, to, err := parseTimeRange(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
+	agent, err := repository.GetAgentByUUID(params.UUID)
+	if err == nil && !restriction.MatchAgent(agent) {
+		err = repository.AgentError{What: "Agent", Type: "Not-Found", Arg: params.UUID}
+	}
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Users restricted to groups only get the fixes taken while the agent
+	// was assigned to one of their vehicles.
+	positions := repository.GetAgentPositions(agent.ID, restriction.VehicleIDs(), from, to)
+	sendTrack(w, req, Track{AgentUUID: agent.UUID}, positions, params.TrackParams)
+}
@@ -0,0 +1,232 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+func TestGetVehicleTrackEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	for i, lon := range []string{"40.000", "40.001", "40.002", "40.003", "40.004"} {
+		syncAgent("test", GPSData{Lat: "40", Lon: lon, TS: fmt.Sprintf("%d", 1000+i*10)})
+	}
+	syncAgent("test", GPSData{Lat: "40.01", Lon: "40.004", TS: "1050"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/track", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var track Track
+	err := json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(track.Points); count != 6 {
+		t.Error(errorMsg("len(Points)", "6", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/track?tolerance=10", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	track = Track{}
+	err = json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(track.Points); count != 3 {
+		t.Error(errorMsg("len(Points)", "3", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	if track.Points[1].Lon != 40.004 || track.Points[1].Lat != 40 {
+		t.Error(errorMsg("Points[1]", "40,40.004", fmt.Sprintf("%v,%v", track.Points[1].Lat, track.Points[1].Lon)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/track?bucket=20&from=1010", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	track = Track{}
+	err = json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(track.Points); count != 3 {
+		t.Error(errorMsg("len(Points)", "3", fmt.Sprintf("%d", count)))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/track?max_points=x", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestGetAgentTrackPolyline(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	syncAgent("test", GPSData{Lat: "38.5", Lon: "-120.2", TS: "1000"})
+	syncAgent("test", GPSData{Lat: "40.7", Lon: "-120.95", TS: "1010"})
+	syncAgent("test", GPSData{Lat: "43.252", Lon: "-126.453", TS: "1020"})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/agent/test/track?format=polyline", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var track Track
+	err := json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if track.Polyline != "_p~iF~ps|U_ulLnnqC_mqNvxq`@" {
+		t.Error(errorMsg("Polyline", "_p~iF~ps|U_ulLnnqC_mqNvxq`@", track.Polyline))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/agent/test/track?max_points=2", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	track = Track{}
+	err = json.Unmarshal([]byte(res.Body.String()), &track)
+	if err != nil {
+		t.Error(errorMsg("Track", "Unmarshallable", "NotUnmarshallable"))
+		return
+	}
+
+	if count := len(track.Points); count != 2 {
+		t.Error(errorMsg("len(Points)", "2", fmt.Sprintf("%d", count)))
+		return
+	}
+}
+
+func countColor(img image.Image, c color.RGBA) int {
+	count := 0
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)) == c {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestGetVehicleTrackPNGEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("test")
+	_ = repository.CreateVehicle("test", "test", []int{}, "SCHOOL-BUS")
+	syncAgent("test", GPSData{Lat: "41.00", Lon: "29.00", TS: "1000"})
+	syncAgent("test", GPSData{Lat: "41.01", Lon: "29.01", TS: "1100"})
+	syncAgent("test", GPSData{Lat: "41.01", Lon: "29.01", TS: "1200"})
+	syncAgent("test", GPSData{Lat: "41.01", Lon: "29.01", TS: "1600"})
+	syncAgent("test", GPSData{Lat: "41.02", Lon: "29.03", TS: "1700"})
+	_, _ = repository.CreateGeofence("zone", "ZONE", 0, []geo.Point{
+		{Lat: 41.005, Lon: 29.005}, {Lat: 41.005, Lon: 29.015}, {Lat: 41.015, Lon: 29.015}, {Lat: 41.015, Lon: 29.005},
+	})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", "/vehicle/test/track?format=png&width=400&height=300&geofences=true", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if contentType := res.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Error(errorMsg("Content-Type", "image/png", contentType))
+		return
+	}
+
+	img, err := png.Decode(res.Body)
+	if err != nil {
+		t.Error(errorMsg("PNG", "Decodable", err.Error()))
+		return
+	}
+
+	if size := img.Bounds().Size(); size.X != 400 || size.Y != 300 {
+		t.Error(errorMsg("Size", "400x300", size.String()))
+		return
+	}
+
+	for name, c := range map[string]color.RGBA{"start": startColor, "end": endColor, "stop": stopColor, "track": trackColor} {
+		if countColor(img, c) == 0 {
+			t.Error(errorMsg(name, "drawn", "missing"))
+			return
+		}
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/test/track?format=png&from=5000", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 404 {
+		t.Error(errorMsg("StatusCode", "404", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
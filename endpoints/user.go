@@ -50,6 +50,8 @@ type GetUserParams struct {
 // swagger:route GET /user/{uuid} Users GetUser
 // Get a User by UUID.
 //
+// Users without the manage-users permission can only get themselves.
+//
 //   Security:
 //       Bearer:
 //
@@ -58,7 +60,13 @@ type GetUserParams struct {
 //     200: UserSuccessUserResponse
 func GetUser(w http.ResponseWriter, req *http.Request) {
 	params := GetUserParams{UUID: mux.Vars(req)["uuid"]}
-	//log.Println(params.UUID)
+	tokenOwner, _ := UUIDFromContext(req.Context())
+	role, _ := RoleFromContext(req.Context())
+	if tokenOwner != params.UUID && !repository.RolePermits(role, repository.PERMISSION_MANAGE_USERS) {
+		sendForbidden(w, role, repository.PERMISSION_MANAGE_USERS)
+		return
+	}
+
 	user, _ := repository.GetUserByUUID(params.UUID)
 	if user.ID == 0 {
 		sendErrorMessage(w, "Not found", 404)
@@ -87,6 +95,12 @@ type CreateNewUserParams struct {
 		//
 		// required: false
 		Password string `json:"password"`
+
+		// Role, viewer by default
+		//
+		// required: false
+		// enum: admin,dispatcher,viewer
+		Role string `json:"role"`
 	}
 }
 
@@ -114,9 +128,14 @@ func CreateNewUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := repository.CreateNewUser(
+	if params.Data.Role == "" {
+		params.Data.Role = repository.ROLE_VIEWER
+	}
+
+	user, err := actor(req).CreateNewUser(
 		params.Data.Email,
 		params.Data.Password,
+		params.Data.Role,
 	)
 
 	if err != nil {
@@ -162,7 +181,7 @@ func DeleteUser(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := repository.DeleteUserByUUID(params.UUID)
+	user, err := actor(req).DeleteUserByUUID(params.UUID)
 	if err != nil {
 		sendErrorMessage(w, "User to be deleted, can not be found", http.StatusNotFound)
 		return
@@ -174,15 +193,207 @@ func DeleteUser(w http.ResponseWriter, req *http.Request) {
 	w.Write(j)
 }
 
+// swagger:parameters SetUserGroups
+type SetUserGroupsParams struct {
+
+	// UUID
+	// in: path
+	// required: true
+	UUID string `json:"uuid"`
+
+	// Groups
+	// in: body
+	// required: true
+	Ident struct {
+		Groups []int `json:"groups"`
+	}
+}
+
+// swagger:route PUT /user/{uuid}/groups Users SetUserGroups
+// Restrict a user to the vehicles of groups.
+//
+// WebSocket connections of the user are only sent the vehicles of the
+// groups. An empty list lifts the restriction.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: UserSuccessUserResponse
+func SetUserGroups(w http.ResponseWriter, req *http.Request) {
+	params := SetUserGroupsParams{UUID: mux.Vars(req)["uuid"]}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&params.Ident); err != nil {
+		sendErrorMessage(w, "Error decoding the input", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := repository.GetUserByUUID(params.UUID); err != nil {
+		sendErrorMessage(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := actor(req).SetUserGroups(params.UUID, params.Ident.Groups)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(user)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters SetUserRole
+type SetUserRoleParams struct {
+
+	// UUID
+	// in: path
+	// required: true
+	UUID string `json:"uuid"`
+
+	// Role
+	// in: body
+	// required: true
+	Ident struct {
+
+		// Role
+		//
+		// required: true
+		// enum: admin,dispatcher,viewer
+		Role string `json:"role" valid:"required"`
+	}
+}
+
+// swagger:route PUT /user/{uuid}/role Users SetUserRole
+// Change the role of a user.
+//
+// Admins manage everything, dispatchers manage the vehicles, groups,
+// agents and geofences, and viewers only read. Users can't change their
+// own role.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: UserSuccessUserResponse
+func SetUserRole(w http.ResponseWriter, req *http.Request) {
+	params := SetUserRoleParams{UUID: mux.Vars(req)["uuid"]}
+
+	decoder := json.NewDecoder(req.Body)
+	if err := decoder.Decode(&params.Ident); err != nil {
+		sendErrorMessage(w, "Error decoding the input", http.StatusBadRequest)
+		return
+	}
+	_, err := valid.ValidateStruct(params)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if tokenOwner, _ := UUIDFromContext(req.Context()); tokenOwner == params.UUID {
+		sendErrorMessage(w, "User can not change their own role.", http.StatusForbidden)
+		return
+	}
+
+	if _, err := repository.GetUserByUUID(params.UUID); err != nil {
+		sendErrorMessage(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := actor(req).SetUserRole(params.UUID, params.Ident.Role)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(user)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
 type userKey int
 
-const userUUIDKey userKey = 0
+const (
+	userUUIDKey    userKey = 0
+	userRoleKey    userKey = 1
+	userSessionKey userKey = 2
+)
 
 // NewUUIDContext creates a new ctx with the given UUID.
 func NewUUIDContext(ctx context.Context, uuid string) context.Context {
 	return context.WithValue(ctx, userUUIDKey, uuid)
 }
 
+// NewRoleContext creates a new ctx with the given role.
+func NewRoleContext(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, userRoleKey, role)
+}
+
+// NewSessionContext creates a new ctx with the given session ID.
+func NewSessionContext(ctx context.Context, sessionID uint) context.Context {
+	return context.WithValue(ctx, userSessionKey, sessionID)
+}
+
+// SessionFromContext extracts the session ID from ctx, if present.
+func SessionFromContext(ctx context.Context) (uint, bool) {
+	sessionID, ok := ctx.Value(userSessionKey).(uint)
+	return sessionID, ok
+}
+
+// RoleFromContext extracts the user role from ctx, if present.
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(userRoleKey).(string)
+	return role, ok
+}
+
+// actor returns the actor of the changes made by req: the owner of its
+// token if any.
+func actor(req *http.Request) repository.Actor {
+	uuid, _ := UUIDFromContext(req.Context())
+	return repository.Actor(uuid)
+}
+
+// restriction returns the vehicles the user of req can see, those of the
+// user's groups if any. Anonymous requests see every vehicle. ok is false
+// when the user is gone.
+func restriction(req *http.Request) (filter repository.VehicleFilter, ok bool) {
+	uuid, authenticated := UUIDFromContext(req.Context())
+	if !authenticated {
+		return filter, true
+	}
+	user, err := repository.GetUserByUUID(uuid)
+	if err != nil {
+		return filter, false
+	}
+	return user.VehicleFilter(), true
+}
+
+// visibleVehicle returns the vehicle with the plate id if the user of req
+// can see it. Otherwise it answers 401 when the user is gone, and 404 as
+// if the vehicle didn't exist, and ok is false.
+func visibleVehicle(w http.ResponseWriter, req *http.Request, plateID string) (vehicle repository.Vehicle, ok bool) {
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return vehicle, false
+	}
+	vehicle, err := repository.GetVehicleByPlateID(plateID)
+	if err == nil && !restriction.Match(vehicle) {
+		err = &repository.VehicleError{What: "Vehicle", Type: "Not-Found", Arg: plateID}
+	}
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return vehicle, false
+	}
+	return vehicle, true
+}
+
 // UUIDFromContext extracts the user UUID from ctx, if present.
 func UUIDFromContext(ctx context.Context) (string, bool) {
 	// ctx.Value returns nil if ctx has no value for the key;
@@ -9,7 +9,9 @@ import (
 	"os"
 	"testing"
 
+	"github.com/cad/vehicle-tracker-api/config"
 	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/jinzhu/gorm"
 )
 
 func TestGetAllUsersEndpoint(t *testing.T) {
@@ -19,8 +21,8 @@ func TestGetAllUsersEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
-	_, _ = repository.CreateNewUser("test1@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	_, _ = repository.CreateNewUser("test1@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	// Execute
@@ -51,7 +53,7 @@ func TestGetUserEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	// Execute
@@ -88,7 +90,7 @@ func TestCreateUserEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 	newUserEmail := "test1@test.com"
 	newUserPassword := "1234"
@@ -136,8 +138,8 @@ func TestDeleteUserEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
-	user2, _ := repository.CreateNewUser("test1@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	user2, _ := repository.CreateNewUser("test1@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user2.RenewToken()
 
 	// Execute
@@ -180,7 +182,7 @@ func TestDeleteUserEndpointDeleteSelf(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	// Execute
@@ -198,3 +200,217 @@ func TestDeleteUserEndpointDeleteSelf(t *testing.T) {
 		return
 	}
 }
+
+func TestSetUserGroupsEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	user2, _ := repository.CreateNewUser("test1@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	groupID, _ := repository.CreateNewGroup("north")
+	_ = repository.CreateVehicle("bus1", "", []int{int(groupID)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "", []int{}, "SCHOOL-BUS")
+
+	// Execute
+	body := bytes.NewBufferString(fmt.Sprintf(`{"groups": [%d]}`, groupID))
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/user/%s/groups", user2.UUID), body)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	var updated repository.User
+	json.Unmarshal(res.Body.Bytes(), &updated)
+	if len(updated.Groups) != 1 || updated.Groups[0].Name != "north" {
+		t.Error(errorMsg("Groups", "north", fmt.Sprintf("%v", updated.Groups)))
+		return
+	}
+
+	user2, _ = repository.GetUserByUUID(user2.UUID)
+	vehicles := repository.FilterVehiclesBy(user2.VehicleFilter())
+	if len(vehicles) != 1 || vehicles[0].PlateID != "bus1" {
+		t.Error(errorMsg("Visible vehicles", "bus1", fmt.Sprintf("%d vehicles", len(vehicles))))
+		return
+	}
+
+	// Execute
+	body = bytes.NewBufferString(`{"groups": [42]}`)
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/user/%s/groups", user2.UUID), body)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	admin, _ := repository.CreateNewUser("admin@test.com", "1234", repository.ROLE_ADMIN)
+	dispatcher, _ := repository.CreateNewUser("dispatcher@test.com", "1234", repository.ROLE_DISPATCHER)
+	viewer, _ := repository.CreateNewUser("viewer@test.com", "1234", repository.ROLE_VIEWER)
+	adminToken, _ := admin.RenewToken()
+	dispatcherToken, _ := dispatcher.RenewToken()
+	viewerToken, _ := viewer.RenewToken()
+
+	for _, c := range []struct {
+		method, url, body, token string
+		code                     int
+	}{
+		{"POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, viewerToken, http.StatusForbidden},
+		{"POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, dispatcherToken, http.StatusOK},
+		{"GET", "/events", "", viewerToken, http.StatusForbidden},
+		{"GET", "/events", "", dispatcherToken, http.StatusForbidden},
+		{"GET", "/events", "", adminToken, http.StatusOK},
+		{"POST", "/user/", `{"email": "new@test.com", "password": "1234"}`, dispatcherToken, http.StatusForbidden},
+		{"DELETE", fmt.Sprintf("/user/%s", viewer.UUID), "", dispatcherToken, http.StatusForbidden},
+		{"GET", "/webhook/", "", dispatcherToken, http.StatusForbidden},
+		{"GET", fmt.Sprintf("/user/%s", dispatcher.UUID), "", viewerToken, http.StatusForbidden},
+		{"GET", fmt.Sprintf("/user/%s", viewer.UUID), "", viewerToken, http.StatusOK},
+		{"GET", fmt.Sprintf("/user/%s", viewer.UUID), "", "", http.StatusUnauthorized},
+		{"GET", fmt.Sprintf("/user/%s", viewer.UUID), "", adminToken, http.StatusOK},
+	} {
+		// Execute
+		res := authorized(c.method, c.url, c.body, c.token)
+
+		// Test
+		if res.Code != c.code {
+			t.Error(errorMsg(fmt.Sprintf("%s %s as %s", c.method, c.url, c.token), fmt.Sprintf("%d", c.code), fmt.Sprintf("%d %s", res.Code, res.Body.String())))
+			return
+		}
+	}
+
+	// Execute
+	res := authorized("POST", "/user/", `{"email": "new@test.com", "password": "1234"}`, adminToken)
+
+	// Test
+	var created repository.User
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || created.Role != repository.ROLE_VIEWER {
+		t.Error(errorMsg("Role", repository.ROLE_VIEWER, res.Body.String()))
+		return
+	}
+}
+
+func TestSetUserRoleEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	admin, _ := repository.CreateNewUser("admin@test.com", "1234", repository.ROLE_ADMIN)
+	viewer, _ := repository.CreateNewUser("viewer@test.com", "1234", repository.ROLE_VIEWER)
+	adminToken, _ := admin.RenewToken()
+	viewerToken, _ := viewer.RenewToken()
+
+	// Execute
+	res := authorized("PUT", fmt.Sprintf("/user/%s/role", viewer.UUID), `{"role": "dispatcher"}`, adminToken)
+
+	// Test
+	var user repository.User
+	if err := json.Unmarshal(res.Body.Bytes(), &user); err != nil || user.Role != repository.ROLE_DISPATCHER {
+		t.Error(errorMsg("Role", repository.ROLE_DISPATCHER, res.Body.String()))
+		return
+	}
+
+	if res := authorized("POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, viewerToken); res.Code != http.StatusOK {
+		t.Error(errorMsg("StatusCode as dispatcher", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = authorized("PUT", fmt.Sprintf("/user/%s/role", viewer.UUID), `{"role": "owner"}`, adminToken)
+
+	// Test
+	if res.Code != http.StatusBadRequest {
+		t.Error(errorMsg("StatusCode of an unknown role", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	// Execute
+	res = authorized("PUT", fmt.Sprintf("/user/%s/role", admin.UUID), `{"role": "viewer"}`, adminToken)
+
+	// Test
+	if res.Code != http.StatusForbidden {
+		t.Error(errorMsg("StatusCode of changing one's own role", "403", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+func TestAnonymousReads(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	defer func(anonymous bool) { config.C.Server.AnonymousRead = anonymous }(config.C.Server.AnonymousRead)
+
+	// Prepare
+	_ = repository.CreateVehicle("bus1", "", nil, "SCHOOL-BUS")
+	urls := []string{
+		"/vehicle/",
+		"/vehicle/?as_of=1500000000",
+		"/vehicle/bus1",
+		"/vehicle/bus1/track",
+		"/vehicle/cluster?bbox=26,37,30,42&zoom=8",
+		"/agent/",
+		"/geofence/",
+		"/tiles/2/2/1.mvt",
+	}
+
+	for _, anonymous := range []bool{false, true} {
+		config.C.Server.AnonymousRead = anonymous
+		code := http.StatusUnauthorized
+		if anonymous {
+			code = http.StatusOK
+		}
+		for _, url := range urls {
+			// Execute
+			req, _ := http.NewRequest("GET", url, nil)
+			res := httptest.NewRecorder()
+			GetRouter().ServeHTTP(res, req)
+
+			// Test
+			if res.Code != code {
+				t.Error(errorMsg(fmt.Sprintf("GET %s with anonymous reads %t", url, anonymous), fmt.Sprintf("%d", code), fmt.Sprintf("%d %s", res.Code, res.Body.String())))
+				return
+			}
+		}
+	}
+}
+
+func TestLegacyUsersRoles(t *testing.T) {
+	// Init
+	legacy, _ := gorm.Open("sqlite3", "/tmp/test.db")
+	legacy.Exec("CREATE TABLE users (id integer primary key autoincrement, uuid varchar(255), created_at datetime, updated_at datetime, email varchar(255), password varchar(255))")
+	legacy.Exec("INSERT INTO users (uuid, email) VALUES ('legacy', 'legacy@test.com')")
+	legacy.Close()
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Execute
+	user, err := repository.GetUserByUUID("legacy")
+
+	// Test
+	if err != nil || user.Role != repository.ROLE_VIEWER {
+		t.Error(errorMsg("Role", repository.ROLE_VIEWER, fmt.Sprintf("%s %v", user.Role, err)))
+		return
+	}
+}
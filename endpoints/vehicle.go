@@ -5,13 +5,14 @@ import (
 	"net/http"
 
 	valid "github.com/asaskevich/govalidator"
-	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/config"
 	"github.com/cad/vehicle-tracker-api/repository"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	//"strings"
 	"log"
 	"strconv"
+	"strings"
+	"time"
 	//	"fmt"
 )
 
@@ -58,39 +59,107 @@ type GetVehicleParams struct {
 	// in: path
 	// required: true
 	PlateID string `json:"plate_id"`
+
+	// Format
+	//
+	// "geojson" returns a GeoJSON Feature, as does
+	// `Accept: application/geo+json`.
+	//
+	// in: query
+	// required: false
+	// enum: json,geojson
+	Format string `json:"format"`
 }
 
 // swagger:route GET /vehicle/{plate_id} Vehicles GetVehicle
 // Get a vehicle from database.
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: VehicleSuccessVehicleResponse
 func GetVehicle(w http.ResponseWriter, req *http.Request) {
 	params := GetVehicleParams{PlateID: mux.Vars(req)["plate_id"]}
 
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
 	vehicle, err := repository.GetVehicleByPlateID(params.PlateID)
-	if vehicle.ID == 0 {
+	if vehicle.ID == 0 || !restriction.Match(vehicle) {
 		sendErrorMessage(w, "Not found", 404)
 		return
 	}
 
+	if wantsGeoJSON(req) {
+		sendGeoJSON(w, vehicleFeature(vehicle))
+		return
+	}
+
 	j, err := json.Marshal(vehicle)
 	checkErr(w, err)
 	sendContentType(w, "application/json")
 	w.Write(j)
 }
 
+// swagger:parameters GetAllVehicles
+type GetAllVehiclesParams struct {
+
+	// AsOf
+	//
+	// Return the vehicles as they were at this time, with the agent,
+	// position and groups they had then. Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	AsOf string `json:"as_of"`
+
+	// Format
+	//
+	// "geojson" returns a GeoJSON FeatureCollection of Points, as does
+	// `Accept: application/geo+json`.
+	//
+	// in: query
+	// required: false
+	// enum: json,geojson
+	Format string `json:"format"`
+}
+
 // swagger:route GET /vehicle/ Vehicles GetAllVehicles
 // Get all vehicles in the database.
 //
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: VehicleSuccessVehiclesResponse
 func GetAllVehicles(w http.ResponseWriter, req *http.Request) {
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
+	}
 	var vehicles []repository.Vehicle
-	vehicles = repository.GetAllVehicles()
+	if asOfStr := req.URL.Query().Get("as_of"); asOfStr != "" {
+		asOf, err := repository.ParseTS(asOfStr)
+		if err != nil {
+			sendErrorMessage(w, (&ParamError{Name: "as_of", Type: "a unix timestamp or RFC3339"}).Error(), http.StatusBadRequest)
+			return
+		}
+		vehicles = repository.FilterVehiclesAsOf(asOf, restriction)
+	} else {
+		vehicles = repository.FilterVehiclesBy(restriction)
+	}
+
+	if wantsGeoJSON(req) {
+		sendGeoJSON(w, vehicleFeatureCollection(vehicles))
+		return
+	}
 
 	j, err := json.Marshal(vehicles)
 	checkErr(w, err)
@@ -103,22 +172,34 @@ type FilterVehiclesParams struct {
 
 	// VehicleType
 	//
-	// VehicleType to be filtered.
+	// VehicleTypes to be filtered, comma separated or repeated.
 	// e.g: "SCHOOL-BUS"
 	//
 	//
 	// in: query
 	// required: false
-	VehicleType string `json:"vehicle_type"`
+	// collection format: csv
+	VehicleType []string `json:"vehicle_type"`
 
 	// VehicleGroup
 	//
-	// VehicleGroup id to be filtered.
+	// VehicleGroup ids to be filtered, comma separated or repeated.
+	// Vehicles in any of the groups match.
 	// e.g: 3
 	//
 	// in: query
 	// required: false
-	VehicleGroupID int `json:"vehicle_group_id"`
+	// collection format: csv
+	VehicleGroupID []int `json:"vehicle_group_id"`
+
+	// PlateID
+	//
+	// PlateIDs to be filtered, comma separated or repeated.
+	//
+	// in: query
+	// required: false
+	// collection format: csv
+	PlateID []string `json:"plate_id"`
 
 	// AgentState
 	//
@@ -130,35 +211,145 @@ type FilterVehiclesParams struct {
 	// required: false
 	// enum: ASSIGNED,UNASSIGNED
 	AgentState string `json:"agent_state"`
+
+	// AsOf
+	//
+	// Return the vehicles as they were at this time, with the agent,
+	// position and groups they had then. Unix timestamp or RFC3339.
+	//
+	// in: query
+	// required: false
+	AsOf string `json:"as_of"`
+
+	// Format
+	//
+	// "geojson" returns a GeoJSON FeatureCollection of Points, as does
+	// `Accept: application/geo+json`.
+	//
+	// in: query
+	// required: false
+	// enum: json,geojson
+	Format string `json:"format"`
+
+	asOf time.Time
+}
+
+// queryList returns the values of a query parameter given either repeated
+// or comma separated.
+func queryList(req *http.Request, name string) []string {
+	values := make([]string, 0)
+	for _, value := range req.URL.Query()[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
+
+// queryLimit returns the positive limit query parameter, defaultLimit when
+// it's missing.
+func queryLimit(req *http.Request, defaultLimit int) (int, error) {
+	s := req.URL.Query().Get("limit")
+	if s == "" {
+		return defaultLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err == nil && limit < 1 {
+		err = strconv.ErrRange
+	}
+	return limit, err
+}
+
+func parseFilterVehiclesParams(req *http.Request) (FilterVehiclesParams, error) {
+	params := FilterVehiclesParams{
+		VehicleType:    queryList(req, "vehicle_type"),
+		VehicleGroupID: make([]int, 0),
+		PlateID:        queryList(req, "plate_id"),
+		AgentState:     req.URL.Query().Get("agent_state"),
+		AsOf:           req.URL.Query().Get("as_of"),
+	}
+
+	for _, groupIDStr := range queryList(req, "vehicle_group_id") {
+		groupID, err := strconv.Atoi(groupIDStr)
+		if err != nil {
+			return FilterVehiclesParams{}, &ParamError{Name: "vehicle_group_id", Type: "int"}
+		}
+		params.VehicleGroupID = append(params.VehicleGroupID, groupID)
+	}
+
+	if params.AsOf != "" {
+		var err error
+		params.asOf, err = repository.ParseTS(params.AsOf)
+		if err != nil {
+			return FilterVehiclesParams{}, &ParamError{Name: "as_of", Type: "a unix timestamp or RFC3339"}
+		}
+	}
+
+	return params, nil
+}
+
+func (params FilterVehiclesParams) filter() repository.VehicleFilter {
+	filter := repository.VehicleFilter{
+		Types:      params.VehicleType,
+		PlateIDs:   params.PlateID,
+		AgentState: params.AgentState,
+	}
+	for _, groupID := range params.VehicleGroupID {
+		filter.GroupIDs = append(filter.GroupIDs, uint(groupID))
+	}
+	return filter
+}
+
+// filterVehicles returns the vehicles matching params, as they were at
+// params.AsOf when given.
+func filterVehicles(params FilterVehiclesParams) []repository.Vehicle {
+	if !params.asOf.IsZero() {
+		return repository.FilterVehiclesAsOf(params.asOf, params.filter())
+	}
+	return repository.FilterVehiclesBy(params.filter())
+}
+
+// restrictVehicles keeps the vehicles the restriction lets through.
+func restrictVehicles(vehicles []repository.Vehicle, restriction repository.VehicleFilter) []repository.Vehicle {
+	allowed := make([]repository.Vehicle, 0, len(vehicles))
+	for _, vehicle := range vehicles {
+		if restriction.Match(vehicle) {
+			allowed = append(allowed, vehicle)
+		}
+	}
+	return allowed
 }
 
 // swagger:route GET /vehicle/filter Vehicles FilterVehicles
 // Filter vehicles in the database.
 //
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: VehicleSuccessVehiclesResponse
 func FilterVehicles(w http.ResponseWriter, req *http.Request) {
-	var groupID int
-
-	groupIDStr := req.URL.Query().Get("vehicle_group_id")
-	if groupIDStr != "" {
-		var err error
-		groupID, err = strconv.Atoi(groupIDStr)
-		if err != nil {
-			sendErrorMessage(w, "vehicle_group_id should be int", http.StatusBadRequest)
-			return
-		}
+	params, err := parseFilterVehiclesParams(req)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	params := FilterVehiclesParams{
-		VehicleType:    req.URL.Query().Get("vehicle_type"),
-		VehicleGroupID: groupID,
-		AgentState:     req.URL.Query().Get("agent_state"),
+	restriction, ok := restriction(req)
+	if !ok {
+		sendErrorMessage(w, "Not Authorized", http.StatusUnauthorized)
+		return
 	}
 	var vehicles []repository.Vehicle
-	vehicles = repository.FilterVehicles(params.VehicleType, uint(params.VehicleGroupID), params.AgentState)
+	vehicles = restrictVehicles(filterVehicles(params), restriction)
+
+	if wantsGeoJSON(req) {
+		sendGeoJSON(w, vehicleFeatureCollection(vehicles))
+		return
+	}
 
 	j, err := json.Marshal(vehicles)
 	checkErr(w, err)
@@ -166,109 +357,114 @@ func FilterVehicles(w http.ResponseWriter, req *http.Request) {
 	w.Write(j)
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
 // swagger:parameters FilterVehiclesWS
 type FilterVehiclesWSParams struct {
 
 	// VehicleType
 	//
-	// VehicleType to be filtered.
+	// VehicleTypes to be filtered, comma separated or repeated.
 	// e.g: "SCHOOL-BUS"
 	//
 	//
 	// in: query
 	// required: false
-	VehicleType string `json:"vehicle_type"`
+	// collection format: csv
+	VehicleType []string `json:"vehicle_type"`
 
 	// VehicleGroup
 	//
-	// VehicleGroup id to be filtered.
+	// VehicleGroup ids to be filtered, comma separated or repeated.
+	// Vehicles in any of the groups match.
 	// e.g: 3
 	//
 	// in: query
 	// required: false
-	VehicleGroupID int `json:"vehicle_group_id"`
+	// collection format: csv
+	VehicleGroupID []int `json:"vehicle_group_id"`
+
+	// PlateID
+	//
+	// PlateIDs to be filtered, comma separated or repeated.
+	//
+	// in: query
+	// required: false
+	// collection format: csv
+	PlateID []string `json:"plate_id"`
+
+	// AgentState
+	//
+	// AgentState to be filtered.
+	// "ASSIGNED" or "UNASSIGNED"
+	//
+	//
+	// in: query
+	// required: false
+	// enum: ASSIGNED,UNASSIGNED
+	AgentState string `json:"agent_state"`
+
+	// Token
+	//
+	// User token, for clients that can't send the Authorization header.
+	//
+	// in: query
+	// required: false
+	Token string `json:"token"`
 }
 
 // swagger:route GET /ws/vehicle/filter WebSocket FilterVehiclesWS
 // WebSocket Endpoint for filter vehicles.
 //
-// e.g. wss://api.vehicles.neu.edu.tr/ws/vehicle/filter?vehicle_type=SCHOOL-BUS&vehicle_group_id=2
+// Every filter is optional and they follow the semantics of FilterVehicles.
+// The matching vehicles are sent on connect, then each update.
+//
+// Clients authenticate as for Stream, the only message they may send is
+// an auth StreamRequest. Users restricted to groups are only sent the
+// vehicles of those groups.
+//
+// e.g. wss://api.vehicles.neu.edu.tr/ws/vehicle/filter?vehicle_type=SCHOOL-BUS&vehicle_group_id=2,3&token=6ba7b810-9dad-11d1-80b4-00c04fd430c8
 //
 //   Responses:
+//     default: ErrorMsg
 //     200: VehicleSuccessVehicleResponse
 //
 func FilterVehiclesWS(w http.ResponseWriter, req *http.Request) {
-	groupID, err := strconv.Atoi(req.URL.Query().Get("vehicle_group_id"))
+	params, err := parseFilterVehiclesParams(req)
 	if err != nil {
-		sendErrorMessage(w, "vehicle_group_id should be int", http.StatusBadRequest)
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
 		return
-
 	}
-	params := FilterVehiclesParams{
-		VehicleType:    req.URL.Query().Get("vehicle_type"),
-		VehicleGroupID: groupID,
+
+	user, sessionID, ok := wsUser(w, req)
+	if !ok {
+		return
 	}
 
+	wsParams, anonymous := config.C.WebSocket, config.C.Server.AnonymousRead
 	c, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		log.Print("upgrade:", err)
 		return
 	}
 	defer c.Close()
-	newAgentEvent := event.MakeKind(repository.NEW_AGENT)
-	handler := func(e *event.Event) {
-		agent, ok := e.Payload.(repository.Agent)
-		if !ok {
-			log.Println("Cannot assert type Agent. Ignoring.")
-			return
-		}
-
-		vehicle, err := repository.GetVehicleByAgentUUID(agent.UUID)
-		if err != nil {
-			// Ignore update
-			log.Printf("(%s)", err.Error())
-			log.Println("[WS-EXPORT] Vehicle not found for the Agent streamed from channel. Ignoring.", "Agent.UUID", agent.UUID)
-			return
-		}
-		if params.VehicleType != "" && vehicle.Type != params.VehicleType {
-			// Ignore update
-			return
-		}
 
-		found := false
-		for _, group := range vehicle.Groups {
-			if group.ID == uint(params.VehicleGroupID) {
-				found = true
-			}
-		}
-		if found != true {
-			// Ignore update
-			return
-		}
-
-		err = c.WriteJSON(vehicle)
-		if err != nil {
-			log.Println("[WS-EXPORT] Can't write to WS Connection!. Ignoring.")
-			return
-		}
+	// The filter from the query is the only subscription of a legacy
+	// session. It starts with the matching vehicles, one message each, and
+	// vehicles are written without envelope.
+	session := newStreamSession(c, true, user, sessionID, wsParams)
+	defer session.close()
+	if user == nil && !anonymous && !session.awaitAuth() {
+		return
 	}
-	newAgentEvent.Register(&handler)
-	defer newAgentEvent.UnRegister(&handler)
-
-	for {
-		_, _, err := c.ReadMessage()
-		if err != nil {
-			log.Println("Client disconnected")
-			break
-		}
+	err = session.add(&streamSubscription{
+		ID:      "legacy",
+		Channel: STREAM_VEHICLES,
+		filter:  params.filter(),
+	})
+	if err != nil {
+		log.Println("[WS-EXPORT] Slow consumer. Closing.")
+		return
 	}
-
+	session.serve()
 }
 
 // swagger:parameters CreateNewVehicle
@@ -325,7 +521,7 @@ func CreateNewVehicle(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repository.CreateVehicle(
+	err = actor(req).CreateVehicle(
 		params.Ident.PlateID,
 		params.Ident.AgentUUID,
 		params.Ident.Groups,
@@ -392,7 +588,7 @@ func VehicleSetAgent(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repository.VehicleSetAgent(params.PlateID, params.Agent.UUID)
+	err = actor(req).VehicleSetAgent(params.PlateID, params.Agent.UUID)
 	if err != nil {
 		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
 		return
@@ -436,7 +632,7 @@ func VehicleUnsetAgent(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repository.VehicleUnsetAgent(params.PlateID)
+	err = actor(req).VehicleUnsetAgent(params.PlateID)
 	if err != nil {
 		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
 		return
@@ -480,7 +676,7 @@ func DeleteVehicle(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	error := repository.DeleteVehicleByPlateID(params.PlateID)
+	error := actor(req).DeleteVehicleByPlateID(params.PlateID)
 	if error != nil {
 		sendErrorMessage(w, "There is no vehicle with that ID", http.StatusNotFound)
 		return
@@ -532,7 +728,7 @@ func CreateNewGroup(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	groupID, err := repository.CreateNewGroup(
+	groupID, err := actor(req).CreateNewGroup(
 		params.Group.Name,
 	)
 
@@ -587,7 +783,7 @@ func DeleteGroup(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repository.DeleteGroup(uint(groupID))
+	err = actor(req).DeleteGroup(uint(groupID))
 	if err != nil {
 		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
 		return
@@ -640,7 +836,7 @@ func SetVehicleGroups(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	err = repository.SetVehicleGroups(params.PlateID, params.Ident.Groups)
+	err = actor(req).SetVehicleGroups(params.PlateID, params.Ident.Groups)
 	if err != nil {
 		sendErrorMessage(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -662,6 +858,9 @@ func SetVehicleGroups(w http.ResponseWriter, req *http.Request) {
 // Get possible vehicle types defined in the system.
 //
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: VehicleSuccessVehicleTypesResponse
@@ -678,6 +877,9 @@ func GetAllTypes(w http.ResponseWriter, req *http.Request) {
 // Get all vehicle groups in the database.
 //
 //
+//   Security:
+//       Bearer:
+//
 //   Responses:
 //     default: ErrorMsg
 //     200: VehicleSuccessVehicleGroupsResponse
@@ -2,14 +2,22 @@ package endpoints
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/geo"
 	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
 )
 
 func TestGetAllVehiclesEndpoint(t *testing.T) {
@@ -27,9 +35,11 @@ func TestGetAllVehiclesEndpoint(t *testing.T) {
 		[]int{int(groupID)},
 		"SCHOOL-BUS",
 	)
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/vehicle/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -92,7 +102,7 @@ func TestCreateNewVehicleEndpoint(t *testing.T) {
 		// required: true
 		Type string `json:"type" valid:"required"`
 	}
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	//agent, _ := repository.CreateNewAgent("string")
@@ -192,9 +202,11 @@ func TestGetVehicleEndpoint(t *testing.T) {
 		[]int{int(groupID)},
 		"SCHOOL-BUS",
 	)
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/vehicle/test", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -240,7 +252,7 @@ func TestDeleteVehicleEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 	agent, _ := repository.CreateNewAgent("string")
 	groupID, _ := repository.CreateNewGroup("string")
@@ -281,7 +293,7 @@ func TestDeleteVehicleEndpointEmptyInput(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 	agent, _ := repository.CreateNewAgent("string")
 	groupID, _ := repository.CreateNewGroup("string")
@@ -321,7 +333,7 @@ func TestSetVehicleAgentEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	agent, _ := repository.CreateNewAgent("string")
@@ -379,7 +391,7 @@ func TestUnsetVehicleAgentEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	agent, _ := repository.CreateNewAgent("string")
@@ -448,9 +460,11 @@ func TestFilterVehicleEndpoint(t *testing.T) {
 		[]int{int(newGroupID)},
 		"SCHOOL-BUS",
 	)
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", fmt.Sprintf("/vehicle/filter?vehicle_group_id=%d", newGroupID), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -481,7 +495,7 @@ func TestCreateVehicleGroupEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
-	user, _ := repository.CreateNewUser("test@test.com", "1234")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
 	token, _ := user.RenewToken()
 
 	params := CreateNewGroupParams{
@@ -533,9 +547,11 @@ func TestGetAllVehicleGroupsEndpoint(t *testing.T) {
 
 	// Prepare
 	repository.CreateNewGroup("string")
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/vehicle/group/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -577,9 +593,11 @@ func TestGetAllVehicleTypesEndpoint(t *testing.T) {
 	defer os.Remove("/tmp/test.db")
 
 	// Prepare
+	token := viewerToken()
 
 	// Execute
 	req, _ := http.NewRequest("GET", "/vehicle/type/", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	res := httptest.NewRecorder()
 	GetRouter().ServeHTTP(res, req)
 
@@ -607,3 +625,380 @@ func TestGetAllVehicleTypesEndpoint(t *testing.T) {
 		return
 	}
 }
+
+// Test the fleet snapshot at a point in time
+func TestGetAllVehiclesAsOfEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	north, _ := repository.CreateNewGroup("north")
+	south, _ := repository.CreateNewGroup("south")
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("test", "agent1", []int{int(north)}, "SCHOOL-BUS")
+	now := time.Now().Unix()
+	syncAgent("agent1", GPSData{Lat: "41", Lon: "29", TS: fmt.Sprintf("%d", now-10)})
+	time.Sleep(10 * time.Millisecond)
+	asOf := time.Now().UnixNano() / int64(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	_ = repository.VehicleSetAgent("test", "agent2")
+	_ = repository.SetVehicleGroups("test", []int{int(south)})
+	_ = repository.DeleteGroup(north)
+	syncAgent("agent2", GPSData{Lat: "38", Lon: "27", TS: fmt.Sprintf("%d", now+10)})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/vehicle/?as_of=%d", asOf), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var vehicles []repository.Vehicle
+	err := json.Unmarshal([]byte(res.Body.String()), &vehicles)
+	if err != nil || len(vehicles) != 1 {
+		t.Error(errorMsg("Vehicles", "1 vehicle", res.Body.String()))
+		return
+	}
+
+	vehicle := vehicles[0]
+	if vehicle.Agent == nil || vehicle.Agent.UUID != "agent1" {
+		t.Error(errorMsg("Agent.UUID", "agent1", fmt.Sprintf("%v", vehicle.Agent)))
+		return
+	}
+
+	if vehicle.Agent.Lat != "41" {
+		t.Error(errorMsg("Agent.Lat", "41", vehicle.Agent.Lat))
+		return
+	}
+
+	if len(vehicle.Groups) != 1 || vehicle.Groups[0].Name != "north" {
+		t.Error(errorMsg("Groups", "north", fmt.Sprintf("%d groups", len(vehicle.Groups))))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/vehicle/filter?as_of=%d&vehicle_group_id=%d", asOf, south), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	err = json.Unmarshal([]byte(res.Body.String()), &vehicles)
+	if err != nil || len(vehicles) != 0 {
+		t.Error(errorMsg("Vehicles", "none in south", res.Body.String()))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", "/vehicle/?as_of=yesterday", nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	if res.Code != 400 {
+		t.Error(errorMsg("StatusCode", "400", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+// Test the fleet snapshot at a point in time before a vehicle was deleted
+func TestGetAllVehiclesAsOfDeletedVehicle(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	north, _ := repository.CreateNewGroup("north")
+	_, _ = repository.CreateNewAgent("agent1")
+	_ = repository.CreateVehicle("gone", "agent1", []int{int(north)}, "SCHOOL-BUS")
+	now := time.Now().Unix()
+	syncAgent("agent1", GPSData{Lat: "41", Lon: "29", TS: fmt.Sprintf("%d", now-10)})
+	time.Sleep(10 * time.Millisecond)
+	asOf := time.Now().UnixNano() / int64(time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	_ = repository.DeleteVehicleByPlateID("gone")
+	syncAgent("agent1", GPSData{Lat: "38", Lon: "27", TS: fmt.Sprintf("%d", now+10)})
+	token := viewerToken()
+
+	// Execute
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/vehicle/?as_of=%d", asOf), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	var vehicles []repository.Vehicle
+	err := json.Unmarshal([]byte(res.Body.String()), &vehicles)
+	if err != nil || len(vehicles) != 1 || vehicles[0].PlateID != "gone" {
+		t.Error(errorMsg("Vehicles", "gone", res.Body.String()))
+		return
+	}
+
+	vehicle := vehicles[0]
+	if vehicle.Agent == nil || vehicle.Agent.UUID != "agent1" || vehicle.Agent.Lat != "41" {
+		t.Error(errorMsg("Agent", "agent1 at 41", fmt.Sprintf("%v", vehicle.Agent)))
+		return
+	}
+
+	if len(vehicle.Groups) != 1 || vehicle.Groups[0].Name != "north" {
+		t.Error(errorMsg("Groups", "north", fmt.Sprintf("%d groups", len(vehicle.Groups))))
+		return
+	}
+
+	// Execute
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/vehicle/?as_of=%d", time.Now().Unix()+60), nil)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res = httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+
+	// Test
+	err = json.Unmarshal([]byte(res.Body.String()), &vehicles)
+	if err != nil || len(vehicles) != 0 {
+		t.Error(errorMsg("Vehicles", "none", res.Body.String()))
+		return
+	}
+
+	agent, _ := repository.GetAgentByUUID("agent1")
+	positions := repository.GetAgentPositions(agent.ID, nil, time.Time{}, time.Time{})
+	if len(positions) != 2 || positions[1].VehicleID != 0 {
+		t.Error(errorMsg("Positions", "the later one without a vehicle", fmt.Sprintf("%+v", positions)))
+		return
+	}
+}
+
+// Test filtering with several values per filter
+func TestFilterVehicleEndpointMultipleValues(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	north, _ := repository.CreateNewGroup("north")
+	south, _ := repository.CreateNewGroup("south")
+	east, _ := repository.CreateNewGroup("east")
+	_, _ = repository.CreateNewAgent("agent1")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{int(north), int(south)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "", []int{int(south)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("car1", "", []int{int(east)}, "SOLAR-CAR")
+	_ = repository.CreateVehicle("car2", "", []int{int(north)}, "SOLAR-CAR")
+	token := viewerToken()
+
+	for query, expected := range map[string]string{
+		fmt.Sprintf("vehicle_group_id=%d,%d", north, east):                       "bus1 car1 car2",
+		fmt.Sprintf("vehicle_group_id=%d&vehicle_group_id=%d", north, south):     "bus1 bus2 car2",
+		"vehicle_type=SCHOOL-BUS":                                                "bus1 bus2",
+		"vehicle_type=SCHOOL-BUS,SOLAR-CAR&plate_id=bus2,car1":                   "bus2 car1",
+		"agent_state=UNASSIGNED&vehicle_type=SCHOOL-BUS":                         "bus2",
+		fmt.Sprintf("agent_state=ASSIGNED&vehicle_group_id=%d,%d", north, south): "bus1",
+	} {
+		// Execute
+		req, _ := http.NewRequest("GET", "/vehicle/filter?"+query, nil)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		res := httptest.NewRecorder()
+		GetRouter().ServeHTTP(res, req)
+
+		// Test
+		var vehicles []repository.Vehicle
+		err := json.Unmarshal([]byte(res.Body.String()), &vehicles)
+		if err != nil {
+			t.Error(errorMsg("Vehicles", "Unmarshallable", res.Body.String()))
+			return
+		}
+
+		plateIDs := make([]string, 0)
+		for _, vehicle := range vehicles {
+			plateIDs = append(plateIDs, vehicle.PlateID)
+		}
+		sort.Strings(plateIDs)
+		if got := strings.Join(plateIDs, " "); got != expected {
+			t.Error(errorMsg(query, expected, got))
+			return
+		}
+	}
+}
+
+// Test that users restricted to groups only get the vehicles of those
+func TestGetVehiclesRestricted(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	north, _ := repository.CreateNewGroup("north")
+	_ = repository.CreateVehicle("bus1", "", []int{int(north)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "", []int{}, "SCHOOL-BUS")
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	token, _ := user.RenewToken()
+	_, _ = repository.SetUserGroups(user.UUID, []int{int(north)})
+
+	for _, url := range []string{"/vehicle/", "/vehicle/filter?vehicle_type=SCHOOL-BUS"} {
+		// Execute
+		res := authorized("GET", url, "", token)
+
+		// Test
+		var vehicles []repository.Vehicle
+		err := json.Unmarshal(res.Body.Bytes(), &vehicles)
+		if err != nil || len(vehicles) != 1 || vehicles[0].PlateID != "bus1" {
+			t.Error(errorMsg(url, "bus1", res.Body.String()))
+			return
+		}
+	}
+
+	// Execute
+	res := authorized("GET", "/vehicle/bus2", "", token)
+
+	// Test
+	if res.Code != 404 {
+		t.Error(errorMsg("StatusCode", "404", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+}
+
+// Test that users restricted to groups can't read the tracks, events and
+// positions of the other vehicles
+func TestVehicleRoutesRestricted(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	config.C.Speeding.TypeLimits = map[string]float64{"SCHOOL-BUS": 50}
+	defer func() { config.C.Speeding.TypeLimits = nil }()
+
+	// Prepare
+	north, _ := repository.CreateNewGroup("north")
+	south, _ := repository.CreateNewGroup("south")
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{int(north)}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("bus2", "agent2", []int{int(south)}, "SCHOOL-BUS")
+	for i, speed := range []string{"30", "70", "40"} {
+		ts := fmt.Sprintf("%d", 1000+i*10)
+		syncAgent("agent1", GPSData{Lat: "41.0010", Lon: "29.0010", TS: ts, Speed: speed})
+		syncAgent("agent2", GPSData{Lat: "38.0010", Lon: "27.0010", TS: ts, Speed: speed})
+	}
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_VIEWER)
+	token, _ := user.RenewToken()
+	_, _ = repository.SetUserGroups(user.UUID, []int{int(north)})
+
+	for _, route := range []string{"/vehicle/%s/track", "/vehicle/%s/overspeed", "/vehicle/%s/idle", "/vehicle/%s/stats"} {
+		// Execute
+		visible := authorized("GET", fmt.Sprintf(route, "bus1"), "", token)
+		hidden := authorized("GET", fmt.Sprintf(route, "bus2"), "", token)
+
+		// Test
+		if visible.Code != 200 || hidden.Code != 404 {
+			t.Error(errorMsg(route, "200 for bus1, 404 for bus2", fmt.Sprintf("%d, %d", visible.Code, hidden.Code)))
+			return
+		}
+	}
+
+	// Execute
+	visible := authorized("GET", "/agent/agent1/track", "", token)
+	hidden := authorized("GET", "/agent/agent2/track", "", token)
+
+	// Test
+	if visible.Code != 200 || hidden.Code != 404 {
+		t.Error(errorMsg("Agent track", "200 for agent1, 404 for agent2", fmt.Sprintf("%d, %d", visible.Code, hidden.Code)))
+		return
+	}
+
+	// Execute
+	res := authorized("GET", "/agent/", "", token)
+
+	// Test
+	var agents []repository.Agent
+	if err := json.Unmarshal(res.Body.Bytes(), &agents); err != nil || len(agents) != 1 || agents[0].UUID != "agent1" {
+		t.Error(errorMsg("Agents", "agent1", res.Body.String()))
+		return
+	}
+
+	for groupID, count := range map[uint]int{north: 1, south: 0} {
+		// Execute
+		res = authorized("GET", fmt.Sprintf("/vehicle/group/%d/overspeed", groupID), "", token)
+
+		// Test
+		var overspeeds []repository.Overspeed
+		if err := json.Unmarshal(res.Body.Bytes(), &overspeeds); err != nil || len(overspeeds) != count {
+			t.Error(errorMsg(fmt.Sprintf("Overspeeds of group %d", groupID), fmt.Sprintf("%d", count), res.Body.String()))
+			return
+		}
+	}
+
+	// Execute
+	res = authorized("GET", "/vehicle/cluster?bbox=26,37,30,42&zoom=8", "", token)
+
+	// Test
+	var clusters VehicleClusters
+	if err := json.Unmarshal(res.Body.Bytes(), &clusters); err != nil || len(clusters.Clusters) != 0 || len(clusters.Vehicles) != 1 || clusters.Vehicles[0].PlateID != "bus1" {
+		t.Error(errorMsg("Clusters", "bus1", res.Body.String()))
+		return
+	}
+
+	for point, count := range map[geo.Point]int{{Lat: 41.0010, Lon: 29.0010}: 1, {Lat: 38.0010, Lon: 27.0010}: 0} {
+		// Execute
+		px, py := geo.Project(point, 12)
+		res = authorized("GET", fmt.Sprintf("/tiles/12/%d/%d.mvt?layers=vehicles,density", int(px)/geo.TileSize, int(py)/geo.TileSize), "", token)
+
+		// Test
+		layers := decodeTile(res.Body.Bytes())
+		if layers["vehicles"].features != count || layers["density"].features != count {
+			t.Error(errorMsg(fmt.Sprintf("Tile at %v", point), fmt.Sprintf("%d vehicles, %d cells", count, count), fmt.Sprintf("%+v", layers)))
+			return
+		}
+	}
+}
+
+// Test the WebSocket filter without any group
+func TestFilterVehiclesWSEndpoint(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	server := httptest.NewServer(GetRouter())
+	defer server.Close()
+
+	// Prepare
+	_, _ = repository.CreateNewAgent("agent1")
+	_, _ = repository.CreateNewAgent("agent2")
+	_ = repository.CreateVehicle("bus1", "agent1", []int{}, "SCHOOL-BUS")
+	_ = repository.CreateVehicle("car1", "agent2", []int{}, "SOLAR-CAR")
+	url := strings.Replace(server.URL, "http", "ws", 1) + "/ws/vehicle/filter?vehicle_type=SCHOOL-BUS&token=" + viewerToken()
+	c, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Error(errorMsg("Dial", "Connected", err.Error()))
+		return
+	}
+	defer c.Close()
+
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var snapshot repository.Vehicle
+	err = c.ReadJSON(&snapshot)
+
+	// Test
+	if err != nil || snapshot.PlateID != "bus1" {
+		t.Error(errorMsg("PlateID", "bus1", fmt.Sprintf("%s (%v)", snapshot.PlateID, err)))
+		return
+	}
+
+	// Execute
+	syncAgent("agent2", GPSData{Lat: "40", Lon: "28", TS: "1000"})
+	syncAgent("agent1", GPSData{Lat: "41", Lon: "29", TS: "1000"})
+	var vehicle repository.Vehicle
+	err = c.ReadJSON(&vehicle)
+
+	// Test
+	if err != nil || vehicle.PlateID != "bus1" || vehicle.Agent.Lat != "41" {
+		t.Error(errorMsg("Vehicle", "bus1 at 41", fmt.Sprintf("%s (%v)", vehicle.PlateID, err)))
+		return
+	}
+}
@@ -0,0 +1,307 @@
+package endpoints
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	valid "github.com/asaskevich/govalidator"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/cad/vehicle-tracker-api/webhook"
+	"github.com/gorilla/mux"
+)
+
+// DELIVERY_LIMIT is the default number of deliveries listed.
+const DELIVERY_LIMIT = 100
+
+// swagger:route GET /webhook/ Webhooks GetAllWebhooks
+// Get all webhooks.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessWebhooksResponse
+func GetAllWebhooks(w http.ResponseWriter, req *http.Request) {
+	var webhooks []repository.Webhook
+	webhooks = repository.GetAllWebhooks()
+	j, err := json.Marshal(webhooks)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters CreateNewWebhook
+type CreateNewWebhookParams struct {
+
+	// Webhook
+	// in: body
+	// required: true
+	Webhook struct {
+
+		// URL the events are posted to
+		//
+		// required: true
+		URL string `json:"url" valid:"required"`
+
+		// Secret the payloads are signed with, random if empty
+		//
+		// required: false
+		Secret string `json:"secret"`
+
+		// Kinds of the events to post, e.g. "GEOFENCE-ENTER",
+		// "OVERSPEED-START" or "VEHICLE-CREATED"
+		//
+		// required: true
+		Kinds []string `json:"kinds"`
+
+		// PlateIDs restricts the events to those about the vehicles
+		//
+		// required: false
+		PlateIDs []string `json:"plate_ids"`
+
+		// GroupIDs restricts the events to those about the vehicles of
+		// the groups
+		//
+		// required: false
+		GroupIDs []uint `json:"group_ids"`
+	}
+}
+
+// swagger:route POST /webhook/ Webhooks CreateNewWebhook
+// Register a webhook.
+//
+// The events are posted as JSON, signed with HMAC-SHA256 keyed with the
+// secret in the X-Webhook-Signature header. Deliveries not answered with
+// a 2xx status are retried with exponential backoff.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessCreatedResponse
+func CreateNewWebhook(w http.ResponseWriter, req *http.Request) {
+	var params CreateNewWebhookParams
+
+	decoder := json.NewDecoder(req.Body)
+
+	if err := decoder.Decode(&params.Webhook); err != nil {
+		sendErrorMessage(w, "Error decoding the input", http.StatusBadRequest)
+		return
+	}
+	_, err := valid.ValidateStruct(params)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hook, err := repository.CreateWebhook(
+		params.Webhook.URL,
+		params.Webhook.Secret,
+		params.Webhook.Kinds,
+		params.Webhook.PlateIDs,
+		params.Webhook.GroupIDs,
+	)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(WebhookCreated{Webhook: hook, Secret: hook.Secret})
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters DeleteWebhook
+type DeleteWebhookParams struct {
+
+	// WebhookID
+	// in: path
+	// required: true
+	ID string `json:"webhook_id"`
+}
+
+// swagger:route DELETE /webhook/{webhook_id} Webhooks DeleteWebhook
+// Delete a webhook and its deliveries.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessWebhookResponse
+func DeleteWebhook(w http.ResponseWriter, req *http.Request) {
+	params := DeleteWebhookParams{ID: mux.Vars(req)["webhook_id"]}
+
+	webhookID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "webhook_id should be int", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := repository.GetWebhookByID(uint(webhookID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = repository.DeleteWebhook(uint(webhookID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	j, err := json.Marshal(hook)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters GetWebhookDeliveries
+type GetWebhookDeliveriesParams struct {
+
+	// WebhookID
+	// in: path
+	// required: true
+	ID string `json:"webhook_id"`
+
+	// Status
+	//
+	// Only the deliveries in the status
+	//
+	// in: query
+	// required: false
+	// enum: PENDING,IN-FLIGHT,DELIVERED,DEAD
+	Status string `json:"status"`
+
+	// Limit
+	//
+	// Number of deliveries, 100 by default
+	//
+	// in: query
+	// required: false
+	Limit int `json:"limit"`
+}
+
+// swagger:route GET /webhook/{webhook_id}/delivery Webhooks GetWebhookDeliveries
+// Get the delivery log of a webhook, latest first.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessDeliveriesResponse
+func GetWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	params := GetWebhookDeliveriesParams{
+		ID:     mux.Vars(req)["webhook_id"],
+		Status: req.URL.Query().Get("status"),
+	}
+
+	webhookID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "webhook_id should be int", http.StatusBadRequest)
+		return
+	}
+	if params.Limit, err = queryLimit(req, DELIVERY_LIMIT); err != nil {
+		sendErrorMessage(w, "limit should be a positive int", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := repository.GetWebhookByID(uint(webhookID)); err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	deliveries := repository.GetWebhookDeliveries(uint(webhookID), params.Status, params.Limit)
+	j, err := json.Marshal(deliveries)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters GetDeadWebhookDeliveries
+type GetDeadWebhookDeliveriesParams struct {
+
+	// Limit
+	//
+	// Number of deliveries, 100 by default
+	//
+	// in: query
+	// required: false
+	Limit int `json:"limit"`
+}
+
+// swagger:route GET /webhook/delivery/dead Webhooks GetDeadWebhookDeliveries
+// Get the dead letters: the deliveries of every webhook whose attempts
+// all failed, latest first.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessDeliveriesResponse
+func GetDeadWebhookDeliveries(w http.ResponseWriter, req *http.Request) {
+	var params GetDeadWebhookDeliveriesParams
+
+	var err error
+	if params.Limit, err = queryLimit(req, DELIVERY_LIMIT); err != nil {
+		sendErrorMessage(w, "limit should be a positive int", http.StatusBadRequest)
+		return
+	}
+
+	deliveries := repository.GetWebhookDeliveries(0, repository.DELIVERY_DEAD, params.Limit)
+	j, err := json.Marshal(deliveries)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
+
+// swagger:parameters RetryWebhookDelivery
+type RetryWebhookDeliveryParams struct {
+
+	// DeliveryID
+	// in: path
+	// required: true
+	ID string `json:"delivery_id"`
+}
+
+// swagger:route POST /webhook/delivery/{delivery_id}/retry Webhooks RetryWebhookDelivery
+// Attempt a dead delivery again.
+//
+//   Security:
+//       Bearer:
+//
+//   Responses:
+//     default: ErrorMsg
+//     200: WebhookSuccessDeliveryResponse
+func RetryWebhookDelivery(w http.ResponseWriter, req *http.Request) {
+	params := RetryWebhookDeliveryParams{ID: mux.Vars(req)["delivery_id"]}
+
+	deliveryID, err := strconv.Atoi(params.ID)
+	if err != nil {
+		sendErrorMessage(w, "delivery_id should be int", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := repository.GetWebhookDeliveryByID(uint(deliveryID)); err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	delivery, err := repository.RetryWebhookDelivery(uint(deliveryID))
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusConflict)
+		return
+	}
+	webhook.Wake()
+
+	j, err := json.Marshal(delivery)
+	checkErr(w, err)
+	sendContentType(w, "application/json")
+	w.Write(j)
+}
@@ -0,0 +1,338 @@
+package endpoints
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/cad/vehicle-tracker-api/webhook"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookTarget records the requests posted to it, answering them with
+// the status in code.
+func webhookTarget(code *int32) (*httptest.Server, chan webhookRequest) {
+	requests := make(chan webhookRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(int(atomic.LoadInt32(code)))
+	}))
+	return server, requests
+}
+
+func authorized(method string, url string, body string, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	res := httptest.NewRecorder()
+	GetRouter().ServeHTTP(res, req)
+	return res
+}
+
+// waitForDeliveries waits until the webhook has n deliveries in status.
+func waitForDeliveries(webhookID uint, status string, n int) []repository.WebhookDelivery {
+	var deliveries []repository.WebhookDelivery
+	for i := 0; i < 100; i++ {
+		deliveries = repository.GetWebhookDeliveries(webhookID, status, DELIVERY_LIMIT)
+		if len(deliveries) >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return deliveries
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	defaults := config.C.Webhook
+	defer func() { config.C.Webhook = defaults }()
+	config.C.Webhook.PollInterval = 0.05
+	webhook.Start()
+	defer webhook.Stop()
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	code := int32(http.StatusOK)
+	target, requests := webhookTarget(&code)
+	defer target.Close()
+
+	// Execute
+	res := authorized("POST", "/webhook/", fmt.Sprintf(`{"url": "%s", "kinds": ["VEHICLE-CREATED"], "plate_ids": ["bus1"]}`, target.URL), token)
+
+	// Test
+	var created WebhookCreated
+	if err := json.Unmarshal(res.Body.Bytes(), &created); err != nil || res.Code != 200 {
+		t.Error(errorMsg("Webhook", "Created", res.Body.String()))
+		return
+	}
+
+	if created.Secret == "" {
+		t.Error(errorMsg("Secret", "Generated", "Empty"))
+		return
+	}
+
+	// Execute
+	authorized("POST", "/vehicle/", `{"plate_id": "car1", "type": "SOLAR-CAR"}`, token)
+	authorized("POST", "/vehicle/", `{"plate_id": "bus1", "type": "SCHOOL-BUS"}`, token)
+
+	// Test
+	var request webhookRequest
+	select {
+	case request = <-requests:
+	case <-time.After(2 * time.Second):
+		t.Error(errorMsg("Request", "Posted", "Timeout"))
+		return
+	}
+
+	if kind := request.header.Get("X-Webhook-Event"); kind != repository.VEHICLE_CREATED {
+		t.Error(errorMsg("X-Webhook-Event", repository.VEHICLE_CREATED, kind))
+		return
+	}
+
+	if signature := request.header.Get("X-Webhook-Signature"); signature != webhook.Sign(created.Secret, request.body) {
+		t.Error(errorMsg("X-Webhook-Signature", webhook.Sign(created.Secret, request.body), signature))
+		return
+	}
+
+	var payload webhook.Payload
+	if err := json.Unmarshal(request.body, &payload); err != nil || payload.Key != "bus1" || payload.Kind != repository.VEHICLE_CREATED {
+		t.Error(errorMsg("Payload", "bus1 created", string(request.body)))
+		return
+	}
+
+	// Execute
+	waitForDeliveries(created.ID, repository.DELIVERY_DELIVERED, 1)
+	res = authorized("GET", fmt.Sprintf("/webhook/%d/delivery", created.ID), "", token)
+
+	// Test
+	var deliveries []repository.WebhookDelivery
+	if err := json.Unmarshal(res.Body.Bytes(), &deliveries); err != nil {
+		t.Error(errorMsg("Deliveries", "Unmarshallable", res.Body.String()))
+		return
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Status != repository.DELIVERY_DELIVERED || deliveries[0].ResponseCode != 200 {
+		t.Error(errorMsg("Deliveries", "1 delivered", res.Body.String()))
+		return
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	defaults := config.C.Webhook
+	defer func() { config.C.Webhook = defaults }()
+	config.C.Webhook.PollInterval = 0.05
+	config.C.Webhook.RetryBase = 0.01
+	config.C.Webhook.MaxAttempts = 2
+	webhook.Start()
+	defer webhook.Stop()
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	code := int32(http.StatusInternalServerError)
+	target, _ := webhookTarget(&code)
+	defer target.Close()
+	hook, _ := repository.CreateWebhook(target.URL, "secret", []string{repository.GROUP_CREATED}, nil, nil)
+
+	// Execute
+	authorized("POST", "/vehicle/group/", `{"name": "group1"}`, token)
+	waitForDeliveries(hook.ID, repository.DELIVERY_DEAD, 1)
+	res := authorized("GET", "/webhook/delivery/dead", "", token)
+
+	// Test
+	var deliveries []repository.WebhookDelivery
+	if err := json.Unmarshal(res.Body.Bytes(), &deliveries); err != nil {
+		t.Error(errorMsg("Dead letters", "Unmarshallable", res.Body.String()))
+		return
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Attempts != 2 || deliveries[0].ResponseCode != 500 {
+		t.Error(errorMsg("Dead letters", "1 after 2 attempts", res.Body.String()))
+		return
+	}
+
+	// Execute
+	atomic.StoreInt32(&code, http.StatusOK)
+	res = authorized("POST", fmt.Sprintf("/webhook/delivery/%d/retry", deliveries[0].ID), "", token)
+	delivered := waitForDeliveries(hook.ID, repository.DELIVERY_DELIVERED, 1)
+
+	// Test
+	if res.Code != 200 {
+		t.Error(errorMsg("StatusCode", "200", fmt.Sprintf("%d", res.Code)))
+		return
+	}
+
+	if len(delivered) != 1 || delivered[0].Attempts != 1 {
+		t.Error(errorMsg("Retried", "delivered at first attempt", fmt.Sprintf("%+v", delivered)))
+		return
+	}
+}
+
+func TestWebhookEventsLoggedWhileStopped(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+	event.Run()
+	defer event.Shutdown(context.Background())
+	defaults := config.C.Webhook
+	defer func() { config.C.Webhook = defaults }()
+	config.C.Webhook.PollInterval = 0.05
+
+	// Prepare
+	user, _ := repository.CreateNewUser("test@test.com", "1234", repository.ROLE_ADMIN)
+	token, _ := user.RenewToken()
+	code := int32(http.StatusOK)
+	target, requests := webhookTarget(&code)
+	defer target.Close()
+	hook, _ := repository.CreateWebhook(target.URL, "secret", []string{repository.GROUP_CREATED}, nil, nil)
+	authorized("POST", "/vehicle/group/", `{"name": "group1"}`, token)
+
+	// Execute
+	webhook.Start()
+	defer webhook.Stop()
+	delivered := waitForDeliveries(hook.ID, repository.DELIVERY_DELIVERED, 1)
+	webhook.Stop()
+	webhook.Start()
+	authorized("POST", "/vehicle/group/", `{"name": "group2"}`, token)
+	delivered = waitForDeliveries(hook.ID, repository.DELIVERY_DELIVERED, 2)
+
+	// Test
+	if len(delivered) != 2 || len(requests) != 2 {
+		t.Error(errorMsg("Deliveries", "1 per event", fmt.Sprintf("%d deliveries, %d requests", len(delivered), len(requests))))
+		return
+	}
+}
+
+func TestWebhookDeliveryClaims(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	hook, _ := repository.CreateWebhook("http://localhost/hook", "secret", []string{repository.GROUP_CREATED}, nil, nil)
+	repository.CreateNewGroup("group1")
+	repository.RecordWebhookDeliveries(10, func(e repository.StoredEvent) []repository.WebhookDelivery {
+		return []repository.WebhookDelivery{{WebhookID: hook.ID, Kind: e.Kind, Key: e.Key, Body: "{}"}}
+	})
+	now := time.Now().UTC()
+
+	// Execute
+	first := repository.ClaimWebhookDeliveries(now, time.Minute, 10)
+	second := repository.ClaimWebhookDeliveries(now, time.Minute, 10)
+	expired := repository.ClaimWebhookDeliveries(now.Add(2*time.Minute), time.Minute, 10)
+
+	// Test
+	if len(first) != 1 || first[0].Status != repository.DELIVERY_IN_FLIGHT || len(second) != 0 {
+		t.Error(errorMsg("Claims", "1 then none", fmt.Sprintf("%d then %d", len(first), len(second))))
+		return
+	}
+
+	if len(expired) != 1 || expired[0].ID != first[0].ID {
+		t.Error(errorMsg("Claims after the lease", "1", fmt.Sprintf("%d", len(expired))))
+		return
+	}
+}
+
+func TestWebhookDeliveriesRecordedOnce(t *testing.T) {
+	// Init
+	repository.ConnectDB("sqlite3", "/tmp/test.db")
+	defer repository.CloseDB()
+	defer os.Remove("/tmp/test.db")
+
+	// Prepare
+	hook, _ := repository.CreateWebhook("http://localhost/hook", "secret", []string{repository.GROUP_CREATED}, nil, nil)
+	repository.CreateNewGroup("group1")
+	repository.CreateNewGroup("group2")
+	delivery := func(e repository.StoredEvent) []repository.WebhookDelivery {
+		return []repository.WebhookDelivery{{WebhookID: hook.ID, Kind: e.Kind, Key: e.Key, Body: "{}"}}
+	}
+	building := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	slow := make(chan int)
+	go func() {
+		recorded, _ := repository.RecordWebhookDeliveries(10, func(e repository.StoredEvent) []repository.WebhookDelivery {
+			once.Do(func() {
+				close(building)
+				<-release
+			})
+			return delivery(e)
+		})
+		slow <- recorded
+	}()
+	<-building
+
+	// Execute
+	created := make(chan struct{})
+	go func() {
+		repository.CreateNewGroup("group3")
+		close(created)
+	}()
+	select {
+	case <-created:
+	case <-time.After(2 * time.Second):
+		t.Error(errorMsg("Write while deliveries are built", "Done", "Blocked"))
+		close(release)
+		return
+	}
+	recorded, err := repository.RecordWebhookDeliveries(10, delivery)
+	close(release)
+	slowRecorded := <-slow
+
+	// Test
+	if err != nil || recorded != 3 || slowRecorded != 0 {
+		t.Error(errorMsg("Recorded events", "3, then 0", fmt.Sprintf("%d, then %d (%v)", recorded, slowRecorded, err)))
+		return
+	}
+
+	if deliveries := repository.GetWebhookDeliveries(hook.ID, "", 10); len(deliveries) != 3 {
+		t.Error(errorMsg("Deliveries", "3", fmt.Sprintf("%d", len(deliveries))))
+		return
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	// Prepare
+	defaults := config.C.Webhook
+	defer func() { config.C.Webhook = defaults }()
+	config.C.Webhook.RetryBase = 10
+	config.C.Webhook.RetryMax = 60
+
+	// Test
+	for attempt, want := range map[int]time.Duration{1: 10 * time.Second, 2: 20 * time.Second, 3: 40 * time.Second, 4: time.Minute, 10: time.Minute} {
+		if got := webhook.Backoff(attempt); got != want {
+			t.Error(errorMsg(fmt.Sprintf("Backoff(%d)", attempt), want.String(), got.String()))
+			return
+		}
+	}
+}
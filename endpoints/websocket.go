@@ -0,0 +1,88 @@
+package endpoints
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"github.com/gorilla/websocket"
+)
+
+// WS_BEARER is the subprotocol browsers authenticate with, as they can't
+// set headers on WebSocket connections. The token is sent as the next
+// subprotocol, i.e. `new WebSocket(url, ["bearer", token])`.
+const WS_BEARER = "bearer"
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin:  checkOrigin,
+	Subprotocols: []string{WS_BEARER},
+}
+
+// checkOrigin accepts connections without Origin, which aren't made by
+// browsers, those from the origin of the API and those from the
+// configured origins.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range config.C.WebSocket.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsToken returns the token sent with a WebSocket handshake, in the
+// Authorization header, the token query parameter or the bearer
+// subprotocol.
+func wsToken(req *http.Request) string {
+	if token, ok := bearerToken(req); ok {
+		return token
+	}
+	if token := req.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	protocols := websocket.Subprotocols(req)
+	for i, protocol := range protocols {
+		if protocol == WS_BEARER && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// wsUser authenticates a WebSocket handshake, returning the user and the
+// session the token was issued in. It returns nil for clients that didn't
+// send a token; they may still authenticate with their first message. A
+// wrong token is answered with 401, a role that doesn't permit reading
+// with 403, and ok is false.
+func wsUser(w http.ResponseWriter, req *http.Request) (user *repository.User, sessionID uint, ok bool) {
+	token := wsToken(req)
+	if token == "" {
+		return nil, 0, true
+	}
+	u, session, err := sessionByToken(token)
+	if err != nil {
+		sendErrorMessage(w, err.Error(), http.StatusUnauthorized)
+		return nil, 0, false
+	}
+	if !repository.RolePermits(u.Role, repository.PERMISSION_READ) {
+		sendForbidden(w, u.Role, repository.PERMISSION_READ)
+		return nil, 0, false
+	}
+	return &u, session.ID, true
+}
+
+// rejectWS closes the connection with a policy violation.
+func rejectWS(c *websocket.Conn, reason string, timeout time.Duration) {
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	c.WriteControl(websocket.CloseMessage, message, time.Now().Add(timeout))
+}
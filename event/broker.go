@@ -0,0 +1,168 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Broker carries the events published on the bus of an instance to the
+// buses of the other instances.
+type Broker interface {
+	// Publish sends the event to the other instances.
+	Publish(e *Event) error
+
+	// Subscribe has receive called with the events published by every
+	// instance, possibly including those of the subscriber.
+	Subscribe(receive func(e *Event)) error
+
+	Close() error
+}
+
+// LocalBroker carries the events between the buses of a process.
+type LocalBroker struct {
+	lock     sync.RWMutex
+	receives []func(e *Event)
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{}
+}
+
+func (b *LocalBroker) Publish(e *Event) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	for _, receive := range b.receives {
+		copy := *e
+		receive(&copy)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(receive func(e *Event)) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.receives = append(b.receives, receive)
+	return nil
+}
+
+func (b *LocalBroker) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.receives = nil
+	return nil
+}
+
+// Decoder decodes the JSON payload of an event received from another
+// instance.
+type Decoder func(data []byte) (interface{}, error)
+
+var (
+	decodersLock sync.RWMutex
+	decoders     = map[string]Decoder{}
+)
+
+// RegisterPayload has the payloads of kind decoded with decode, so that
+// the events received from other instances carry the same types as those
+// published locally. Payloads of kinds not registered are left as
+// json.RawMessage.
+func RegisterPayload(kind string, decode Decoder) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	decoders[kind] = decode
+}
+
+// LogLoader loads the JSON payload of the event of the event log with the
+// id logID.
+type LogLoader func(logID uint) ([]byte, error)
+
+var logLoader LogLoader
+
+// RegisterLog has the payloads of the events received without them, see
+// MarshalReference, loaded from the event log with load.
+func RegisterLog(load LogLoader) {
+	decodersLock.Lock()
+	defer decodersLock.Unlock()
+	logLoader = load
+}
+
+// DecodeAs returns a Decoder of payloads of the type of prototype.
+func DecodeAs(prototype interface{}) Decoder {
+	t := reflect.TypeOf(prototype)
+	return func(data []byte) (interface{}, error) {
+		v := reflect.New(t)
+		if err := json.Unmarshal(data, v.Interface()); err != nil {
+			return nil, err
+		}
+		return v.Elem().Interface(), nil
+	}
+}
+
+// envelope is the encoding of an event sent to other instances.
+type envelope struct {
+	Origin  string          `json:"origin"`
+	Kind    string          `json:"kind"`
+	Key     string          `json:"key,omitempty"`
+	Time    time.Time       `json:"time"`
+	LogID   uint            `json:"log_id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Marshal encodes an event for brokers.
+func Marshal(e *Event) ([]byte, error) {
+	payload, err := json.Marshal(e.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Origin: e.Origin, Kind: e.Kind, Key: e.Key, Time: e.Time, LogID: e.LogID, Payload: payload})
+}
+
+// MarshalReference encodes a logged event for brokers without its
+// payload, which receivers load from the event log.
+func MarshalReference(e *Event) ([]byte, error) {
+	if e.LogID == 0 {
+		return nil, fmt.Errorf("event %s isn't logged", e.Kind)
+	}
+	return json.Marshal(envelope{Origin: e.Origin, Kind: e.Kind, Key: e.Key, Time: e.Time, LogID: e.LogID})
+}
+
+// Unmarshal decodes an event encoded by Marshal or MarshalReference, its
+// payload with the Decoder registered for its kind.
+func Unmarshal(data []byte) (*Event, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+	if len(env.Payload) == 0 && env.LogID != 0 {
+		decodersLock.RLock()
+		load := logLoader
+		decodersLock.RUnlock()
+		if load == nil {
+			return nil, fmt.Errorf("no event log to load event %d from", env.LogID)
+		}
+		var err error
+		if env.Payload, err = load(env.LogID); err != nil {
+			return nil, err
+		}
+	}
+	payload, err := Decode(env.Kind, env.Payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{Origin: env.Origin, Kind: env.Kind, Key: env.Key, Time: env.Time, Payload: payload, LogID: env.LogID}, nil
+}
+
+// Decode decodes the JSON payload of an event of kind with the Decoder
+// registered for the kind, if any, and returns it as json.RawMessage
+// otherwise.
+func Decode(kind string, data []byte) (interface{}, error) {
+	decodersLock.RLock()
+	decode, ok := decoders[kind]
+	decodersLock.RUnlock()
+	if !ok {
+		return json.RawMessage(data), nil
+	}
+	return decode(data)
+}
@@ -1,80 +1,406 @@
+// Package event is an in-process publish/subscribe bus.
+//
+// Events are published with a kind, e.g. "NEW-AGENT", and an ordering key,
+// e.g. the UUID of the agent. Every subscription has its own bounded
+// buffer and workers, so a slow subscriber only holds up itself; when its
+// buffer is full, the events it can't take are dropped and counted.
+// Events with the same key are handled by a subscription in the order
+// they were published.
+//
+// With a Broker, the events published on the bus are also carried to the
+// buses of the other instances of the API, and theirs to it.
 package event
 
-var eventKinds = map[string]Kind{}
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
-var stream = make(chan *Event)
-var stop = make(chan bool)
-
-type Kind struct {
-	Name string
-	handlers *[]*func(e *Event)
-	stream *chan *Event
-}
+const (
+	DEFAULT_BUFFER  = 1024
+	DEFAULT_WORKERS = 1
+)
 
+// Event is a published event. Origin identifies the bus it was published
+// on, and Remote is set when that's the bus of another instance. LogID is
+// the id of the event in the event log, 0 when it isn't logged.
 type Event struct {
-	kind *Kind
+	Kind    string
+	Key     string
+	Time    time.Time
 	Payload interface{}
+	Origin  string
+	Remote  bool
+	LogID   uint
+}
+
+// Handler handles the events of a subscription.
+type Handler func(e *Event)
+
+// Options configure a subscription. Each of the Workers handles the
+// events of a share of the keys, and has a buffer of Buffer events.
+type Options struct {
+	Name    string
+	Buffer  int
+	Workers int
+}
+
+// Subscription receives the events of some kinds until it's cancelled.
+type Subscription struct {
+	bus     *Bus
+	name    string
+	kinds   []string
+	handler Handler
+	queues  []chan *Event
+	workers sync.WaitGroup
+
+	delivered uint64
+	dropped   uint64
+	once      sync.Once
+}
+
+// Bus dispatches the published events to the subscriptions. Events are
+// only accepted while the bus is running.
+type Bus struct {
+	lock          sync.RWMutex
+	running       bool
+	subscriptions map[string]map[*Subscription]bool
+	origin        string
+
+	// forward queues the events for the broker, if any.
+	forward chan *Event
+
+	// inflight counts the events queued and not handled or forwarded
+	// yet.
+	inflight sync.WaitGroup
+
+	statsLock sync.Mutex
+	published map[string]uint64
+	received  map[string]uint64
+	dropped   map[string]uint64
+}
+
+func NewBus() *Bus {
+	origin := make([]byte, 8)
+	rand.Read(origin)
+	return &Bus{
+		subscriptions: map[string]map[*Subscription]bool{},
+		origin:        hex.EncodeToString(origin),
+		published:     map[string]uint64{},
+		received:      map[string]uint64{},
+		dropped:       map[string]uint64{},
+	}
 }
 
-func (k *Kind) Emit(payload interface{}) {
-	go func(){
-		event := Event{
-			kind: k,
-			Payload: payload,
+// SetBroker has the events published on the bus carried to the buses of
+// the other instances by broker, and the events published on those
+// dispatched on the bus.
+func (b *Bus) SetBroker(broker Broker) error {
+	if err := broker.Subscribe(b.receive); err != nil {
+		return err
+	}
+	forward := make(chan *Event, DEFAULT_BUFFER)
+	go func() {
+		for e := range forward {
+			if err := broker.Publish(e); err != nil {
+				log.Println("[Event] Can't forward the event to the broker.", "Event", e.Kind, err)
+				b.count(e.Kind, 0, 0, 1)
+			}
+			b.inflight.Done()
 		}
-		*k.stream <- &event
 	}()
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.forward = forward
+	return nil
 }
 
-func (k *Kind) Register(handler *func(event *Event)){
-	*k.handlers = append(*k.handlers, handler)
+// Run starts accepting events.
+func (b *Bus) Run() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.running = true
 }
 
-func (k *Kind) UnRegister(handler *func(event *Event)){
-	found := -1
-	for i, v := range *k.handlers {
-		if v == handler {
-			found = i
-			break
+// Shutdown stops accepting events and waits until the queued ones are
+// handled and forwarded, or ctx is done.
+func (b *Bus) Shutdown(ctx context.Context) error {
+	b.lock.Lock()
+	b.running = false
+	b.lock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		b.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe calls handler with the events of kinds from now on, until the
+// subscription is cancelled.
+func (b *Bus) Subscribe(kinds []string, options Options, handler Handler) *Subscription {
+	if options.Buffer < 1 {
+		options.Buffer = DEFAULT_BUFFER
+	}
+	if options.Workers < 1 {
+		options.Workers = DEFAULT_WORKERS
+	}
+	s := &Subscription{
+		bus:     b,
+		name:    options.Name,
+		kinds:   kinds,
+		handler: handler,
+		queues:  make([]chan *Event, options.Workers),
+	}
+	for i := range s.queues {
+		s.queues[i] = make(chan *Event, options.Buffer)
+		s.workers.Add(1)
+		go s.work(s.queues[i])
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, kind := range kinds {
+		if b.subscriptions[kind] == nil {
+			b.subscriptions[kind] = map[*Subscription]bool{}
 		}
+		b.subscriptions[kind][s] = true
 	}
-	(*k.handlers)[found] = (*k.handlers)[len(*k.handlers)-1]
-	*k.handlers = (*k.handlers)[:len(*k.handlers)-1]
+	return s
+}
+
+// Publish queues the event for the subscriptions to its kind, and for
+// the broker. It never blocks: the event is dropped for the subscriptions
+// whose buffer is full, and for all of them when the bus isn't running.
+func (b *Bus) Publish(kind string, key string, payload interface{}) {
+	b.PublishLogged(kind, key, 0, payload)
 }
 
-func MakeKind(name string) *Kind {
-	if _, ok := eventKinds[name]; !ok {
-		eventKinds[name] = Kind{
-			Name: name,
-			stream: &stream,
-			handlers: &[]*func(e *Event){},
+// PublishLogged publishes an event stored in the event log with the id
+// logID, like Publish.
+func (b *Bus) PublishLogged(kind string, key string, logID uint, payload interface{}) {
+	e := &Event{Kind: kind, Key: key, Time: time.Now().UTC(), Payload: payload, Origin: b.origin, LogID: logID}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if !b.running {
+		b.count(kind, 0, 0, 1)
+		return
+	}
+	b.count(kind, 1, 0, b.dispatch(e))
+	if b.forward != nil {
+		b.inflight.Add(1)
+		select {
+		case b.forward <- e:
+		default:
+			b.inflight.Done()
+			b.count(kind, 0, 0, 1)
 		}
 	}
-	kind := eventKinds[name]
-	return &kind
 }
 
-func Run() {
-	go func(){
+// receive dispatches an event received from the broker, unless it was
+// published on the bus.
+func (b *Bus) receive(e *Event) {
+	if e.Origin == b.origin {
+		return
+	}
+	e.Remote = true
 
-		for {
-			var e *Event
-			select {
-			case e = <-stream:
-				// Handle
-				for _, handler := range *e.kind.handlers {
-					(*handler)(e)
-				}
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	if !b.running {
+		b.count(e.Kind, 0, 0, 1)
+		return
+	}
+	b.count(e.Kind, 0, 1, b.dispatch(e))
+}
 
-			case <- stop:
-				// Quit
-				return
+// dispatch queues the event for the subscriptions to its kind, and
+// returns the number of those it was dropped for.
+func (b *Bus) dispatch(e *Event) uint64 {
+	dropped := uint64(0)
+	for s := range b.subscriptions[e.Kind] {
+		b.inflight.Add(1)
+		select {
+		case s.queue(e.Key) <- e:
+		default:
+			b.inflight.Done()
+			atomic.AddUint64(&s.dropped, 1)
+			dropped++
+		}
+	}
+	return dropped
+}
 
-			}
+func (b *Bus) count(kind string, published uint64, received uint64, dropped uint64) {
+	b.statsLock.Lock()
+	defer b.statsLock.Unlock()
+	b.published[kind] += published
+	b.received[kind] += received
+	b.dropped[kind] += dropped
+}
+
+// KindStats counts the events of a kind. Received counts the events
+// published by other instances. Dropped counts each subscription an event
+// was dropped for, and the events that couldn't be forwarded.
+type KindStats struct {
+	Kind      string `json:"kind"`
+	Published uint64 `json:"published"`
+	Received  uint64 `json:"received"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// SubscriptionStats describes a subscription and its buffers.
+type SubscriptionStats struct {
+	Name      string   `json:"name"`
+	Kinds     []string `json:"kinds"`
+	Queued    int      `json:"queued"`
+	Delivered uint64   `json:"delivered"`
+	Dropped   uint64   `json:"dropped"`
+}
+
+// Stats counts the events of the bus.
+type Stats struct {
+	Running       bool                `json:"running"`
+	Kinds         []KindStats         `json:"kinds"`
+	Subscriptions []SubscriptionStats `json:"subscriptions"`
+}
+
+func (b *Bus) Stats() Stats {
+	stats := Stats{Kinds: make([]KindStats, 0), Subscriptions: make([]SubscriptionStats, 0)}
+
+	b.statsLock.Lock()
+	for kind, dropped := range b.dropped {
+		stats.Kinds = append(stats.Kinds, KindStats{Kind: kind, Published: b.published[kind], Received: b.received[kind], Dropped: dropped})
+	}
+	b.statsLock.Unlock()
+	sort.Slice(stats.Kinds, func(i, j int) bool { return stats.Kinds[i].Kind < stats.Kinds[j].Kind })
+
+	b.lock.RLock()
+	stats.Running = b.running
+	subscriptions := map[*Subscription]bool{}
+	for _, kindSubscriptions := range b.subscriptions {
+		for s := range kindSubscriptions {
+			subscriptions[s] = true
 		}
-	}()
+	}
+	b.lock.RUnlock()
+	for s := range subscriptions {
+		stats.Subscriptions = append(stats.Subscriptions, s.Stats())
+	}
+	sort.Slice(stats.Subscriptions, func(i, j int) bool { return stats.Subscriptions[i].Name < stats.Subscriptions[j].Name })
+	return stats
+}
+
+// queue returns the queue of the worker handling the events of key.
+func (s *Subscription) queue(key string) chan *Event {
+	if len(s.queues) == 1 {
+		return s.queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.queues[h.Sum32()%uint32(len(s.queues))]
+}
+
+func (s *Subscription) work(queue chan *Event) {
+	defer s.workers.Done()
+	for e := range queue {
+		s.handler(e)
+		atomic.AddUint64(&s.delivered, 1)
+		s.bus.inflight.Done()
+	}
+}
+
+// Cancel stops the subscription. The events queued already are still
+// handled; Cancel doesn't wait for them, so it can be called by the
+// handler. Cancelling twice is harmless.
+func (s *Subscription) Cancel() {
+	s.once.Do(func() {
+		s.bus.lock.Lock()
+		defer s.bus.lock.Unlock()
+		for _, kind := range s.kinds {
+			delete(s.bus.subscriptions[kind], s)
+		}
+		for _, queue := range s.queues {
+			close(queue)
+		}
+	})
+}
+
+func (s *Subscription) Stats() SubscriptionStats {
+	queued := 0
+	for _, queue := range s.queues {
+		queued += len(queue)
+	}
+	return SubscriptionStats{
+		Name:      s.name,
+		Kinds:     s.kinds,
+		Queued:    queued,
+		Delivered: atomic.LoadUint64(&s.delivered),
+		Dropped:   atomic.LoadUint64(&s.dropped),
+	}
+}
+
+// Kind publishes the events of a kind on a bus.
+type Kind struct {
+	Name string
+	bus  *Bus
+}
+
+// Emit publishes an event without ordering key.
+func (k Kind) Emit(payload interface{}) {
+	k.bus.Publish(k.Name, "", payload)
+}
+
+// EmitKeyed publishes an event ordered with the other events of key.
+func (k Kind) EmitKeyed(key string, payload interface{}) {
+	k.bus.Publish(k.Name, key, payload)
+}
+
+// EmitLogged publishes an event stored in the event log with the id
+// logID, ordered with the other events of key.
+func (k Kind) EmitLogged(key string, logID uint, payload interface{}) {
+	k.bus.PublishLogged(k.Name, key, logID, payload)
+}
+
+// Default is the bus of the application.
+var Default = NewBus()
+
+func MakeKind(name string) Kind {
+	return Kind{Name: name, bus: Default}
+}
+
+func Subscribe(kinds []string, options Options, handler Handler) *Subscription {
+	return Default.Subscribe(kinds, options, handler)
+}
+
+func Run() {
+	Default.Run()
+}
+
+func Shutdown(ctx context.Context) error {
+	return Default.Shutdown(ctx)
+}
+
+func GetStats() Stats {
+	return Default.Stats()
 }
 
-func Shutdown() {
-	stop <- true
+func SetBroker(broker Broker) error {
+	return Default.SetBroker(broker)
 }
@@ -0,0 +1,41 @@
+// Package geo contains the small amount of geometry the tracker needs:
+// distances on the earth's surface and point-in-polygon tests.
+package geo
+
+import "math"
+
+// EarthRadius is the mean radius of the earth in meters.
+const EarthRadius = 6371008.8
+
+// Point is a WGS84 coordinate.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+func radians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// Distance returns the great-circle distance between a and b in meters.
+func Distance(a, b Point) float64 {
+	dLat := radians(b.Lat - a.Lat)
+	dLon := radians(b.Lon - a.Lon)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(radians(a.Lat))*math.Cos(radians(b.Lat))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Contains reports whether p lies inside polygon. The polygon may be
+// given open or closed; points exactly on an edge may go either way.
+func Contains(polygon []Point, p Point) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,70 @@
+package geo
+
+import "math"
+
+// TileSize is the size of a web map tile in pixels.
+const TileSize = 256
+
+// MaxLat is the latitude bound of the web mercator projection.
+const MaxLat = 85.05112878
+
+// Project returns the position of p in pixels on a web mercator map at
+// the given zoom level.
+func Project(p Point, zoom float64) (x, y float64) {
+	scale := TileSize * math.Pow(2, zoom)
+	lat := math.Max(-MaxLat, math.Min(MaxLat, p.Lat))
+	sin := math.Sin(radians(lat))
+	x = (p.Lon + 180) / 360 * scale
+	y = (0.5 - math.Log((1+sin)/(1-sin))/(4*math.Pi)) * scale
+	return
+}
+
+// Unproject is the inverse of Project.
+func Unproject(x, y, zoom float64) Point {
+	scale := TileSize * math.Pow(2, zoom)
+	n := math.Pi - 2*math.Pi*y/scale
+	return Point{
+		Lat: 180 / math.Pi * math.Atan(math.Sinh(n)),
+		Lon: x/scale*360 - 180,
+	}
+}
+
+// BBox is a bounding box in degrees.
+type BBox struct {
+	MinLon float64 `json:"min_lon"`
+	MinLat float64 `json:"min_lat"`
+	MaxLon float64 `json:"max_lon"`
+	MaxLat float64 `json:"max_lat"`
+}
+
+// Contains reports whether p is inside the bounding box.
+func (b BBox) Contains(p Point) bool {
+	return p.Lon >= b.MinLon && p.Lon <= b.MaxLon && p.Lat >= b.MinLat && p.Lat <= b.MaxLat
+}
+
+// TileBBox returns the bounding box of the web map tile x, y at zoom.
+func TileBBox(zoom, x, y int) BBox {
+	topLeft := Unproject(float64(x*TileSize), float64(y*TileSize), float64(zoom))
+	bottomRight := Unproject(float64((x+1)*TileSize), float64((y+1)*TileSize), float64(zoom))
+	return BBox{MinLon: topLeft.Lon, MinLat: bottomRight.Lat, MaxLon: bottomRight.Lon, MaxLat: topLeft.Lat}
+}
+
+// Intersects reports whether the two bounding boxes overlap.
+func (b BBox) Intersects(o BBox) bool {
+	return b.MinLon <= o.MaxLon && o.MinLon <= b.MaxLon && b.MinLat <= o.MaxLat && o.MinLat <= b.MaxLat
+}
+
+// Bounds returns the bounding box of points.
+func Bounds(points []Point) BBox {
+	if len(points) == 0 {
+		return BBox{}
+	}
+	b := BBox{MinLon: points[0].Lon, MinLat: points[0].Lat, MaxLon: points[0].Lon, MaxLat: points[0].Lat}
+	for _, p := range points[1:] {
+		b.MinLon = math.Min(b.MinLon, p.Lon)
+		b.MinLat = math.Min(b.MinLat, p.Lat)
+		b.MaxLon = math.Max(b.MaxLon, p.Lon)
+		b.MaxLat = math.Max(b.MaxLat, p.Lat)
+	}
+	return b
+}
@@ -0,0 +1,33 @@
+package geo
+
+import (
+	"bytes"
+	"math"
+)
+
+// EncodePolyline encodes points in the Google encoded polyline format
+// with a precision of 5 decimal places.
+func EncodePolyline(points []Point) string {
+	var buf bytes.Buffer
+	var prevLat, prevLon int64
+	for _, p := range points {
+		lat := int64(math.Floor(p.Lat*1e5 + 0.5))
+		lon := int64(math.Floor(p.Lon*1e5 + 0.5))
+		encodeValue(&buf, lat-prevLat)
+		encodeValue(&buf, lon-prevLon)
+		prevLat, prevLon = lat, lon
+	}
+	return buf.String()
+}
+
+func encodeValue(buf *bytes.Buffer, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		buf.WriteByte(byte(0x20|(u&0x1f)) + 63)
+		u >>= 5
+	}
+	buf.WriteByte(byte(u) + 63)
+}
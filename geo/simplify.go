@@ -0,0 +1,90 @@
+package geo
+
+import "math"
+
+// project maps p to meters on a plane tangent to the earth at origin.
+// Good enough for the distances within a single track.
+func project(origin, p Point) (x, y float64) {
+	x = radians(p.Lon-origin.Lon) * math.Cos(radians(origin.Lat)) * EarthRadius
+	y = radians(p.Lat-origin.Lat) * EarthRadius
+	return
+}
+
+// segmentDistance returns the distance of p from the segment a-b in meters.
+func segmentDistance(p, a, b Point) float64 {
+	px, py := project(a, p)
+	bx, by := project(a, b)
+	l := bx*bx + by*by
+	if l == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/l))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// Simplify reduces a polyline with the Douglas-Peucker algorithm and
+// returns the indices of the points to keep, in order. Segments are
+// split, worst first, until every dropped point is within tolerance
+// meters of the result or maxPoints points are kept. A zero tolerance or
+// maxPoints disables that bound; the end points are always kept.
+func Simplify(points []Point, tolerance float64, maxPoints int) []int {
+	n := len(points)
+	if n <= 2 {
+		return sequence(n)
+	}
+
+	type segment struct {
+		first, last int
+		worst       int
+		distance    float64
+	}
+	split := func(first, last int) segment {
+		s := segment{first: first, last: last, worst: -1}
+		for i := first + 1; i < last; i++ {
+			if d := segmentDistance(points[i], points[first], points[last]); d > s.distance || s.worst == -1 {
+				s.worst, s.distance = i, d
+			}
+		}
+		return s
+	}
+
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	kept := 2
+	segments := []segment{split(0, n-1)}
+	for len(segments) > 0 {
+		if maxPoints > 0 && kept >= maxPoints {
+			break
+		}
+		worst := 0
+		for i := range segments {
+			if segments[i].distance > segments[worst].distance {
+				worst = i
+			}
+		}
+		s := segments[worst]
+		segments = append(segments[:worst], segments[worst+1:]...)
+		if s.worst == -1 || (tolerance > 0 && s.distance <= tolerance) {
+			continue
+		}
+		keep[s.worst] = true
+		kept++
+		segments = append(segments, split(s.first, s.worst), split(s.worst, s.last))
+	}
+
+	indices := make([]int, 0, kept)
+	for i, k := range keep {
+		if k {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+func sequence(n int) []int {
+	s := make([]int, n)
+	for i := range s {
+		s[i] = i
+	}
+	return s
+}
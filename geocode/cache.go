@@ -0,0 +1,73 @@
+package geocode
+
+import (
+	"container/list"
+	"math"
+	"sync"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+)
+
+// cachePrecision rounds coordinates to ~11m before caching.
+const cachePrecision = 1e4
+
+type cacheKey struct {
+	lat, lon int64
+}
+
+type cacheEntry struct {
+	key   cacheKey
+	place Place
+	err   error
+}
+
+// Cache is a ReverseGeocoder remembering the last Size lookups of
+// another one. Coordinates are rounded so nearby fixes share entries.
+type Cache struct {
+	Geocoder ReverseGeocoder
+	Size     int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[cacheKey]*list.Element
+}
+
+// NewCache wraps geocoder with a cache of size entries.
+func NewCache(geocoder ReverseGeocoder, size int) *Cache {
+	return &Cache{
+		Geocoder: geocoder,
+		Size:     size,
+		order:    list.New(),
+		entries:  map[cacheKey]*list.Element{},
+	}
+}
+
+func (c *Cache) Reverse(p geo.Point) (Place, error) {
+	key := cacheKey{
+		lat: int64(math.Floor(p.Lat*cachePrecision + 0.5)),
+		lon: int64(math.Floor(p.Lon*cachePrecision + 0.5)),
+	}
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		entry := e.Value.(*cacheEntry)
+		c.mu.Unlock()
+		return entry.place, entry.err
+	}
+	c.mu.Unlock()
+
+	place, err := c.Geocoder.Reverse(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, place: place, err: err})
+		for c.order.Len() > c.Size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).key)
+		}
+	}
+	return place, err
+}
@@ -0,0 +1,113 @@
+package geocode
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+)
+
+// cellSize of the gazetteer index in degrees.
+const cellSize = 0.05
+
+type cell struct {
+	lat, lon int
+}
+
+// Gazetteer is an offline ReverseGeocoder backed by a list of named
+// points, e.g. street intersections or POIs extracted from OSM.
+type Gazetteer struct {
+	// MaxDistance in meters, places farther away aren't returned.
+	MaxDistance float64
+
+	cells map[cell][]Place
+}
+
+// NewGazetteer returns an empty gazetteer.
+func NewGazetteer(maxDistance float64) *Gazetteer {
+	return &Gazetteer{
+		MaxDistance: maxDistance,
+		cells:       map[cell][]Place{},
+	}
+}
+
+// LoadGazetteer reads a CSV file of `name,lat,lon` records. Records that
+// don't parse, such as a header line, are skipped.
+func LoadGazetteer(path string, maxDistance float64) (*Gazetteer, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	g := NewGazetteer(maxDistance)
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			continue
+		}
+		lat, err := strconv.ParseFloat(record[1], 64)
+		if err != nil {
+			continue
+		}
+		lon, err := strconv.ParseFloat(record[2], 64)
+		if err != nil {
+			continue
+		}
+		g.Add(record[0], geo.Point{Lat: lat, Lon: lon})
+	}
+	return g, nil
+}
+
+func cellOf(p geo.Point) cell {
+	return cell{lat: int(math.Floor(p.Lat / cellSize)), lon: int(math.Floor(p.Lon / cellSize))}
+}
+
+// Add adds a named place to the gazetteer.
+func (g *Gazetteer) Add(name string, p geo.Point) {
+	c := cellOf(p)
+	g.cells[c] = append(g.cells[c], Place{Name: name, Point: p})
+}
+
+// Reverse returns the nearest place within MaxDistance of p.
+func (g *Gazetteer) Reverse(p geo.Point) (Place, error) {
+	center := cellOf(p)
+	// Cells to search in each direction to cover MaxDistance.
+	latCells := int(math.Ceil(g.MaxDistance/(cellSize*111320))) + 1
+	lonCells := int(math.Ceil(g.MaxDistance/(cellSize*111320*math.Max(0.01, math.Cos(p.Lat*math.Pi/180))))) + 1
+
+	var nearest Place
+	found := false
+	for dLat := -latCells; dLat <= latCells; dLat++ {
+		for dLon := -lonCells; dLon <= lonCells; dLon++ {
+			for _, place := range g.cells[cell{lat: center.lat + dLat, lon: center.lon + dLon}] {
+				d := geo.Distance(p, place.Point)
+				if d > g.MaxDistance {
+					continue
+				}
+				if !found || d < nearest.Distance {
+					nearest = place
+					nearest.Distance = d
+					found = true
+				}
+			}
+		}
+	}
+	if !found {
+		return nearest, &GeocodeError{What: "Place", Type: "Not-Found", Arg: fmt.Sprintf("%.5f,%.5f", p.Lat, p.Lon)}
+	}
+	return nearest, nil
+}
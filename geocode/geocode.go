@@ -0,0 +1,54 @@
+// Package geocode turns coordinates into human readable place names.
+package geocode
+
+import (
+	"fmt"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+)
+
+// Place is a named location found near a coordinate.
+type Place struct {
+	Name string `json:"name"`
+	geo.Point
+
+	// Distance from the looked up coordinate in meters.
+	Distance float64 `json:"distance"`
+}
+
+// Description returns a short text for dispatchers, e.g. "near Main St & 5th".
+func (p Place) Description() string {
+	return fmt.Sprintf("near %s", p.Name)
+}
+
+// ReverseGeocoder finds the place closest to a coordinate.
+type ReverseGeocoder interface {
+	Reverse(p geo.Point) (Place, error)
+}
+
+// Default is the geocoder used to annotate positions, nil disables
+// annotation.
+var Default ReverseGeocoder
+
+// Describe returns the description of the place nearest to p using the
+// Default geocoder, or an empty string if there is none.
+func Describe(p geo.Point) string {
+	if Default == nil {
+		return ""
+	}
+	place, err := Default.Reverse(p)
+	if err != nil {
+		return ""
+	}
+	return place.Description()
+}
+
+type GeocodeError struct {
+	What string
+	Type string
+	Arg  string
+}
+
+func (e GeocodeError) Error() string {
+	return fmt.Sprintf("%s: <%s> %s", e.Type, e.What, e.Arg)
+}
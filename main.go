@@ -43,7 +43,7 @@ func main() {
 		{
 			Name:    "createsuperuser",
 			Aliases: []string{"c"},
-			Usage:   "Create a new user.",
+			Usage:   "Create a new admin user.",
 			Action: func(c *cli.Context) {
 				println("action:", "createsuperuser")
 				configPath := c.String("config-path")
@@ -55,7 +55,7 @@ func main() {
 				email := c.String("email")
 				password := c.String("password")
 				repository.ConnectDB(config.C.DB.Type, config.C.DB.URL)
-				user, err := repository.CreateNewUser(email, password)
+				user, err := repository.CreateNewUser(email, password, repository.ROLE_ADMIN)
 				if err != nil {
 					log.Fatal("Can not create user:", err.Error())
 					return
@@ -0,0 +1,114 @@
+package mvt
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type buffer []byte
+
+func (b *buffer) varint(v uint64) {
+	for v >= 0x80 {
+		*b = append(*b, byte(v)|0x80)
+		v >>= 7
+	}
+	*b = append(*b, byte(v))
+}
+
+func (b *buffer) key(field, wire uint64) {
+	b.varint(field<<3 | wire)
+}
+
+func (b *buffer) bytes(field uint64, data []byte) {
+	b.key(field, wireBytes)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *buffer) packed(field uint64, values []uint32) {
+	if len(values) == 0 {
+		return
+	}
+	var packed buffer
+	for _, v := range values {
+		packed.varint(uint64(v))
+	}
+	b.bytes(field, packed)
+}
+
+// Marshal encodes the tile in the protobuf wire format.
+func (t *Tile) Marshal() []byte {
+	var b buffer
+	for _, layer := range t.Layers {
+		b.bytes(3, layer.marshal())
+	}
+	return b
+}
+
+func (l *Layer) marshal() []byte {
+	var b buffer
+	b.key(15, wireVarint)
+	b.varint(2)
+	b.bytes(1, []byte(l.Name))
+	for _, f := range l.features {
+		b.bytes(2, f.marshal())
+	}
+	for _, key := range l.keys {
+		b.bytes(3, []byte(key))
+	}
+	for _, value := range l.values {
+		b.bytes(4, marshalValue(value))
+	}
+	b.key(5, wireVarint)
+	b.varint(uint64(l.Extent))
+	return b
+}
+
+func (f *feature) marshal() []byte {
+	var b buffer
+	if f.id != 0 {
+		b.key(1, wireVarint)
+		b.varint(f.id)
+	}
+	b.packed(2, f.tags)
+	b.key(3, wireVarint)
+	b.varint(f.geomType)
+	b.packed(4, f.geometry)
+	return b
+}
+
+func marshalValue(v interface{}) []byte {
+	var b buffer
+	switch v := v.(type) {
+	case string:
+		b.bytes(1, []byte(v))
+	case float64:
+		b.key(3, wireFixed64)
+		var raw [8]byte
+		binary.LittleEndian.PutUint64(raw[:], math.Float64bits(v))
+		b = append(b, raw[:]...)
+	case int64:
+		// sint_value, zigzag encoded.
+		b.key(6, wireVarint)
+		b.varint(uint64((v << 1) ^ (v >> 63)))
+	case uint64:
+		b.key(5, wireVarint)
+		b.varint(v)
+	case bool:
+		b.key(7, wireVarint)
+		if v {
+			b.varint(1)
+		} else {
+			b.varint(0)
+		}
+	}
+	return b
+}
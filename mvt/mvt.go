@@ -0,0 +1,173 @@
+// Package mvt encodes Mapbox Vector Tiles (version 2.1 of the spec).
+//
+// Only what the tracker serves is supported: point and polygon features
+// with scalar properties.
+package mvt
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultExtent of a tile in tile coordinates.
+const DefaultExtent = 4096
+
+const (
+	geomPoint   = 1
+	geomPolygon = 3
+
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// Tile is a set of layers.
+type Tile struct {
+	Layers []*Layer
+}
+
+// Layer is a named set of features sharing a key/value table.
+type Layer struct {
+	Name   string
+	Extent uint32
+
+	features []feature
+	keys     []string
+	keyIndex map[string]uint32
+	values   []interface{}
+	valIndex map[interface{}]uint32
+}
+
+type feature struct {
+	id       uint64
+	tags     []uint32
+	geomType uint64
+	geometry []uint32
+}
+
+// NewLayer returns an empty layer.
+func NewLayer(name string, extent uint32) *Layer {
+	return &Layer{
+		Name:     name,
+		Extent:   extent,
+		keyIndex: map[string]uint32{},
+		valIndex: map[interface{}]uint32{},
+	}
+}
+
+// Len returns the number of features in the layer.
+func (l *Layer) Len() int {
+	return len(l.features)
+}
+
+func (l *Layer) tags(properties map[string]interface{}) []uint32 {
+	keys := make([]string, 0, len(properties))
+	for key := range properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	tags := make([]uint32, 0, 2*len(keys))
+	for _, key := range keys {
+		value := normalize(properties[key])
+		if value == nil {
+			continue
+		}
+		k, ok := l.keyIndex[key]
+		if !ok {
+			k = uint32(len(l.keys))
+			l.keys = append(l.keys, key)
+			l.keyIndex[key] = k
+		}
+		v, ok := l.valIndex[value]
+		if !ok {
+			v = uint32(len(l.values))
+			l.values = append(l.values, value)
+			l.valIndex[value] = v
+		}
+		tags = append(tags, k, v)
+	}
+	return tags
+}
+
+// normalize maps property values to the types the encoder knows.
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, float64, int64, uint64:
+		return v
+	case int:
+		return int64(v)
+	case uint:
+		return uint64(v)
+	case uint32:
+		return uint64(v)
+	case float32:
+		return float64(v)
+	case nil:
+		return nil
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func command(id, count uint32) uint32 {
+	return id&0x7 | count<<3
+}
+
+func zigzag(n int32) uint32 {
+	return uint32((n << 1) ^ (n >> 31))
+}
+
+// AddPoint adds a point feature at tile coordinates x, y.
+func (l *Layer) AddPoint(id uint64, x, y int32, properties map[string]interface{}) {
+	l.features = append(l.features, feature{
+		id:       id,
+		tags:     l.tags(properties),
+		geomType: geomPoint,
+		geometry: []uint32{command(cmdMoveTo, 1), zigzag(x), zigzag(y)},
+	})
+}
+
+// AddPolygon adds a polygon feature with a single ring given in tile
+// coordinates. The ring may be open or closed and in either winding
+// order; it's rewound clockwise as the spec requires for exterior rings.
+func (l *Layer) AddPolygon(id uint64, ring [][2]int32, properties map[string]interface{}) {
+	if n := len(ring); n > 1 && ring[0] == ring[n-1] {
+		ring = ring[:n-1]
+	}
+	if len(ring) < 3 {
+		return
+	}
+	if area(ring) < 0 {
+		reversed := make([][2]int32, len(ring))
+		for i := range ring {
+			reversed[len(ring)-1-i] = ring[i]
+		}
+		ring = reversed
+	}
+
+	geometry := []uint32{command(cmdMoveTo, 1), zigzag(ring[0][0]), zigzag(ring[0][1])}
+	geometry = append(geometry, command(cmdLineTo, uint32(len(ring)-1)))
+	for i := 1; i < len(ring); i++ {
+		geometry = append(geometry, zigzag(ring[i][0]-ring[i-1][0]), zigzag(ring[i][1]-ring[i-1][1]))
+	}
+	geometry = append(geometry, command(cmdClosePath, 1))
+
+	l.features = append(l.features, feature{
+		id:       id,
+		tags:     l.tags(properties),
+		geomType: geomPolygon,
+		geometry: geometry,
+	})
+}
+
+// area returns the signed area of ring in tile coordinates, positive for
+// clockwise rings since the y axis points down.
+func area(ring [][2]int32) int64 {
+	var sum int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		sum += int64(ring[i][0])*int64(ring[j][1]) - int64(ring[j][0])*int64(ring[i][1])
+	}
+	return sum
+}
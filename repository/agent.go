@@ -3,9 +3,12 @@ package repository
 import (
 	"fmt"
 	//	"log"
+	"strconv"
 	"time"
 
-	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
 )
 
@@ -22,6 +25,9 @@ type Agent struct {
 	Lat string `json:"lat"`
 	Lon string `json:"lon"`
 	TS  string `json:"gps_ts"`
+
+	// Place describes where the agent last was, e.g. "near Main St & 5th".
+	Place string `json:"place"`
 }
 
 func (a *Agent) Vehicle() *Vehicle {
@@ -33,6 +39,13 @@ func (a *Agent) Vehicle() *Vehicle {
 	return nil
 }
 
+// Point returns the last reported location of the agent, ok is false
+// if it has never reported a valid one.
+func (a *Agent) Point() (p geo.Point, ok bool) {
+	p, err := parsePoint(a.Lat, a.Lon)
+	return p, err == nil
+}
+
 func GetAllAgents() []Agent {
 	var agents []Agent
 
@@ -66,13 +79,17 @@ func FilterAgents(agentState string) []Agent {
 }
 
 func GetAgentByUUID(uUID string) (Agent, error) {
+	return agentByUUID(db, uUID)
+}
+
+func agentByUUID(q *gorm.DB, uUID string) (Agent, error) {
 	var agent Agent
 	if uUID == "" {
 		return agent, &AgentError{What: "uUID", Type: "Empty", Arg: uUID}
 	}
 
-	db.Where(&Agent{UUID: uUID}).First(&agent)
-	if db.NewRecord(&agent) {
+	q.Where(&Agent{UUID: uUID}).First(&agent)
+	if q.NewRecord(&agent) {
 		return agent, AgentError{
 			What: "Agent",
 			Type: "Not-Found",
@@ -82,7 +99,29 @@ func GetAgentByUUID(uUID string) (Agent, error) {
 	return agent, nil
 }
 
+func GetAgentByID(iD uint) (Agent, error) {
+	var agent Agent
+	db.First(&agent, iD)
+	if db.NewRecord(&agent) {
+		return agent, AgentError{
+			What: "Agent",
+			Type: "Not-Found",
+			Arg:  fmt.Sprintf("%d", iD),
+		}
+	}
+	return agent, nil
+}
+
 func CreateNewAgent(uUID string) (Agent, error) {
+	var agent Agent
+	err := transaction(func(tx *Tx) (err error) {
+		agent, err = tx.createAgent(uUID)
+		return err
+	})
+	return agent, err
+}
+
+func (tx *Tx) createAgent(uUID string) (Agent, error) {
 	var agent Agent
 	if uUID == "" {
 		return agent, &AgentError{What: "uUID", Type: "Empty", Arg: uUID}
@@ -90,7 +129,7 @@ func CreateNewAgent(uUID string) (Agent, error) {
 	agent = Agent{
 		UUID: uUID,
 	}
-	db.Create(&agent)
+	tx.Create(&agent)
 	if agent == (Agent{}) {
 		return agent, AgentError{
 			What: "Agent",
@@ -98,40 +137,99 @@ func CreateNewAgent(uUID string) (Agent, error) {
 			Arg:  uUID,
 		}
 	}
-	return agent, nil
+	return agent, System.emitChange(tx, AGENT_CREATED, agent.UUID, nil, agent)
 }
 
 func SetLabelByUUID(uUID string, label string) error {
-	var agent Agent
-	agent, err := GetAgentByUUID(uUID)
-	if err != nil {
-		return err
-	}
-
-	agent.Label = label
-	db.Save(&agent)
-	return nil
+	return System.SetLabelByUUID(uUID, label)
 }
 
-func SyncAgentByUUID(uUID string, lat string, lon string, ts string) error {
-	var agent Agent
-	agent, err := GetAgentByUUID(uUID)
-	if (err != nil) && (agent == Agent{}) {
-		agent, err = CreateNewAgent(uUID)
+func (a Actor) SetLabelByUUID(uUID string, label string) error {
+	return transaction(func(tx *Tx) error {
+		agent, err := agentByUUID(tx.DB, uUID)
 		if err != nil {
 			return err
 		}
-	} else if err != nil {
+
+		before := agent
+		agent.Label = label
+		tx.Save(&agent)
+		return a.emitChange(tx, AGENT_LABEL_SET, agent.UUID, before, agent)
+	})
+}
+
+func SyncAgentByUUID(uUID string, lat string, lon string, ts string, speed string) error {
+	return transaction(func(tx *Tx) error {
+		agent, err := agentByUUID(tx.DB, uUID)
+		if (err != nil) && (agent == Agent{}) {
+			agent, err = tx.createAgent(uUID)
+			if err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		agent.Lat = lat
+		agent.Lon = lon
+		agent.TS = ts
+		agent.Place = ""
+		if p, err := parsePoint(lat, lon); err == nil {
+			agent.Place = geocode.Describe(p)
+		}
+		tx.Save(&agent)
+
+		if err := tx.trackAgent(agent, lat, lon, ts, speed); err != nil {
+			return err
+		}
+
+		return tx.emit(NEW_AGENT, agent.UUID, agent)
+	})
+}
+
+// trackAgent records the fix in the position history and runs the
+// detectors on it. Fixes that can't be parsed are left out of history.
+func (tx *Tx) trackAgent(agent Agent, lat string, lon string, ts string, speed string) error {
+	p, err := parsePoint(lat, lon)
+	if err != nil {
+		return nil
+	}
+	t, err := ParseTS(ts)
+	if err != nil {
+		t = time.Now().UTC()
+	}
+	var reported *float64
+	if s, err := strconv.ParseFloat(speed, 64); err == nil {
+		reported = &s
+	}
+
+	vehicle := agent.Vehicle()
+	var vehicleID uint
+	if vehicle != nil {
+		vehicleID = vehicle.ID
+	}
+	position, previous := recordPosition(tx.DB, agent, vehicleID, p.Lat, p.Lon, t, reported)
+	if vehicle == nil {
+		return nil
+	}
+	if err := tx.detectOverspeed(*vehicle, position); err != nil {
 		return err
 	}
-	agent.Lat = lat
-	agent.Lon = lon
-	agent.TS = ts
-	db.Save(&agent)
-	newAgentEvent := event.MakeKind(NEW_AGENT)
-	newAgentEvent.Emit(agent)
+	if err := tx.detectIdle(*vehicle, position); err != nil {
+		return err
+	}
+	return tx.detectGeofenceCrossings(*vehicle, position, previous)
+}
 
-	return nil
+func parsePoint(lat string, lon string) (geo.Point, error) {
+	var p geo.Point
+	var err error
+	if p.Lat, err = strconv.ParseFloat(lat, 64); err != nil {
+		return p, &AgentError{What: "Lat", Type: "Invalid", Arg: lat}
+	}
+	if p.Lon, err = strconv.ParseFloat(lon, 64); err != nil {
+		return p, &AgentError{What: "Lon", Type: "Invalid", Arg: lon}
+	}
+	return p, nil
 }
 
 type AgentError struct {
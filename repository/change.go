@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"encoding/json"
+
+	"github.com/cad/vehicle-tracker-api/event"
+)
+
+// Kinds of the events emitted on mutations of the vehicles, groups,
+// agents and users. Their payload is a Change.
+const (
+	VEHICLE_CREATED     = "VEHICLE-CREATED"
+	VEHICLE_DELETED     = "VEHICLE-DELETED"
+	VEHICLE_AGENT_SET   = "VEHICLE-AGENT-SET"
+	VEHICLE_AGENT_UNSET = "VEHICLE-AGENT-UNSET"
+	VEHICLE_GROUPS_SET  = "VEHICLE-GROUPS-SET"
+
+	GROUP_CREATED = "GROUP-CREATED"
+	GROUP_DELETED = "GROUP-DELETED"
+
+	AGENT_CREATED   = "AGENT-CREATED"
+	AGENT_LABEL_SET = "AGENT-LABEL-SET"
+
+	USER_CREATED    = "USER-CREATED"
+	USER_DELETED    = "USER-DELETED"
+	USER_GROUPS_SET = "USER-GROUPS-SET"
+	USER_ROLE_SET   = "USER-ROLE-SET"
+)
+
+// CHANGE_KINDS lists the kinds of the mutation events.
+var CHANGE_KINDS []string = []string{
+	VEHICLE_CREATED, VEHICLE_DELETED, VEHICLE_AGENT_SET, VEHICLE_AGENT_UNSET, VEHICLE_GROUPS_SET,
+	GROUP_CREATED, GROUP_DELETED,
+	AGENT_CREATED, AGENT_LABEL_SET,
+	USER_CREATED, USER_DELETED, USER_GROUPS_SET, USER_ROLE_SET,
+}
+
+// Change is the payload of the mutation events. Before is nil for
+// creations and After for deletions. Actor is the UUID of the user who
+// made the change, empty for changes made by agents or the system.
+type Change struct {
+	Actor  string      `json:"actor,omitempty"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Actor makes changes on behalf of the user with its UUID, who is
+// recorded in their events.
+type Actor string
+
+// System is the actor of the changes made by agents or the system.
+const System Actor = ""
+
+// emitChange stores a mutation event in the transaction.
+func (a Actor) emitChange(tx *Tx, kind string, key string, before interface{}, after interface{}) error {
+	return tx.emit(kind, key, Change{Actor: string(a), Before: before, After: after})
+}
+
+// decodeChange returns a Decoder of the changes of the entities of the
+// type of prototype.
+func decodeChange(prototype interface{}) event.Decoder {
+	decode := event.DecodeAs(prototype)
+	return func(data []byte) (interface{}, error) {
+		var raw struct {
+			Actor  string          `json:"actor"`
+			Before json.RawMessage `json:"before"`
+			After  json.RawMessage `json:"after"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+		change := Change{Actor: raw.Actor}
+		for state, data := range map[*interface{}]json.RawMessage{&change.Before: raw.Before, &change.After: raw.After} {
+			if len(data) == 0 || string(data) == "null" {
+				continue
+			}
+			decoded, err := decode(data)
+			if err != nil {
+				return nil, err
+			}
+			*state = decoded
+		}
+		return change, nil
+	}
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/geocode"
+)
+
+const (
+	GEOFENCE_ENTER = "GEOFENCE-ENTER"
+	GEOFENCE_EXIT  = "GEOFENCE-EXIT"
+)
+
+// GeofenceCrossing is a vehicle entering or leaving a geofence.
+type GeofenceCrossing struct {
+	PlateID      string    `json:"plate_id"`
+	GeofenceID   uint      `json:"geofence_id"`
+	GeofenceName string    `json:"geofence_name"`
+	GeofenceKind string    `json:"geofence_kind"`
+	Lat          float64   `json:"lat"`
+	Lon          float64   `json:"lon"`
+	Place        string    `json:"place"`
+	TS           time.Time `json:"ts"`
+}
+
+// detectGeofenceCrossings emits an event for every geofence vehicle
+// entered or left between the previous fix and position. Nothing is
+// emitted without a previous fix of the same vehicle to compare with.
+func (tx *Tx) detectGeofenceCrossings(vehicle Vehicle, position Position, previous *Position) error {
+	if previous == nil || previous.VehicleID != vehicle.ID {
+		return nil
+	}
+
+	before := map[uint]bool{}
+	for _, geofence := range GeofencesContaining(previous.Point()) {
+		before[geofence.ID] = true
+	}
+	after := GeofencesContaining(position.Point())
+
+	crossing := func(geofence Geofence) GeofenceCrossing {
+		return GeofenceCrossing{
+			PlateID:      vehicle.PlateID,
+			GeofenceID:   geofence.ID,
+			GeofenceName: geofence.Name,
+			GeofenceKind: geofence.Kind,
+			Lat:          position.Lat,
+			Lon:          position.Lon,
+			Place:        geocode.Describe(position.Point()),
+			TS:           position.TS,
+		}
+	}
+
+	for _, geofence := range after {
+		if before[geofence.ID] {
+			delete(before, geofence.ID)
+			continue
+		}
+		if err := tx.emit(GEOFENCE_ENTER, vehicle.PlateID, crossing(geofence)); err != nil {
+			return err
+		}
+	}
+	for _, geofence := range GetAllGeofences() {
+		if before[geofence.ID] {
+			if err := tx.emit(GEOFENCE_EXIT, vehicle.PlateID, crossing(geofence)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
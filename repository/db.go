@@ -15,12 +15,32 @@ func ConnectDB(dbType string, dbURL string) {
 	if err != nil {
 		panic("failed to connect database")
 	}
+	// Users created before roles have none until they're backfilled.
+	roleless := db.HasTable(&User{}) && !db.Dialect().HasColumn("users", "role")
 	db.AutoMigrate(
 		&User{},
 		&Vehicle{},
 		&Agent{},
 		&Group{},
+		&Position{},
+		&Geofence{},
+		&Overspeed{},
+		&Idle{},
+		&Assignment{},
+		&Membership{},
+		&DeletedVehicle{},
+		&Webhook{},
+		&WebhookDelivery{},
+		&StoredEvent{},
+		&EventCursor{},
+		&Session{},
 	)
+	backfillHistory()
+	if roleless {
+		backfillRoles()
+	}
+	backfillSessions()
+	backfillCursor(WEBHOOK_CURSOR)
 }
 
 func CloseDB() {
@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// The payloads of the events received from other instances are decoded
+// to the types of those published locally.
+func init() {
+	for kind, prototype := range map[string]interface{}{
+		VEHICLE_UPDATE:  Vehicle{},
+		VEHICLE_DELETE:  Vehicle{},
+		NEW_AGENT:       Agent{},
+		GEOFENCE_ENTER:  GeofenceCrossing{},
+		GEOFENCE_EXIT:   GeofenceCrossing{},
+		OVERSPEED_START: Overspeed{},
+		OVERSPEED_END:   Overspeed{},
+		IDLE_START:      Idle{},
+		IDLE_END:        Idle{},
+	} {
+		event.RegisterPayload(kind, event.DecodeAs(prototype))
+	}
+	event.RegisterLog(loadEventPayload)
+	for _, kind := range CHANGE_KINDS {
+		switch {
+		case strings.HasPrefix(kind, "VEHICLE-"):
+			event.RegisterPayload(kind, decodeChange(Vehicle{}))
+		case strings.HasPrefix(kind, "GROUP-"):
+			event.RegisterPayload(kind, decodeChange(Group{}))
+		case strings.HasPrefix(kind, "AGENT-"):
+			event.RegisterPayload(kind, decodeChange(Agent{}))
+		case strings.HasPrefix(kind, "USER-"):
+			event.RegisterPayload(kind, decodeChange(User{}))
+		}
+	}
+}
+
+// StoredEvent is an event in the event log. Events are stored in the
+// transaction of the change they're about, so the log misses none of the
+// changes committed, and holds none of those rolled back.
+type StoredEvent struct {
+	ID        uint            `json:"id"   gorm:"primary_key"`
+	CreatedAt time.Time       `json:"time"`
+	Kind      string          `json:"kind" gorm:"index"`
+	Key       string          `json:"key"`
+	Data      json.RawMessage `json:"data" gorm:"-"`
+	Payload   string          `json:"-"`
+}
+
+func (e *StoredEvent) AfterFind() error {
+	e.Data = json.RawMessage(e.Payload)
+	return nil
+}
+
+// loadEventPayload returns the payload of the event of the log with id.
+func loadEventPayload(id uint) ([]byte, error) {
+	var e StoredEvent
+	if err := db.Where("id = ?", id).First(&e).Error; err != nil {
+		return nil, err
+	}
+	return []byte(e.Payload), nil
+}
+
+// GetStoredEvents returns up to limit events of the log after the one
+// with id after, oldest first, only those of kinds unless it's empty.
+//
+// As transactions are serialized, see transaction, the ids of the events
+// follow the order of the commits: an event committed later never gets a
+// lower id than the events that can be read already.
+func GetStoredEvents(after uint, kinds []string, limit int) []StoredEvent {
+	events := make([]StoredEvent, 0)
+	q := db.Where("id > ?", after).Order("id").Limit(limit)
+	if len(kinds) > 0 {
+		q = q.Where("kind IN (?)", kinds)
+	}
+	q.Find(&events)
+	return events
+}
+
+// EventCursor is the id of the last event of the log a consumer of the
+// log handled, persisted so that it resumes from there.
+type EventCursor struct {
+	Name    string `gorm:"primary_key"`
+	EventID uint
+}
+
+// backfillCursor starts the cursor at the end of the log unless it
+// exists, so that a new consumer only handles the events from now on.
+func backfillCursor(name string) {
+	var cursor EventCursor
+	if !db.Where("name = ?", name).First(&cursor).RecordNotFound() {
+		return
+	}
+	var last StoredEvent
+	db.Order("id desc").First(&last)
+	db.Create(&EventCursor{Name: name, EventID: last.ID})
+}
+
+// Tx is a transaction, along with the events of the changes made in it.
+type Tx struct {
+	*gorm.DB
+	events []event.Event
+}
+
+// emit stores the event in the transaction, to be published once it's
+// committed.
+func (tx *Tx) emit(kind string, key string, payload interface{}) error {
+	j, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	stored := StoredEvent{Kind: kind, Key: key, Payload: string(j)}
+	if err := tx.Create(&stored).Error; err != nil {
+		return err
+	}
+	tx.events = append(tx.events, event.Event{Kind: kind, Key: key, Payload: payload, LogID: stored.ID})
+	return nil
+}
+
+// txLock serializes the transactions on SQLite, which allows a single
+// writer, so that they wait for each other rather than fail.
+var txLock sync.Mutex
+
+// EVENT_LOG_LOCK is the key of the Postgres advisory lock serializing the
+// transactions, across the instances of the API.
+const EVENT_LOG_LOCK = 7201
+
+// transaction runs f in a transaction, committed unless f fails. The
+// events emitted in it are published once it's committed.
+//
+// Transactions are serialized, with txLock on SQLite and the advisory
+// lock EVENT_LOG_LOCK held until the commit on Postgres, so that no
+// transaction stores events while another one that stored some is still
+// open: the ids of the events are handed out in the order of the commits.
+func transaction(f func(tx *Tx) error) error {
+	if db.Dialect().GetName() == "sqlite3" {
+		txLock.Lock()
+		defer txLock.Unlock()
+	}
+
+	tx := &Tx{DB: db.Begin()}
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if db.Dialect().GetName() == "postgres" {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", EVENT_LOG_LOCK).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	for _, e := range tx.events {
+		event.MakeKind(e.Kind).EmitLogged(e.Key, e.LogID, e.Payload)
+	}
+	return nil
+}
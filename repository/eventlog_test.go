@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+)
+
+// The tests of the event log against Postgres run when TEST_POSTGRES_URL
+// is set, e.g. to "postgres://localhost/test?sslmode=disable".
+func postgresURL(t *testing.T) string {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+	return url
+}
+
+func TestStoredEventsCommitOrder(t *testing.T) {
+	// Init
+	ConnectDB("postgres", postgresURL(t))
+	defer CloseDB()
+
+	// Prepare
+	var last StoredEvent
+	db.Order("id desc").First(&last)
+	kinds := []string{"TEST-FIRST", "TEST-SECOND"}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 2)
+	go func() {
+		done <- transaction(func(tx *Tx) error {
+			if err := tx.emit("TEST-FIRST", "", 1); err != nil {
+				return err
+			}
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	go func() {
+		done <- transaction(func(tx *Tx) error {
+			return tx.emit("TEST-SECOND", "", 2)
+		})
+	}()
+
+	// Execute
+	// A reader polls while the first transaction is still open and the
+	// second one tries to commit, then catches up from its cursor.
+	time.Sleep(200 * time.Millisecond)
+	events := GetStoredEvents(last.ID, kinds, 10)
+	cursor := last.ID
+	if len(events) > 0 {
+		cursor = events[len(events)-1].ID
+	}
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error(fmt.Sprintf("expected no error but got \"%s\"", err))
+			return
+		}
+	}
+	events = append(events, GetStoredEvents(cursor, kinds, 10)...)
+
+	// Test
+	got := make([]string, 0)
+	for _, e := range events {
+		got = append(got, e.Kind)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(kinds) {
+		t.Error(fmt.Sprintf("expected events \"%v\" but got \"%v\"", kinds, got))
+		return
+	}
+}
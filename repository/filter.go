@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	AGENT_STATE_ASSIGNED   = "ASSIGNED"
+	AGENT_STATE_UNASSIGNED = "UNASSIGNED"
+)
+
+// VehicleFilter selects vehicles. Every field is optional; a vehicle
+// matches when it matches every given field, and a field matches when
+// any of its values does.
+type VehicleFilter struct {
+	Types      []string
+	GroupIDs   []uint
+	PlateIDs   []string
+	AgentState string
+}
+
+// Match reports whether vehicle passes the filter.
+func (f VehicleFilter) Match(vehicle Vehicle) bool {
+	if len(f.Types) > 0 && !containsString(f.Types, vehicle.Type) {
+		return false
+	}
+	if len(f.PlateIDs) > 0 && !containsString(f.PlateIDs, vehicle.PlateID) {
+		return false
+	}
+	switch f.AgentState {
+	case AGENT_STATE_ASSIGNED:
+		if vehicle.Agent == nil {
+			return false
+		}
+	case AGENT_STATE_UNASSIGNED:
+		if vehicle.Agent != nil {
+			return false
+		}
+	}
+	if len(f.GroupIDs) > 0 {
+		found := false
+		for _, group := range vehicle.Groups {
+			for _, groupID := range f.GroupIDs {
+				found = found || group.ID == groupID
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether the filter lets every vehicle through.
+func (f VehicleFilter) Empty() bool {
+	return len(f.Types) == 0 && len(f.GroupIDs) == 0 && len(f.PlateIDs) == 0 && f.AgentState == ""
+}
+
+// VehicleIDs returns the ids of the vehicles passing the filter, nil when
+// it lets every vehicle through.
+func (f VehicleFilter) VehicleIDs() []uint {
+	if f.Empty() {
+		return nil
+	}
+	vehicleIDs := make([]uint, 0)
+	f.scope(db.Model(&Vehicle{})).Pluck("vehicles.id", &vehicleIDs)
+	return vehicleIDs
+}
+
+// MatchAgent reports whether the agent is assigned to a vehicle passing
+// the filter. Every agent passes an empty filter.
+func (f VehicleFilter) MatchAgent(agent Agent) bool {
+	if f.Empty() {
+		return true
+	}
+	vehicle, err := GetVehicleByAgentUUID(agent.UUID)
+	return err == nil && f.Match(vehicle)
+}
+
+// RestrictAgents keeps the agents assigned to a vehicle passing the
+// filter.
+func RestrictAgents(agents []Agent, f VehicleFilter) []Agent {
+	if f.Empty() {
+		return agents
+	}
+	agentIDs := map[uint]bool{}
+	for _, vehicle := range FilterVehiclesBy(f) {
+		agentIDs[vehicle.AgentID] = true
+	}
+	restricted := make([]Agent, 0, len(agents))
+	for _, agent := range agents {
+		if agentIDs[agent.ID] {
+			restricted = append(restricted, agent)
+		}
+	}
+	return restricted
+}
+
+// scope restricts a vehicle query to the filter.
+func (f VehicleFilter) scope(q *gorm.DB) *gorm.DB {
+	if len(f.Types) > 0 {
+		q = q.Where("vehicles.type IN (?)", f.Types)
+	}
+	if len(f.PlateIDs) > 0 {
+		q = q.Where("vehicles.plate_id IN (?)", f.PlateIDs)
+	}
+	switch f.AgentState {
+	case AGENT_STATE_ASSIGNED:
+		q = q.Where("vehicles.agent_id is not null AND vehicles.agent_id <> 0")
+	case AGENT_STATE_UNASSIGNED:
+		q = q.Where("vehicles.agent_id is null OR vehicles.agent_id = 0")
+	}
+	if len(f.GroupIDs) > 0 {
+		q = q.Where("vehicles.id IN (SELECT vehicle_id FROM vehicle_group WHERE group_id IN (?))", f.GroupIDs)
+	}
+	return q
+}
+
+// FilterVehiclesBy returns the vehicles passing the filter.
+func FilterVehiclesBy(filter VehicleFilter) []Vehicle {
+	var vehicles []Vehicle
+	filter.scope(db.Preload("Groups").Preload("Agent")).Find(&vehicles)
+	return vehicles
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
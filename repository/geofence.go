@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	GEOFENCE_ZONE  = "ZONE"
+	GEOFENCE_DEPOT = "DEPOT"
+)
+
+var GEOFENCE_KINDS []string = []string{GEOFENCE_ZONE, GEOFENCE_DEPOT}
+
+type Geofence struct {
+	ID        uint      `json:"id"          gorm:"primary_key"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `json:"name"        gorm:"not null;unique_index"`
+	Kind      string    `json:"kind"`
+
+	// SpeedLimit in km/h, zero means the geofence doesn't limit speed.
+	SpeedLimit float64 `json:"speed_limit"`
+
+	Polygon     []geo.Point `json:"polygon"     gorm:"-"`
+	Coordinates string      `json:"-"`
+}
+
+func (g *Geofence) BeforeSave() error {
+	j, err := json.Marshal(g.Polygon)
+	if err != nil {
+		return err
+	}
+	g.Coordinates = string(j)
+	return nil
+}
+
+func (g *Geofence) AfterFind() error {
+	if g.Coordinates == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(g.Coordinates), &g.Polygon)
+}
+
+// Contains reports whether p is inside the geofence.
+func (g *Geofence) Contains(p geo.Point) bool {
+	return geo.Contains(g.Polygon, p)
+}
+
+func GetAllGeofences() []Geofence {
+	var geofences []Geofence
+
+	db.Find(&geofences)
+
+	return geofences
+}
+
+func GetGeofenceByID(iD uint) (Geofence, error) {
+	var geofence Geofence
+	db.Where(&Geofence{ID: iD}).First(&geofence)
+	if geofence.ID != 0 {
+		return geofence, nil
+	}
+	return geofence, &GeofenceError{What: "Geofence.ID", Type: "Not-Found", Arg: fmt.Sprintf("%d", iD)}
+}
+
+// GeofencesContaining returns every geofence p falls into.
+func GeofencesContaining(p geo.Point) []Geofence {
+	geofences := make([]Geofence, 0)
+	for _, geofence := range GetAllGeofences() {
+		if geofence.Contains(p) {
+			geofences = append(geofences, geofence)
+		}
+	}
+	return geofences
+}
+
+func CreateGeofence(name string, kind string, speedLimit float64, polygon []geo.Point) (Geofence, error) {
+	var geofence Geofence
+	if name == "" {
+		return geofence, &GeofenceError{What: "name", Type: "Empty", Arg: name}
+	}
+
+	kindFound := false
+	for _, item := range GEOFENCE_KINDS {
+		if kind == item {
+			kindFound = true
+		}
+	}
+	if !kindFound {
+		return geofence, &GeofenceError{What: "GeofenceKind", Type: "Not-Found", Arg: kind}
+	}
+
+	if len(polygon) < 3 {
+		return geofence, &GeofenceError{What: "Geofence.Polygon", Type: "Too-Few-Points", Arg: fmt.Sprintf("%d", len(polygon))}
+	}
+
+	geofence = Geofence{
+		Name:       name,
+		Kind:       kind,
+		SpeedLimit: speedLimit,
+		Polygon:    polygon,
+	}
+	db.Create(&geofence)
+	if db.NewRecord(&geofence) {
+		return geofence, &GeofenceError{What: "Geofence.Name", Type: "Already-Exists", Arg: name}
+	}
+	return geofence, nil
+}
+
+func DeleteGeofence(iD uint) error {
+	geofence, err := GetGeofenceByID(iD)
+	if err != nil {
+		return err
+	}
+
+	db.Unscoped().Delete(&geofence)
+	return nil
+}
+
+type GeofenceError struct {
+	What string
+	Type string
+	Arg  string
+}
+
+func (e GeofenceError) Error() string {
+	return fmt.Sprintf("%s: <%s> %s", e.Type, e.What, e.Arg)
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Assignment records an agent being assigned to a vehicle. An assignment
+// is current while EndedAt is nil.
+type Assignment struct {
+	ID        uint       `json:"id"          gorm:"primary_key"`
+	CreatedAt time.Time  `json:"-"`
+	VehicleID uint       `json:"-"           gorm:"index"`
+	AgentID   uint       `json:"-"           gorm:"index"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+}
+
+// Membership records a vehicle being in a group. The group name is kept
+// so that memberships of deleted groups can still be shown. A membership
+// is current while EndedAt is nil.
+type Membership struct {
+	ID        uint       `json:"id"          gorm:"primary_key"`
+	CreatedAt time.Time  `json:"-"`
+	VehicleID uint       `json:"-"           gorm:"index"`
+	GroupID   uint       `json:"group_id"    gorm:"index"`
+	GroupName string     `json:"group_name"`
+	StartedAt time.Time  `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+}
+
+// DeletedVehicle keeps a vehicle once it's deleted, so that the fleet can
+// still be shown as it was before. The vehicle existed from its CreatedAt
+// until EndedAt, and its history stays under VehicleID.
+type DeletedVehicle struct {
+	ID        uint      `json:"-"           gorm:"primary_key"`
+	VehicleID uint      `json:"-"           gorm:"index"`
+	PlateID   string    `json:"plate_id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"-"`
+	EndedAt   time.Time `json:"-"           gorm:"index"`
+}
+
+// activeAt restricts a history query to the rows in effect at ts.
+const activeAt = "started_at <= ? AND (ended_at IS NULL OR ended_at > ?)"
+
+// startAssignment ends the current assignments of both the vehicle and the
+// agent and records the new one.
+func startAssignment(q *gorm.DB, vehicleID, agentID uint, at time.Time) {
+	at = at.UTC()
+	q.Model(&Assignment{}).
+		Where("(vehicle_id = ? OR agent_id = ?) AND ended_at IS NULL", vehicleID, agentID).
+		Update("ended_at", at)
+	q.Create(&Assignment{VehicleID: vehicleID, AgentID: agentID, StartedAt: at})
+}
+
+// endAssignment ends the current assignment of the vehicle.
+func endAssignment(q *gorm.DB, vehicleID uint, at time.Time) {
+	q.Model(&Assignment{}).
+		Where("vehicle_id = ? AND ended_at IS NULL", vehicleID).
+		Update("ended_at", at.UTC())
+}
+
+// setMemberships ends the current memberships of the vehicle to groups not
+// in groups and starts the missing ones.
+func setMemberships(q *gorm.DB, vehicleID uint, groups []*Group, at time.Time) {
+	at = at.UTC()
+	var current []Membership
+	q.Where("vehicle_id = ? AND ended_at IS NULL", vehicleID).Find(&current)
+
+	wanted := map[uint]bool{}
+	for _, group := range groups {
+		wanted[group.ID] = true
+	}
+	for _, membership := range current {
+		if !wanted[membership.GroupID] {
+			membership.EndedAt = &at
+			q.Save(&membership)
+		}
+		delete(wanted, membership.GroupID)
+	}
+	for _, group := range groups {
+		if wanted[group.ID] {
+			q.Create(&Membership{VehicleID: vehicleID, GroupID: group.ID, GroupName: group.Name, StartedAt: at})
+			delete(wanted, group.ID)
+		}
+	}
+}
+
+// retireVehicle ends the history of the vehicle and keeps it as deleted.
+func retireVehicle(q *gorm.DB, vehicle Vehicle, at time.Time) error {
+	at = at.UTC()
+	endAssignment(q, vehicle.ID, at)
+	q.Model(&Membership{}).
+		Where("vehicle_id = ? AND ended_at IS NULL", vehicle.ID).
+		Update("ended_at", at)
+	return q.Create(&DeletedVehicle{
+		VehicleID: vehicle.ID,
+		PlateID:   vehicle.PlateID,
+		Type:      vehicle.Type,
+		CreatedAt: vehicle.CreatedAt,
+		EndedAt:   at,
+	}).Error
+}
+
+// endGroupMemberships ends every current membership to the group.
+func endGroupMemberships(q *gorm.DB, groupID uint, at time.Time) {
+	q.Model(&Membership{}).
+		Where("group_id = ? AND ended_at IS NULL", groupID).
+		Update("ended_at", at.UTC())
+}
+
+// backfillHistory records the current assignments and memberships of
+// vehicles that have no history yet, e.g. those created before history was
+// kept. As the actual start is unknown, the vehicle creation time is used.
+func backfillHistory() {
+	var vehicles []Vehicle
+	db.Preload("Groups").Find(&vehicles)
+	for _, vehicle := range vehicles {
+		var count int
+		db.Model(&Assignment{}).Where("vehicle_id = ?", vehicle.ID).Count(&count)
+		if count == 0 && vehicle.AgentID != 0 {
+			db.Create(&Assignment{VehicleID: vehicle.ID, AgentID: vehicle.AgentID, StartedAt: vehicle.CreatedAt.UTC()})
+		}
+		db.Model(&Membership{}).Where("vehicle_id = ?", vehicle.ID).Count(&count)
+		if count == 0 && len(vehicle.Groups) > 0 {
+			setMemberships(db, vehicle.ID, vehicle.Groups, vehicle.CreatedAt)
+		}
+	}
+
+	// Vehicles used to be deleted without ending their history.
+	now := time.Now().UTC()
+	live := db.Table("vehicles").Select("id").QueryExpr()
+	db.Model(&Assignment{}).Where("ended_at IS NULL AND vehicle_id NOT IN (?)", live).Update("ended_at", now)
+	db.Model(&Membership{}).Where("ended_at IS NULL AND vehicle_id NOT IN (?)", live).Update("ended_at", now)
+}
+
+// agentVehicleAt returns the vehicle the agent was assigned to at ts, zero
+// if none.
+func agentVehicleAt(q *gorm.DB, agentID uint, ts time.Time) uint {
+	var assignment Assignment
+	q.Where("agent_id = ?", agentID).Where(activeAt, ts.UTC(), ts.UTC()).First(&assignment)
+	return assignment.VehicleID
+}
+
+// vehicleAsOf fills in the agent, position and groups the vehicle had at ts.
+func vehicleAsOf(vehicle Vehicle, ts time.Time) Vehicle {
+	ts = ts.UTC()
+	vehicle.Agent = nil
+	vehicle.AgentID = 0
+	vehicle.Groups = make([]*Group, 0)
+
+	var assignment Assignment
+	db.Where("vehicle_id = ?", vehicle.ID).Where(activeAt, ts, ts).First(&assignment)
+	if assignment.ID != 0 {
+		agent, err := GetAgentByID(assignment.AgentID)
+		if err == nil {
+			agent.Lat, agent.Lon, agent.TS, agent.Place = "", "", "", ""
+			var position Position
+			db.Where("vehicle_id = ? AND agent_id = ? AND ts <= ?", vehicle.ID, agent.ID, ts).
+				Order("ts desc").First(&position)
+			if position.ID != 0 {
+				agent.Lat = strconv.FormatFloat(position.Lat, 'f', -1, 64)
+				agent.Lon = strconv.FormatFloat(position.Lon, 'f', -1, 64)
+				agent.TS = strconv.FormatInt(position.TS.Unix(), 10)
+				agent.UpdatedAt = position.TS
+				agent.Place = geocode.Describe(position.Point())
+			}
+			vehicle.AgentID = agent.ID
+			vehicle.Agent = &agent
+		}
+	}
+
+	var memberships []Membership
+	db.Where("vehicle_id = ?", vehicle.ID).Where(activeAt, ts, ts).Order("group_id asc").Find(&memberships)
+	for _, membership := range memberships {
+		vehicle.Groups = append(vehicle.Groups, &Group{ID: membership.GroupID, Name: membership.GroupName})
+	}
+	return vehicle
+}
+
+// FilterVehiclesAsOf is FilterVehiclesBy for the fleet as it was at ts:
+// the vehicles that existed then, with the agent, position and groups they
+// had.
+func FilterVehiclesAsOf(ts time.Time, filter VehicleFilter) []Vehicle {
+	var vehicles []Vehicle
+	db.Order("id asc").Find(&vehicles)
+
+	var deleted []DeletedVehicle
+	db.Where("ended_at > ?", ts.UTC()).Find(&deleted)
+	for _, d := range deleted {
+		vehicles = append(vehicles, Vehicle{
+			ID:        d.VehicleID,
+			CreatedAt: d.CreatedAt,
+			UpdatedAt: d.EndedAt,
+			PlateID:   d.PlateID,
+			Type:      d.Type,
+		})
+	}
+	sort.Slice(vehicles, func(i, j int) bool { return vehicles[i].ID < vehicles[j].ID })
+
+	result := make([]Vehicle, 0)
+	for _, vehicle := range vehicles {
+		if vehicle.CreatedAt.After(ts) {
+			continue
+		}
+		vehicle = vehicleAsOf(vehicle, ts)
+		if filter.Match(vehicle) {
+			result = append(result, vehicle)
+		}
+	}
+	return result
+}
@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	IDLE_START = "IDLE-START"
+	IDLE_END   = "IDLE-END"
+)
+
+// Idle is a period during which a vehicle stood still. A stop becomes an
+// idle (Confirmed) once it lasts longer than the configured threshold;
+// shorter stops are discarded. An idle is open while EndedAt is nil.
+type Idle struct {
+	ID         uint       `json:"id"          gorm:"primary_key"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdatedAt  time.Time  `json:"-"`
+	VehicleID  uint       `json:"-"           gorm:"index"`
+	PlateID    string     `json:"plate_id"`
+	Confirmed  bool       `json:"-"`
+	StartedAt  time.Time  `json:"started_at"`
+	LastSeenAt time.Time  `json:"-"`
+	EndedAt    *time.Time `json:"ended_at"`
+	Lat        float64    `json:"lat"`
+	Lon        float64    `json:"lon"`
+	Place      string     `json:"place"`
+
+	// Duration in seconds.
+	Duration float64 `json:"duration"    gorm:"-"`
+}
+
+func (i *Idle) AfterFind() error {
+	end := i.LastSeenAt
+	if i.EndedAt != nil {
+		end = *i.EndedAt
+	}
+	i.Duration = end.Sub(i.StartedAt).Seconds()
+	return nil
+}
+
+func inDepot(p geo.Point) bool {
+	for _, geofence := range GeofencesContaining(p) {
+		if geofence.Kind == GEOFENCE_DEPOT {
+			return true
+		}
+	}
+	return false
+}
+
+func openIdle(q *gorm.DB, vehicleID uint) (Idle, bool) {
+	var idle Idle
+	q.Where("vehicle_id = ? AND ended_at IS NULL", vehicleID).First(&idle)
+	return idle, idle.ID != 0
+}
+
+// detectIdle opens, confirms or closes the idle of vehicle according to
+// the given fix.
+func (tx *Tx) detectIdle(vehicle Vehicle, position Position) error {
+	params := config.C.Idle
+	stationary := position.Speed <= params.Speed
+	if stationary && params.OutsideDepotsOnly && inDepot(position.Point()) {
+		stationary = false
+	}
+	idle, open := openIdle(tx.DB, vehicle.ID)
+
+	if stationary {
+		if !open {
+			idle = Idle{
+				VehicleID: vehicle.ID,
+				PlateID:   vehicle.PlateID,
+				StartedAt: position.TS,
+				Lat:       position.Lat,
+				Lon:       position.Lon,
+				Place:     geocode.Describe(position.Point()),
+			}
+		}
+		idle.LastSeenAt = position.TS
+		confirmed := !idle.Confirmed && position.TS.Sub(idle.StartedAt).Seconds() >= params.Threshold
+		if confirmed {
+			idle.Confirmed = true
+		}
+		tx.Save(&idle)
+		if confirmed {
+			idle.AfterFind()
+			return tx.emit(IDLE_START, idle.PlateID, idle)
+		}
+		return nil
+	}
+
+	if !open {
+		return nil
+	}
+	if !idle.Confirmed {
+		tx.Unscoped().Delete(&idle)
+		return nil
+	}
+	ts := position.TS
+	idle.EndedAt = &ts
+	tx.Save(&idle)
+	idle.AfterFind()
+	return tx.emit(IDLE_END, idle.PlateID, idle)
+}
+
+// GetIdlesByPlateID returns the idles of a vehicle that started between
+// from and to. Zero times leave that end of the range open.
+func GetIdlesByPlateID(plateID string, from, to time.Time) ([]Idle, error) {
+	vehicle, err := GetVehicleByPlateID(plateID)
+	if err != nil {
+		return nil, err
+	}
+	return getIdles(vehicle.ID, from, to), nil
+}
+
+func getIdles(vehicleID uint, from, to time.Time) []Idle {
+	idles := make([]Idle, 0)
+	q := db.Where("vehicle_id = ? AND confirmed = ?", vehicleID, true)
+	if !from.IsZero() {
+		q = q.Where("started_at >= ?", from.UTC())
+	}
+	if !to.IsZero() {
+		q = q.Where("started_at <= ?", to.UTC())
+	}
+	q.Order("started_at asc").Find(&idles)
+	return idles
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+const (
+	OVERSPEED_START = "OVERSPEED-START"
+	OVERSPEED_END   = "OVERSPEED-END"
+)
+
+// Overspeed is a period during which a vehicle went faster than the
+// limit that applied to it. An overspeed is open while EndedAt is nil.
+type Overspeed struct {
+	ID         uint       `json:"id"          gorm:"primary_key"`
+	CreatedAt  time.Time  `json:"-"`
+	UpdatedAt  time.Time  `json:"-"`
+	VehicleID  uint       `json:"-"           gorm:"index"`
+	PlateID    string     `json:"plate_id"`
+	GeofenceID uint       `json:"geofence_id"`
+	SpeedLimit float64    `json:"speed_limit"`
+	PeakSpeed  float64    `json:"peak_speed"`
+	StartedAt  time.Time  `json:"started_at"`
+	LastSeenAt time.Time  `json:"-"`
+	EndedAt    *time.Time `json:"ended_at"`
+
+	// Location of the peak speed.
+	Lat   float64 `json:"lat"`
+	Lon   float64 `json:"lon"`
+	Place string  `json:"place"`
+
+	// Duration in seconds.
+	Duration float64 `json:"duration"    gorm:"-"`
+}
+
+func (o *Overspeed) AfterFind() error {
+	end := o.LastSeenAt
+	if o.EndedAt != nil {
+		end = *o.EndedAt
+	}
+	o.Duration = end.Sub(o.StartedAt).Seconds()
+	return nil
+}
+
+// speedLimitAt returns the strictest limit that applies to vehicle at p,
+// along with the geofence imposing it (zero for the vehicle type limit).
+// A zero limit means no limit applies.
+func speedLimitAt(vehicle Vehicle, p geo.Point) (float64, uint) {
+	limit := config.C.Speeding.TypeLimits[vehicle.Type]
+	var geofenceID uint
+	for _, geofence := range GeofencesContaining(p) {
+		if geofence.SpeedLimit > 0 && (limit == 0 || geofence.SpeedLimit < limit) {
+			limit = geofence.SpeedLimit
+			geofenceID = geofence.ID
+		}
+	}
+	return limit, geofenceID
+}
+
+func openOverspeed(q *gorm.DB, vehicleID uint) (Overspeed, bool) {
+	var overspeed Overspeed
+	q.Where("vehicle_id = ? AND ended_at IS NULL", vehicleID).First(&overspeed)
+	return overspeed, overspeed.ID != 0
+}
+
+// detectOverspeed opens, extends or closes the overspeed of vehicle
+// according to the given fix. An overspeed is closed and another one
+// opened when a stricter limit starts to apply, e.g. in a school zone, so
+// that the overspeed is attributed to the geofence imposing it.
+func (tx *Tx) detectOverspeed(vehicle Vehicle, position Position) error {
+	limit, geofenceID := speedLimitAt(vehicle, position.Point())
+	overspeed, open := openOverspeed(tx.DB, vehicle.ID)
+
+	if limit > 0 && position.Speed > limit {
+		if open && limit < overspeed.SpeedLimit {
+			if err := tx.endOverspeed(overspeed, position.TS); err != nil {
+				return err
+			}
+			open = false
+		}
+		if !open {
+			overspeed = Overspeed{
+				VehicleID:  vehicle.ID,
+				PlateID:    vehicle.PlateID,
+				GeofenceID: geofenceID,
+				SpeedLimit: limit,
+				StartedAt:  position.TS,
+			}
+		}
+		overspeed.LastSeenAt = position.TS
+		if position.Speed > overspeed.PeakSpeed {
+			overspeed.PeakSpeed = position.Speed
+			overspeed.Lat = position.Lat
+			overspeed.Lon = position.Lon
+			overspeed.Place = geocode.Describe(position.Point())
+		}
+		tx.Save(&overspeed)
+		if !open {
+			return tx.emit(OVERSPEED_START, overspeed.PlateID, overspeed)
+		}
+		return nil
+	}
+
+	if open {
+		return tx.endOverspeed(overspeed, position.TS)
+	}
+	return nil
+}
+
+func (tx *Tx) endOverspeed(overspeed Overspeed, ts time.Time) error {
+	overspeed.EndedAt = &ts
+	tx.Save(&overspeed)
+	overspeed.AfterFind()
+	return tx.emit(OVERSPEED_END, overspeed.PlateID, overspeed)
+}
+
+// GetOverspeedsByPlateID returns the overspeeds of a vehicle that started
+// between from and to. Zero times leave that end of the range open.
+func GetOverspeedsByPlateID(plateID string, from, to time.Time) ([]Overspeed, error) {
+	vehicle, err := GetVehicleByPlateID(plateID)
+	if err != nil {
+		return nil, err
+	}
+	return getOverspeeds([]uint{vehicle.ID}, from, to), nil
+}
+
+// GetOverspeedsByGroupID returns the overspeeds of every vehicle in the
+// group passing the restriction that started between from and to.
+func GetOverspeedsByGroupID(groupID uint, restriction VehicleFilter, from, to time.Time) ([]Overspeed, error) {
+	if _, err := GetGroupByID(groupID); err != nil {
+		return nil, err
+	}
+	vehicleIDs := make([]uint, 0)
+	for _, vehicle := range FilterVehicles("", groupID, "") {
+		if restriction.Match(vehicle) {
+			vehicleIDs = append(vehicleIDs, vehicle.ID)
+		}
+	}
+	return getOverspeeds(vehicleIDs, from, to), nil
+}
+
+func getOverspeeds(vehicleIDs []uint, from, to time.Time) []Overspeed {
+	overspeeds := make([]Overspeed, 0)
+	if len(vehicleIDs) == 0 {
+		return overspeeds
+	}
+	q := db.Where("vehicle_id IN (?)", vehicleIDs)
+	if !from.IsZero() {
+		q = q.Where("started_at >= ?", from.UTC())
+	}
+	if !to.IsZero() {
+		q = q.Where("started_at <= ?", to.UTC())
+	}
+	q.Order("started_at asc").Find(&overspeeds)
+	return overspeeds
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Position is a single GPS fix reported by an agent. VehicleID records
+// the vehicle the agent was assigned to when the fix was taken, so that
+// history survives later reassignments.
+type Position struct {
+	ID        uint      `json:"-"     gorm:"primary_key"`
+	CreatedAt time.Time `json:"-"`
+	AgentID   uint      `json:"-"     gorm:"index"`
+	VehicleID uint      `json:"-"     gorm:"index"`
+	Lat       float64   `json:"lat"`
+	Lon       float64   `json:"lon"`
+	TS        time.Time `json:"ts"    gorm:"index"`
+
+	// Speed in km/h. SpeedReported tells whether the device sent it or
+	// it was derived from the previous fix.
+	Speed         float64 `json:"speed"`
+	SpeedReported bool    `json:"-"`
+}
+
+func (p *Position) Point() geo.Point {
+	return geo.Point{Lat: p.Lat, Lon: p.Lon}
+}
+
+// ParseTS parses a timestamp sent by an agent or a client. Unix seconds,
+// unix milliseconds and RFC3339 are accepted.
+func ParseTS(ts string) (time.Time, error) {
+	if n, err := strconv.ParseFloat(ts, 64); err == nil {
+		if n > 1e12 {
+			return time.Unix(0, int64(n*float64(time.Millisecond))).UTC(), nil
+		}
+		return time.Unix(0, int64(n*float64(time.Second))).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return t, &AgentError{What: "TS", Type: "Invalid", Arg: ts}
+	}
+	return t.UTC(), nil
+}
+
+// lastPositionBefore returns the latest fix of the agent taken before ts.
+func lastPositionBefore(q *gorm.DB, agentID uint, ts time.Time) (Position, bool) {
+	var position Position
+	q.Where("agent_id = ? AND ts < ?", agentID, ts).Order("ts desc").First(&position)
+	return position, position.ID != 0
+}
+
+// recordPosition stores a fix for agent. When the device didn't report
+// a speed it is derived from the previous fix.
+func recordPosition(q *gorm.DB, agent Agent, vehicleID uint, lat, lon float64, ts time.Time, speed *float64) (Position, *Position) {
+	position := Position{
+		AgentID:   agent.ID,
+		VehicleID: vehicleID,
+		Lat:       lat,
+		Lon:       lon,
+		TS:        ts,
+	}
+
+	var previous *Position
+	if prev, ok := lastPositionBefore(q, agent.ID, ts); ok {
+		previous = &prev
+	}
+
+	if speed != nil {
+		position.Speed = *speed
+		position.SpeedReported = true
+	} else if previous != nil {
+		dt := ts.Sub(previous.TS).Seconds()
+		if dt > 0 {
+			position.Speed = geo.Distance(previous.Point(), position.Point()) / dt * 3.6
+		}
+	}
+
+	q.Create(&position)
+	return position, previous
+}
+
+// GetAgentPositions returns the fixes of an agent between from and to,
+// oldest first. Zero times leave that end of the range open. Only the
+// fixes taken while the agent was assigned to a vehicle in vehicleIDs are
+// returned, unless it's nil.
+func GetAgentPositions(agentID uint, vehicleIDs []uint, from, to time.Time) []Position {
+	q := db.Where("agent_id = ?", agentID)
+	if vehicleIDs != nil {
+		if len(vehicleIDs) == 0 {
+			return make([]Position, 0)
+		}
+		q = q.Where("vehicle_id IN (?)", vehicleIDs)
+	}
+	return positionsBetween(q, from, to)
+}
+
+// GetVehiclePositions returns the fixes taken while an agent was assigned
+// to the vehicle between from and to, oldest first.
+func GetVehiclePositions(vehicleID uint, from, to time.Time) []Position {
+	return positionsBetween(db.Where("vehicle_id = ?", vehicleID), from, to)
+}
+
+// DensityCell is a cell of a grid and the number of fixes inside it.
+type DensityCell struct {
+	Col   int `gorm:"column:cell_col"`
+	Row   int `gorm:"column:cell_row"`
+	Count int `gorm:"column:cell_count"`
+}
+
+// CountPositionsInGrid counts the fixes taken between from and to in the
+// cells of a grid over bbox. Columns are split at the longitudes in
+// colEdges, west to east, and rows at the latitudes in rowEdges, north to
+// south. Only the cells holding fixes are returned. The fixes are counted
+// by the database, so the number of them doesn't matter.
+//
+// Only the fixes of the vehicles in vehicleIDs are counted, unless it's
+// nil.
+func CountPositionsInGrid(bbox geo.BBox, colEdges, rowEdges []float64, from, to time.Time, vehicleIDs []uint) []DensityCell {
+	cells := make([]DensityCell, 0)
+	if vehicleIDs != nil && len(vehicleIDs) == 0 {
+		return cells
+	}
+	colCase, colArgs := bucketCase("lon <", colEdges)
+	rowCase, rowArgs := bucketCase("lat >", rowEdges)
+	q := db.Table("positions").
+		Select(colCase+" AS cell_col, "+rowCase+" AS cell_row, COUNT(*) AS cell_count", append(colArgs, rowArgs...)...).
+		Where("lat BETWEEN ? AND ?", bbox.MinLat, bbox.MaxLat).
+		Where("lon BETWEEN ? AND ?", bbox.MinLon, bbox.MaxLon)
+	if !from.IsZero() {
+		q = q.Where("ts >= ?", from.UTC())
+	}
+	if !to.IsZero() {
+		q = q.Where("ts <= ?", to.UTC())
+	}
+	if vehicleIDs != nil {
+		q = q.Where("vehicle_id IN (?)", vehicleIDs)
+	}
+	q.Group("cell_col, cell_row").Order("cell_row, cell_col").Scan(&cells)
+	return cells
+}
+
+// bucketCase returns an SQL expression numbering the bucket a value falls
+// in, given the condition comparing it to the edge closing each bucket.
+func bucketCase(condition string, edges []float64) (string, []interface{}) {
+	expr := "CASE"
+	args := make([]interface{}, 0, len(edges))
+	for i, edge := range edges {
+		expr += " WHEN " + condition + " ? THEN " + strconv.Itoa(i)
+		args = append(args, edge)
+	}
+	return expr + " ELSE " + strconv.Itoa(len(edges)) + " END", args
+}
+
+func positionsBetween(q *gorm.DB, from, to time.Time) []Position {
+	positions := make([]Position, 0)
+	if !from.IsZero() {
+		q = q.Where("ts >= ?", from.UTC())
+	}
+	if !to.IsZero() {
+		q = q.Where("ts <= ?", to.UTC())
+	}
+	q.Order("ts asc").Find(&positions)
+	return positions
+}
+
+// ImportAgentPositions adds historical fixes to the history of the agent,
+// creating the agent if needed. Fixes are rejected as a whole when two of
+// them share a timestamp or one collides with a fix already recorded.
+// Speeds are derived from neighbouring fixes unless given. Fixes are
+// attributed to the vehicle the agent was assigned to at their time.
+// Imported fixes don't run the detectors.
+func ImportAgentPositions(uUID string, positions []Position) error {
+	sort.Sort(byTS(positions))
+	for i := range positions {
+		positions[i].TS = positions[i].TS.UTC()
+		if i > 0 && positions[i].TS.Equal(positions[i-1].TS) {
+			return &AgentError{What: "Position.TS", Type: "Duplicate", Arg: positions[i].TS.Format(time.RFC3339)}
+		}
+	}
+
+	return transaction(func(tx *Tx) error {
+		agent, err := agentByUUID(tx.DB, uUID)
+		if (err != nil) && (agent == Agent{}) {
+			if agent, err = tx.createAgent(uUID); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if len(positions) == 0 {
+			return nil
+		}
+
+		// The timestamps recorded in the range of the import are
+		// compared here rather than bound in the query, as a day of
+		// fixes is more than the databases take as parameters.
+		var recorded []time.Time
+		err = tx.Model(&Position{}).
+			Where("agent_id = ? AND ts BETWEEN ? AND ?", agent.ID, positions[0].TS, positions[len(positions)-1].TS).
+			Pluck("ts", &recorded).Error
+		if err != nil {
+			return err
+		}
+		collisions := make(map[int64]bool, len(recorded))
+		for _, ts := range recorded {
+			collisions[ts.UnixNano()] = true
+		}
+		for i := range positions {
+			if collisions[positions[i].TS.UnixNano()] {
+				return &AgentError{What: "Position.TS", Type: "Already-Exists", Arg: positions[i].TS.Format(time.RFC3339)}
+			}
+		}
+
+		previous, hasPrevious := lastPositionBefore(tx.DB, agent.ID, positions[0].TS)
+		for i := range positions {
+			position := &positions[i]
+			position.ID = 0
+			position.AgentID = agent.ID
+			position.VehicleID = agentVehicleAt(tx.DB, agent.ID, position.TS)
+			if !position.SpeedReported && hasPrevious {
+				if dt := position.TS.Sub(previous.TS).Seconds(); dt > 0 {
+					position.Speed = geo.Distance(previous.Point(), position.Point()) / dt * 3.6
+				}
+			}
+			if err := tx.Create(position).Error; err != nil {
+				return err
+			}
+			previous, hasPrevious = *position, true
+		}
+		return nil
+	})
+}
+
+type byTS []Position
+
+func (p byTS) Len() int           { return len(p) }
+func (p byTS) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byTS) Less(i, j int) bool { return p[i].TS.Before(p[j].TS) }
@@ -0,0 +1,46 @@
+package repository
+
+// Roles of the users.
+const (
+	ROLE_ADMIN      = "admin"
+	ROLE_DISPATCHER = "dispatcher"
+	ROLE_VIEWER     = "viewer"
+)
+
+var ROLES []string = []string{ROLE_ADMIN, ROLE_DISPATCHER, ROLE_VIEWER}
+
+// Permissions required by the routes that need a token.
+const (
+	PERMISSION_READ            = "read"
+	PERMISSION_MANAGE_FLEET    = "manage-fleet"
+	PERMISSION_MANAGE_USERS    = "manage-users"
+	PERMISSION_MANAGE_WEBHOOKS = "manage-webhooks"
+)
+
+// ROLE_PERMISSIONS lists what each role permits. Viewers read, dispatchers
+// also manage the vehicles, groups, agents and geofences, and admins
+// manage everything, including users and webhooks.
+var ROLE_PERMISSIONS map[string][]string = map[string][]string{
+	ROLE_ADMIN:      {PERMISSION_READ, PERMISSION_MANAGE_FLEET, PERMISSION_MANAGE_USERS, PERMISSION_MANAGE_WEBHOOKS},
+	ROLE_DISPATCHER: {PERMISSION_READ, PERMISSION_MANAGE_FLEET},
+	ROLE_VIEWER:     {PERMISSION_READ},
+}
+
+// RolePermits reports whether role grants permission.
+func RolePermits(role string, permission string) bool {
+	return containsString(ROLE_PERMISSIONS[role], permission)
+}
+
+func validateRole(role string) error {
+	if !containsString(ROLES, role) {
+		return &UserError{What: "Role", Type: "Invalid", Arg: role}
+	}
+	return nil
+}
+
+// backfillRoles makes viewers of the users created before roles, once
+// when the role column is added. The admins are then created with the
+// createsuperuser command, and give the other users their roles.
+func backfillRoles() {
+	db.Model(&User{}).Where("role = ? OR role IS NULL", "").Update("role", ROLE_VIEWER)
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/google/uuid"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// Session is a sign-in of a user, on a device. Its token authenticates
+// the requests until it's revoked or expires, see config.SessionParams.
+type Session struct {
+	ID         uint       `json:"id"           gorm:"primary_key"`
+	CreatedAt  time.Time  `json:"issued_at"`
+	UpdatedAt  time.Time  `json:"-"`
+	UserID     uint       `json:"-"            gorm:"index"`
+	Token      string     `json:"-"            gorm:"unique_index"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+
+	// Current is set on the session of the request listing them.
+	Current bool `json:"current" gorm:"-"`
+}
+
+// expired reports whether the session is past its absolute or idle
+// timeout at now.
+func (s Session) expired(now time.Time) bool {
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return true
+	}
+	idle := config.C.Session.IdleTimeout
+	return idle > 0 && now.Sub(s.LastUsedAt) >= time.Duration(idle*float64(time.Second))
+}
+
+// NewSession signs the user in on a new session, leaving the other
+// sessions of the user signed in.
+func (u *User) NewSession(userAgent string, ip string) (Session, error) {
+	if db.NewRecord(u) {
+		return Session{}, &UserError{
+			What: "User",
+			Type: "User-Not-Persisted",
+			Arg:  u.Email,
+		}
+	}
+	token, err := uuid.NewRandom()
+	if err != nil {
+		return Session{}, err
+	}
+	session := newSession(u.ID, token.String(), time.Now().UTC())
+	session.UserAgent = userAgent
+	session.IP = ip
+	if err := db.Create(&session).Error; err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+func newSession(userID uint, token string, now time.Time) Session {
+	session := Session{UserID: userID, Token: token, LastUsedAt: now}
+	if absolute := config.C.Session.AbsoluteTimeout; absolute > 0 {
+		expiresAt := now.Add(time.Duration(absolute * float64(time.Second)))
+		session.ExpiresAt = &expiresAt
+	}
+	return session
+}
+
+// RenewToken issues a token in a new session, without the details of the
+// client.
+func (u *User) RenewToken() (string, error) {
+	session, err := u.NewSession("", "")
+	return session.Token, err
+}
+
+// Authenticate returns the user the token was issued to, and the session
+// it was issued in, which is marked as used now. Expired sessions are
+// deleted.
+func Authenticate(token string) (User, Session, error) {
+	var (
+		user    User
+		session Session
+	)
+	if token == "" {
+		return user, session, &UserError{What: "Token", Type: "Empty", Arg: ""}
+	}
+
+	db.Where(&Session{Token: token}).First(&session)
+	if session.ID == 0 {
+		return user, session, &UserError{What: "Session", Type: "Not-Found", Arg: ""}
+	}
+	return authenticate(session)
+}
+
+// AuthenticateSession returns the user of the session and the session,
+// which is marked as used now, as Authenticate does for its token. It
+// fails once the session has been signed out or has expired.
+func AuthenticateSession(sessionID uint) (User, Session, error) {
+	var session Session
+	db.First(&session, sessionID)
+	if session.ID == 0 {
+		return User{}, session, &UserError{What: "Session", Type: "Not-Found", Arg: strconv.Itoa(int(sessionID))}
+	}
+	return authenticate(session)
+}
+
+func authenticate(session Session) (User, Session, error) {
+	var user User
+	now := time.Now().UTC()
+	if session.expired(now) {
+		db.Delete(&session)
+		return user, Session{}, &UserError{What: "Session", Type: "Expired", Arg: strconv.Itoa(int(session.ID))}
+	}
+
+	db.Preload("Groups").First(&user, session.UserID)
+	if user.ID == 0 {
+		return user, Session{}, &UserError{What: "User", Type: "Not-Found", Arg: fmt.Sprintf("%d", session.UserID)}
+	}
+	db.Model(&session).UpdateColumn("last_used_at", now)
+	session.LastUsedAt = now
+	return user, session, nil
+}
+
+// GetUserSessions returns the sessions of the user that haven't expired,
+// latest first.
+func GetUserSessions(userID uint) []Session {
+	var sessions []Session
+	db.Where("user_id = ?", userID).Order("id desc").Find(&sessions)
+
+	now := time.Now().UTC()
+	current := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.expired(now) {
+			current = append(current, session)
+		}
+	}
+	return current
+}
+
+// DeleteSession signs the user out of the session.
+func DeleteSession(userID uint, sessionID uint) (Session, error) {
+	var session Session
+	db.Where("user_id = ?", userID).First(&session, sessionID)
+	if session.ID == 0 {
+		return session, &UserError{What: "Session", Type: "Not-Found", Arg: fmt.Sprintf("%d", sessionID)}
+	}
+	if err := db.Delete(&session).Error; err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// backfillSessions moves the tokens issued before sessions, which were
+// kept on the users, to sessions of their own.
+func backfillSessions() {
+	if !db.Dialect().HasColumn("users", "token") {
+		return
+	}
+	type issued struct {
+		ID    uint
+		Token string
+	}
+	var tokens []issued
+	db.Table("users").Select("id, token").Where("token IS NOT NULL AND token <> ''").Scan(&tokens)
+
+	now := time.Now().UTC()
+	for _, token := range tokens {
+		session := newSession(token.ID, token.Token, now)
+		db.Create(&session)
+	}
+	db.Table("users").Where("token IS NOT NULL AND token <> ''").UpdateColumn("token", "")
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+)
+
+// VehicleStats summarizes what a vehicle did during a time range.
+// Distances are in km, speeds in km/h and durations in seconds.
+type VehicleStats struct {
+	PlateID           string  `json:"plate_id"`
+	Distance          float64 `json:"distance"`
+	MaxSpeed          float64 `json:"max_speed"`
+	OverspeedCount    int     `json:"overspeed_count"`
+	OverspeedDuration float64 `json:"overspeed_duration"`
+	IdleCount         int     `json:"idle_count"`
+	IdleDuration      float64 `json:"idle_duration"`
+}
+
+// GetVehicleStats returns the stats of a vehicle between from and to.
+// Zero times leave that end of the range open.
+func GetVehicleStats(plateID string, from, to time.Time) (VehicleStats, error) {
+	stats := VehicleStats{PlateID: plateID}
+	vehicle, err := GetVehicleByPlateID(plateID)
+	if err != nil {
+		return stats, err
+	}
+
+	positions := GetVehiclePositions(vehicle.ID, from, to)
+	for i := range positions {
+		if positions[i].Speed > stats.MaxSpeed {
+			stats.MaxSpeed = positions[i].Speed
+		}
+		if i > 0 {
+			stats.Distance += geo.Distance(positions[i-1].Point(), positions[i].Point()) / 1000
+		}
+	}
+
+	for _, overspeed := range getOverspeeds([]uint{vehicle.ID}, from, to) {
+		stats.OverspeedCount++
+		stats.OverspeedDuration += overspeed.Duration
+	}
+
+	for _, idle := range getIdles(vehicle.ID, from, to) {
+		stats.IdleCount++
+		stats.IdleDuration += idle.Duration
+	}
+
+	return stats, nil
+}
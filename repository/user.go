@@ -3,9 +3,11 @@ package repository
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite" // Force sqlite dialect to load
 	"gopkg.in/hlandau/passlib.v1"
 )
@@ -18,7 +20,12 @@ type User struct {
 
 	Email    string `json:"email" gorm:"unique_index"`
 	Password string `json:"-"`
-	Token    string `json:"-"`
+
+	// Role is one of ROLES, see ROLE_PERMISSIONS.
+	Role string `json:"role"`
+
+	// Groups the user is restricted to, see VehicleFilter.
+	Groups []*Group `json:"groups" gorm:"many2many:user_group;"`
 }
 
 func (u *User) SetPassword(password string) error {
@@ -34,27 +41,10 @@ func (u *User) SetPassword(password string) error {
 	return nil
 }
 
-func (u *User) RenewToken() (string, error) {
-	if db.NewRecord(u) {
-		return "", &UserError{
-			What: "User",
-			Type: "User-Not-Persisted",
-			Arg:  u.Email,
-		}
-	}
-	uuidToken, err := uuid.NewRandom()
-	if err != nil {
-		return "", err
-	}
-	u.Token = uuidToken.String()
-	db.Save(&u)
-	return u.Token, nil
-}
-
 func GetAllUsers() []User {
 	var users []User
 
-	db.Find(&users)
+	db.Preload("Groups").Find(&users)
 
 	return users
 }
@@ -73,28 +63,22 @@ func (u *User) CheckPassword(password string) bool {
 }
 
 func CheckToken(token string) bool {
-	if token == "" {
-		// TODO(cad): Write a log about what happened here.
-		return false
-	}
-
-	var user User
-	db.Where(&User{Token: token}).First(&user)
-	if db.NewRecord(&user) {
-		return false
-	}
-
-	return true
+	_, _, err := Authenticate(token)
+	return err == nil
 }
 
 func GetUserByUUID(uUID string) (User, error) {
+	return userByUUID(db, uUID)
+}
+
+func userByUUID(q *gorm.DB, uUID string) (User, error) {
 	var user User
 	if uUID == "" {
 		return user, &UserError{What: "uUID", Type: "Empty", Arg: uUID}
 	}
 
-	db.Where(&User{UUID: uUID}).First(&user)
-	if db.NewRecord(&user) {
+	q.Preload("Groups").Where(&User{UUID: uUID}).First(&user)
+	if q.NewRecord(&user) {
 		return user, UserError{
 			What: "User",
 			Type: "Not-Found",
@@ -122,63 +106,145 @@ func GetUserByEmail(email string) (User, error) {
 }
 
 func GetUserByToken(token string) (User, error) {
-	var user User
-	if token == "" {
-		// TODO(cad): Write a log about what happened here.
-		return user, fmt.Errorf("token is expected to be not empty, but it's empty instead")
-	}
-
-	db.Where(&User{Token: token}).First(&user)
-	if db.NewRecord(&user) {
-		return user, fmt.Errorf("user is not found")
-	}
+	user, _, err := Authenticate(token)
+	return user, err
+}
 
-	return user, nil
+func CreateNewUser(email string, password string, role string) (User, error) {
+	return System.CreateNewUser(email, password, role)
 }
 
-func CreateNewUser(email string, password string) (User, error) {
+func (a Actor) CreateNewUser(email string, password string, role string) (User, error) {
 	var user User
 	if email == "" || password == "" {
 		return user, &UserError{What: "EmailOrPassword", Type: "Empty", Arg: ""}
 	}
+	if err := validateRole(role); err != nil {
+		return user, err
+	}
 
 	uUID, err := uuid.NewRandom()
 	user = User{
 		Email: email,
 		UUID:  uUID.String(),
+		Role:  role,
 	}
 	if err != nil {
 		return user, err
 	}
 	user.SetPassword(password)
-	db.Create(&user)
-	if db.NewRecord(&user) {
-		return user, UserError{
-			What: "User",
-			Type: "Can-Not-Create",
-			Arg:  email,
+	err = transaction(func(tx *Tx) error {
+		tx.Create(&user)
+		if tx.NewRecord(&user) {
+			return UserError{
+				What: "User",
+				Type: "Can-Not-Create",
+				Arg:  email,
+			}
 		}
-	}
-	return user, nil
+		return a.emitChange(tx, USER_CREATED, user.UUID, nil, user)
+	})
+	return user, err
 }
 
 func DeleteUserByUUID(uUID string) (User, error) {
-	user, err := GetUserByUUID(uUID)
+	return System.DeleteUserByUUID(uUID)
+}
 
-	if err != nil {
+func (a Actor) DeleteUserByUUID(uUID string) (User, error) {
+	var user User
+	err := transaction(func(tx *Tx) (err error) {
+		user, err = userByUUID(tx.DB, uUID)
+		if err != nil {
+			return err
+		}
+
+		if tx.NewRecord(user) {
+			return UserError{
+				What: "User",
+				Type: "Can-Not-Delete",
+				Arg:  uUID,
+			}
+		}
+
+		tx.Model(&user).Association("Groups").Clear()
+		tx.Where("user_id = ?", user.ID).Delete(&Session{})
+		tx.Unscoped().Delete(&user)
+		return a.emitChange(tx, USER_DELETED, user.UUID, user, nil)
+	})
+	return user, err
+}
+
+func SetUserGroups(uUID string, groupIDs []int) (User, error) {
+	return System.SetUserGroups(uUID, groupIDs)
+}
+
+// SetUserGroups restricts the user to the vehicles of the groups, or lifts
+// the restriction when groupIDs is empty.
+func (a Actor) SetUserGroups(uUID string, groupIDs []int) (User, error) {
+	var user User
+	err := transaction(func(tx *Tx) (err error) {
+		user, err = userByUUID(tx.DB, uUID)
+		if err != nil {
+			return err
+		}
+		before := user
+
+		groups := make([]*Group, 0)
+		for _, item := range groupIDs {
+			var group Group
+			tx.First(&group, item)
+			if group == (Group{}) {
+				return &UserError{What: "Group", Type: "Not-Found", Arg: strconv.Itoa(item)}
+			}
+			groups = append(groups, &group)
+		}
+
+		if err := tx.Model(&user).Association("Groups").Replace(groups).Error; err != nil {
+			return err
+		}
+		user.Groups = groups
+		return a.emitChange(tx, USER_GROUPS_SET, user.UUID, before, user)
+	})
+	return user, err
+}
+
+func SetUserRole(uUID string, role string) (User, error) {
+	return System.SetUserRole(uUID, role)
+}
+
+// SetUserRole changes what the user is permitted to do.
+func (a Actor) SetUserRole(uUID string, role string) (User, error) {
+	var user User
+	if err := validateRole(role); err != nil {
 		return user, err
 	}
+	err := transaction(func(tx *Tx) (err error) {
+		user, err = userByUUID(tx.DB, uUID)
+		if err != nil {
+			return err
+		}
+		before := user
 
-	if db.NewRecord(user) {
-		return user, UserError{
-			What: "User",
-			Type: "Can-Not-Delete",
-			Arg:  uUID,
+		if err := tx.Model(&user).Update("role", role).Error; err != nil {
+			return err
 		}
-	}
+		return a.emitChange(tx, USER_ROLE_SET, user.UUID, before, user)
+	})
+	return user, err
+}
 
-	db.Unscoped().Delete(&user)
-	return user, nil
+// VehicleFilter returns the filter of the vehicles the user may see: those
+// in the user's groups, or every vehicle for users without groups. The
+// groups are read from the join table so that users whose groups were
+// deleted see nothing rather than the whole fleet.
+func (u User) VehicleFilter() VehicleFilter {
+	var groupIDs []uint
+	db.Table("user_group").Where("user_id = ?", u.ID).Pluck("group_id", &groupIDs)
+	if len(groupIDs) == 0 {
+		return VehicleFilter{}
+	}
+	return VehicleFilter{GroupIDs: groupIDs}
 }
 
 type UserError struct {
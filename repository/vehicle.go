@@ -5,8 +5,8 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
-	//	"github.com/jinzhu/gorm"
 )
 
 const (
@@ -16,6 +16,22 @@ const (
 
 var VEHICLE_TYPES []string = []string{SCHOOL_BUS, SOLAR_CAR}
 
+const (
+	VEHICLE_UPDATE = "VEHICLE-UPDATE"
+	VEHICLE_DELETE = "VEHICLE-DELETE"
+)
+
+// vehicleUpdate emits the vehicle as it is now in tx after a change to
+// its agent or groups, and returns it.
+func (tx *Tx) vehicleUpdate(vehicleID uint) (Vehicle, error) {
+	var vehicle Vehicle
+	tx.Preload("Groups").Preload("Agent").First(&vehicle, vehicleID)
+	if vehicle.ID == 0 {
+		return vehicle, &VehicleError{What: "Vehicle.ID", Type: "Not-Found", Arg: fmt.Sprintf("%d", vehicleID)}
+	}
+	return vehicle, tx.emit(VEHICLE_UPDATE, vehicle.PlateID, vehicle)
+}
+
 type Vehicle struct {
 	ID        uint      `json:"-"           gorm:"primary_key"`
 	CreatedAt time.Time `json:"-"`
@@ -36,11 +52,15 @@ type Group struct {
 }
 
 func GetVehicleByPlateID(plateID string) (Vehicle, error) {
+	return vehicleByPlateID(db, plateID)
+}
+
+func vehicleByPlateID(q *gorm.DB, plateID string) (Vehicle, error) {
 	var vehicle Vehicle
 	if plateID == "" {
 		return vehicle, &VehicleError{What: "plateID", Type: "Empty", Arg: plateID}
 	}
-	db.Preload("Groups").Preload("Agent").Where(&Vehicle{PlateID: plateID}).First(&vehicle)
+	q.Preload("Groups").Preload("Agent").Where(&Vehicle{PlateID: plateID}).First(&vehicle)
 	if vehicle.ID != 0 {
 		return vehicle, nil
 	}
@@ -73,119 +93,135 @@ func GetAllVehicles() []Vehicle {
 	return vehicles
 }
 
+// FilterVehicles returns the vehicles of the given type, group and agent
+// state. Zero values don't filter.
 func FilterVehicles(vehicleType string, groupID uint, agentState string) []Vehicle {
-	var vehicles []Vehicle
-
-	q := db.Preload("Groups").Preload("Agent")
-	if groupID != *new(uint) {
-		q = q.Joins("JOIN vehicle_group ON vehicle_group.vehicle_id = vehicles.id")
-		q = q.Where("vehicle_group.group_id = ?", groupID)
-	}
-
-	if vehicleType != *new(string) {
-		q = q.Where("vehicles.type = ?", vehicleType)
+	filter := VehicleFilter{AgentState: agentState}
+	if vehicleType != "" {
+		filter.Types = []string{vehicleType}
 	}
-
-	if agentState != "" {
-		switch agentState {
-		case "ASSIGNED":
-			q = q.Where("vehicles.agent_id is not null")
-		case "UNASSIGNED":
-			q = q.Where("vehicles.agent_id is null")
-		default:
-		}
+	if groupID != 0 {
+		filter.GroupIDs = []uint{groupID}
 	}
-
-	q.Find(&vehicles)
-
-	return vehicles
+	return FilterVehiclesBy(filter)
 }
 
 func VehicleSetAgent(plateID, uUID string) error {
-	vehicle, err := GetVehicleByPlateID(plateID)
-	if err != nil {
-		return err
-	}
-	agent, err := GetAgentByUUID(uUID)
-	if err != nil {
-		return err
-	}
-	agentVehicle := agent.Vehicle()
-	if agentVehicle != nil {
-		agentVehicle.Agent = nil
-		db.Save(&agentVehicle)
-	}
-	vehicle.Agent = &agent
+	return System.VehicleSetAgent(plateID, uUID)
+}
 
-	db.Save(&vehicle)
-	return nil
+// VehicleSetAgent assigns the agent to the vehicle, taking it from the
+// vehicle it was assigned to if any.
+func (a Actor) VehicleSetAgent(plateID, uUID string) error {
+	return transaction(func(tx *Tx) error {
+		vehicle, err := vehicleByPlateID(tx.DB, plateID)
+		if err != nil {
+			return err
+		}
+		agent, err := agentByUUID(tx.DB, uUID)
+		if err != nil {
+			return err
+		}
+		agentVehicle := agent.Vehicle()
+		if agentVehicle != nil && agentVehicle.ID != vehicle.ID {
+			before := *agentVehicle
+			agentVehicle.AgentID = 0
+			agentVehicle.Agent = nil
+			tx.Save(agentVehicle)
+			after, err := tx.vehicleUpdate(agentVehicle.ID)
+			if err != nil {
+				return err
+			}
+			if err := a.emitChange(tx, VEHICLE_AGENT_UNSET, after.PlateID, before, after); err != nil {
+				return err
+			}
+		}
+		before := vehicle
+		vehicle.Agent = &agent
+
+		tx.Save(&vehicle)
+		startAssignment(tx.DB, vehicle.ID, agent.ID, time.Now())
+		after, err := tx.vehicleUpdate(vehicle.ID)
+		if err != nil {
+			return err
+		}
+		return a.emitChange(tx, VEHICLE_AGENT_SET, after.PlateID, before, after)
+	})
 }
 
 func VehicleUnsetAgent(plateID string) error {
-	vehicle, err := GetVehicleByPlateID(plateID)
-	if err != nil {
-		return err
-	}
+	return System.VehicleUnsetAgent(plateID)
+}
+
+func (a Actor) VehicleUnsetAgent(plateID string) error {
+	return transaction(func(tx *Tx) error {
+		vehicle, err := vehicleByPlateID(tx.DB, plateID)
+		if err != nil {
+			return err
+		}
+		before := vehicle
 
-	vehicle.AgentID = 0
-	vehicle.Agent = nil
+		vehicle.AgentID = 0
+		vehicle.Agent = nil
 
-	db.Save(&vehicle)
-	return nil
+		tx.Save(&vehicle)
+		endAssignment(tx.DB, vehicle.ID, time.Now())
+		after, err := tx.vehicleUpdate(vehicle.ID)
+		if err != nil {
+			return err
+		}
+		return a.emitChange(tx, VEHICLE_AGENT_UNSET, after.PlateID, before, after)
+	})
 }
 
 func SetVehicleGroups(plateID string, groupIDs []int) error {
-	vehicle, err := GetVehicleByPlateID(plateID)
-	if err != nil {
-		return err
-	}
+	return System.SetVehicleGroups(plateID, groupIDs)
+}
 
-	groups := make([]*Group, 0)
-	// Sanitize incoming
-	for _, item := range groupIDs {
-		var group Group
-		db.First(&group, item)
-		if group == (Group{}) {
-			return &VehicleError{What: "Group", Type: "Not-Found", Arg: strconv.Itoa(item)}
+func (a Actor) SetVehicleGroups(plateID string, groupIDs []int) error {
+	return transaction(func(tx *Tx) error {
+		vehicle, err := vehicleByPlateID(tx.DB, plateID)
+		if err != nil {
+			return err
 		}
-		groups = append(groups, &group)
-	}
-
-	// Set groups if not empty
-	if len(groups) > 0 {
-		db.Model(&vehicle).Association("Groups").Replace(groups)
-		if db.Error != nil {
-			return db.Error
+		before := vehicle
+
+		groups := make([]*Group, 0)
+		// Sanitize incoming
+		for _, item := range groupIDs {
+			var group Group
+			tx.First(&group, item)
+			if group == (Group{}) {
+				return &VehicleError{What: "Group", Type: "Not-Found", Arg: strconv.Itoa(item)}
+			}
+			groups = append(groups, &group)
 		}
-	} else {
-		db.Model(&vehicle).Association("Groups").Replace([]*Group{})
-		if db.Error != nil {
-			return db.Error
+
+		if err := tx.Model(&vehicle).Association("Groups").Replace(groups).Error; err != nil {
+			return err
 		}
-	}
 
-	db.Save(&vehicle)
-	if db.Error != nil {
-		return db.Error
-	}
+		if err := tx.Save(&vehicle).Error; err != nil {
+			return err
+		}
 
-	return nil
+		setMemberships(tx.DB, vehicle.ID, groups, time.Now())
+		after, err := tx.vehicleUpdate(vehicle.ID)
+		if err != nil {
+			return err
+		}
+		return a.emitChange(tx, VEHICLE_GROUPS_SET, after.PlateID, before, after)
+	})
 }
 
 func CreateVehicle(plateID string, agentUUID string, groupIDs []int, vehicleType string) error {
+	return System.CreateVehicle(plateID, agentUUID, groupIDs, vehicleType)
+}
+
+func (a Actor) CreateVehicle(plateID string, agentUUID string, groupIDs []int, vehicleType string) error {
 	if plateID == "" {
 		return &VehicleError{What: "plateID", Type: "Empty", Arg: plateID}
 	}
-	groups := make([]*Group, 0)
-	// Sanitize incoming
-	for _, item := range groupIDs {
-		var group Group
-		db.First(&group, item)
-		if group == (Group{}) {
-			return &VehicleError{What: "Group", Type: "Not-Found", Arg: strconv.Itoa(item)}
-		}
-		groups = append(groups, &group)
-	}
 
 	typeFound := false
 	for _, item := range VEHICLE_TYPES {
@@ -198,70 +234,125 @@ func CreateVehicle(plateID string, agentUUID string, groupIDs []int, vehicleType
 		return &VehicleError{What: "VehicleType", Type: "Not-Found", Arg: vehicleType}
 	}
 
-	// Create Vehicle
-	vehicle := Vehicle{
-		PlateID: plateID, // TODO(cad): sanitize `plateID`
-		Type:    vehicleType,
-	}
-	if agentUUID != "" {
-		var a Agent
-		db.Where(&Agent{UUID: agentUUID}).First(&a)
-		vehicle.Agent = &a
-		//vehicle.AgentID = a.ID
-	}
+	return transaction(func(tx *Tx) error {
+		groups := make([]*Group, 0)
+		// Sanitize incoming
+		for _, item := range groupIDs {
+			var group Group
+			tx.First(&group, item)
+			if group == (Group{}) {
+				return &VehicleError{What: "Group", Type: "Not-Found", Arg: strconv.Itoa(item)}
+			}
+			groups = append(groups, &group)
+		}
 
-	vehicle.Groups = groups
-	// Set groups if not empty
-	if len(groups) > 0 {
-		vehicle.Groups = groups
-	} else {
-		vehicle.Groups = nil
-	}
+		// Create Vehicle
+		vehicle := Vehicle{
+			PlateID: plateID, // TODO(cad): sanitize `plateID`
+			Type:    vehicleType,
+		}
+		if agentUUID != "" {
+			var agent Agent
+			tx.Where(&Agent{UUID: agentUUID}).First(&agent)
+			vehicle.Agent = &agent
+			//vehicle.AgentID = a.ID
+		}
 
-	db.Create(&vehicle) // TODO(cad): check here if vehicle
-	if db.NewRecord(&vehicle) == true {
-		return &VehicleError{What: "Vehicle.PlateID", Type: "Already-Exists", Arg: plateID}
-	}
-	// created suc⎈cessfully.
-	return nil
+		// Set groups if not empty
+		if len(groups) > 0 {
+			vehicle.Groups = groups
+		} else {
+			vehicle.Groups = nil
+		}
+
+		tx.Create(&vehicle)
+		if tx.NewRecord(&vehicle) {
+			return &VehicleError{What: "Vehicle.PlateID", Type: "Already-Exists", Arg: plateID}
+		}
+		if vehicle.Agent != nil && vehicle.Agent.ID != 0 {
+			startAssignment(tx.DB, vehicle.ID, vehicle.Agent.ID, vehicle.CreatedAt)
+		}
+		setMemberships(tx.DB, vehicle.ID, groups, vehicle.CreatedAt)
+		after, err := tx.vehicleUpdate(vehicle.ID)
+		if err != nil {
+			return err
+		}
+		// created suc⎈cessfully.
+		return a.emitChange(tx, VEHICLE_CREATED, after.PlateID, nil, after)
+	})
 }
 
 func DeleteVehicleByPlateID(plateID string) error {
-	vehicle, err := GetVehicleByPlateID(plateID)
-	if err != nil {
-		return err
-	}
+	return System.DeleteVehicleByPlateID(plateID)
+}
+
+func (a Actor) DeleteVehicleByPlateID(plateID string) error {
+	return transaction(func(tx *Tx) error {
+		vehicle, err := vehicleByPlateID(tx.DB, plateID)
+		if err != nil {
+			return err
+		}
 
-	db.Unscoped().Delete(&vehicle)
-	return nil
+		if err := retireVehicle(tx.DB, vehicle, time.Now()); err != nil {
+			return err
+		}
+		tx.Unscoped().Delete(&vehicle)
+		if err := tx.emit(VEHICLE_DELETE, vehicle.PlateID, vehicle); err != nil {
+			return err
+		}
+		return a.emitChange(tx, VEHICLE_DELETED, vehicle.PlateID, vehicle, nil)
+	})
 }
 
 func CreateNewGroup(name string) (uint, error) {
+	return System.CreateNewGroup(name)
+}
+
+func (a Actor) CreateNewGroup(name string) (uint, error) {
 	group := Group{Name: name}
-	db.Create(&group)
-	if db.NewRecord(&group) {
-		return group.ID, &VehicleError{
-			What: "Group.Name",
-			Type: "Unknown-Error",
-			Arg:  name,
+	err := transaction(func(tx *Tx) error {
+		tx.Create(&group)
+		if tx.NewRecord(&group) {
+			return &VehicleError{
+				What: "Group.Name",
+				Type: "Unknown-Error",
+				Arg:  name,
+			}
 		}
-	}
-	return group.ID, nil
+		return a.emitChange(tx, GROUP_CREATED, strconv.Itoa(int(group.ID)), nil, group)
+	})
+	return group.ID, err
 }
 
 func DeleteGroup(groupID uint) error {
-	var group Group
+	return System.DeleteGroup(groupID)
+}
 
-	db.First(&group, groupID)
-	if db.NewRecord(&group) {
-		return &VehicleError{
-			What: "Group.ID",
-			Type: "Unknown-Error",
-			Arg:  fmt.Sprintf("%d", groupID),
+// DeleteGroup deletes the group, taking its vehicles out of it.
+func (a Actor) DeleteGroup(groupID uint) error {
+	return transaction(func(tx *Tx) error {
+		var group Group
+
+		tx.First(&group, groupID)
+		if tx.NewRecord(&group) {
+			return &VehicleError{
+				What: "Group.ID",
+				Type: "Unknown-Error",
+				Arg:  fmt.Sprintf("%d", groupID),
+			}
 		}
-	}
-	db.Unscoped().Delete(&group)
-	return nil
+		var vehicleIDs []uint
+		tx.Table("vehicle_group").Where("group_id = ?", group.ID).Pluck("vehicle_id", &vehicleIDs)
+		tx.Unscoped().Delete(&group)
+		tx.Exec("DELETE FROM vehicle_group WHERE group_id = ?", group.ID)
+		endGroupMemberships(tx.DB, group.ID, time.Now())
+		for _, vehicleID := range vehicleIDs {
+			if _, err := tx.vehicleUpdate(vehicleID); err != nil {
+				return err
+			}
+		}
+		return a.emitChange(tx, GROUP_DELETED, strconv.Itoa(int(group.ID)), group, nil)
+	})
 }
 
 func GetAllGroups() []Group {
@@ -0,0 +1,343 @@
+package repository
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// States of webhook deliveries. Deliveries are retried while PENDING, are
+// IN-FLIGHT while an instance of the API attempts them, and are DEAD once
+// every attempt failed.
+const (
+	DELIVERY_PENDING   = "PENDING"
+	DELIVERY_IN_FLIGHT = "IN-FLIGHT"
+	DELIVERY_DELIVERED = "DELIVERED"
+	DELIVERY_DEAD      = "DEAD"
+)
+
+// WEBHOOK_CURSOR names the cursor of the events of the log recorded as
+// webhook deliveries.
+const WEBHOOK_CURSOR = "webhook"
+
+// WEBHOOK_KINDS lists the kinds of the events webhooks can subscribe to.
+var WEBHOOK_KINDS []string = append([]string{
+	NEW_AGENT,
+	GEOFENCE_ENTER, GEOFENCE_EXIT,
+	OVERSPEED_START, OVERSPEED_END,
+	IDLE_START, IDLE_END,
+}, CHANGE_KINDS...)
+
+// Webhook is a subscription of an HTTP endpoint to events of some kinds.
+// With PlateIDs or GroupIDs, only the events about the matching vehicles
+// are delivered.
+type Webhook struct {
+	ID        uint      `json:"id"         gorm:"primary_key"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+	URL       string    `json:"url"        gorm:"not null"`
+
+	// Secret signs the payloads, see the webhook package.
+	Secret string `json:"-"`
+
+	Kinds    []string `json:"kinds"      gorm:"-"`
+	PlateIDs []string `json:"plate_ids"  gorm:"-"`
+	GroupIDs []uint   `json:"group_ids"  gorm:"-"`
+	Selector string   `json:"-"`
+}
+
+type webhookSelector struct {
+	Kinds    []string `json:"kinds"`
+	PlateIDs []string `json:"plate_ids"`
+	GroupIDs []uint   `json:"group_ids"`
+}
+
+func (h *Webhook) BeforeSave() error {
+	j, err := json.Marshal(webhookSelector{Kinds: h.Kinds, PlateIDs: h.PlateIDs, GroupIDs: h.GroupIDs})
+	if err != nil {
+		return err
+	}
+	h.Selector = string(j)
+	return nil
+}
+
+func (h *Webhook) AfterFind() error {
+	if h.Selector == "" {
+		return nil
+	}
+	var selector webhookSelector
+	if err := json.Unmarshal([]byte(h.Selector), &selector); err != nil {
+		return err
+	}
+	h.Kinds, h.PlateIDs, h.GroupIDs = selector.Kinds, selector.PlateIDs, selector.GroupIDs
+	return nil
+}
+
+// FiltersVehicles reports whether the webhook is restricted to some
+// vehicles.
+func (h *Webhook) FiltersVehicles() bool {
+	return len(h.PlateIDs) > 0 || len(h.GroupIDs) > 0
+}
+
+// Match reports whether an event of kind is to be delivered to the
+// webhook. vehicle is the vehicle the event is about, nil for the events
+// about none, which webhooks filtering vehicles don't get.
+func (h *Webhook) Match(kind string, vehicle *Vehicle) bool {
+	if !containsString(h.Kinds, kind) {
+		return false
+	}
+	if !h.FiltersVehicles() {
+		return true
+	}
+	if vehicle == nil {
+		return false
+	}
+	return VehicleFilter{PlateIDs: h.PlateIDs, GroupIDs: h.GroupIDs}.Match(*vehicle)
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, and the log
+// of its attempts.
+type WebhookDelivery struct {
+	ID        uint      `json:"id"            gorm:"primary_key"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	WebhookID uint      `json:"webhook_id"    gorm:"index"`
+	Kind      string    `json:"kind"`
+	Key       string    `json:"key"`
+
+	// Body is the JSON payload posted.
+	Body string `json:"body"`
+
+	Status       string    `json:"status"        gorm:"index"`
+	Attempts     int       `json:"attempts"`
+	NextAttempt  time.Time `json:"next_attempt"`
+	ResponseCode int       `json:"response_code"`
+	LastError    string    `json:"last_error"`
+}
+
+func GetAllWebhooks() []Webhook {
+	var webhooks []Webhook
+
+	db.Find(&webhooks)
+
+	return webhooks
+}
+
+func GetWebhookByID(iD uint) (Webhook, error) {
+	var webhook Webhook
+	db.Where(&Webhook{ID: iD}).First(&webhook)
+	if webhook.ID != 0 {
+		return webhook, nil
+	}
+	return webhook, &WebhookError{What: "Webhook.ID", Type: "Not-Found", Arg: fmt.Sprintf("%d", iD)}
+}
+
+// CreateWebhook registers a webhook, with a random secret unless one is
+// given.
+func CreateWebhook(rawURL string, secret string, kinds []string, plateIDs []string, groupIDs []uint) (Webhook, error) {
+	var webhook Webhook
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook, &WebhookError{What: "url", Type: "Invalid", Arg: rawURL}
+	}
+
+	if len(kinds) == 0 {
+		return webhook, &WebhookError{What: "kinds", Type: "Empty", Arg: ""}
+	}
+	for _, kind := range kinds {
+		if !containsString(WEBHOOK_KINDS, kind) {
+			return webhook, &WebhookError{What: "EventKind", Type: "Not-Found", Arg: kind}
+		}
+	}
+
+	for _, groupID := range groupIDs {
+		if _, err := GetGroupByID(groupID); err != nil {
+			return webhook, &WebhookError{What: "Group", Type: "Not-Found", Arg: fmt.Sprintf("%d", groupID)}
+		}
+	}
+
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return webhook, &WebhookError{What: "Webhook.Secret", Type: "Can-Not-Create", Arg: err.Error()}
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	webhook = Webhook{
+		URL:      rawURL,
+		Secret:   secret,
+		Kinds:    kinds,
+		PlateIDs: plateIDs,
+		GroupIDs: groupIDs,
+	}
+	db.Create(&webhook)
+	if db.NewRecord(&webhook) {
+		return webhook, &WebhookError{What: "Webhook", Type: "Can-Not-Create", Arg: rawURL}
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes the webhook and its deliveries.
+func DeleteWebhook(iD uint) error {
+	webhook, err := GetWebhookByID(iD)
+	if err != nil {
+		return err
+	}
+
+	db.Where("webhook_id = ?", webhook.ID).Delete(WebhookDelivery{})
+	db.Unscoped().Delete(&webhook)
+	return nil
+}
+
+// errCursorMoved tells that another instance of the API recorded the
+// events first.
+var errCursorMoved = &WebhookError{What: "EventCursor", Type: "Moved", Arg: WEBHOOK_CURSOR}
+
+// RecordWebhookDeliveries queues the deliveries of up to limit events of
+// the log after the last one recorded, deliveries returning those of an
+// event. It returns the number of events recorded.
+//
+// The deliveries are built before the transaction, which creates them and
+// advances the cursor past the events only if it hasn't moved meanwhile,
+// so that every event is recorded once, even with several instances of
+// the API recording, without holding the transactions of the others
+// while the webhooks are matched. The events are read again from the
+// cursor when it has moved.
+func RecordWebhookDeliveries(limit int, deliveries func(e StoredEvent) []WebhookDelivery) (int, error) {
+	for {
+		recorded, err := recordWebhookDeliveries(limit, deliveries)
+		if err != errCursorMoved {
+			return recorded, err
+		}
+	}
+}
+
+func recordWebhookDeliveries(limit int, deliveries func(e StoredEvent) []WebhookDelivery) (int, error) {
+	var cursor EventCursor
+	if err := db.Where("name = ?", WEBHOOK_CURSOR).First(&cursor).Error; err != nil {
+		return 0, err
+	}
+	events := GetStoredEvents(cursor.EventID, WEBHOOK_KINDS, limit)
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now().UTC()
+	queued := make([]WebhookDelivery, 0)
+	for _, e := range events {
+		for _, delivery := range deliveries(e) {
+			delivery.Status = DELIVERY_PENDING
+			delivery.NextAttempt = now
+			queued = append(queued, delivery)
+		}
+	}
+
+	err := transaction(func(tx *Tx) error {
+		// The cursor is written first, to hold it until the commit.
+		q := tx.Exec("UPDATE event_cursors SET event_id = ? WHERE name = ? AND event_id = ?",
+			events[len(events)-1].ID, WEBHOOK_CURSOR, cursor.EventID)
+		if q.Error != nil {
+			return q.Error
+		}
+		if q.RowsAffected != 1 {
+			return errCursorMoved
+		}
+		for i := range queued {
+			if err := tx.Create(&queued[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+func SaveWebhookDelivery(delivery *WebhookDelivery) {
+	db.Save(delivery)
+}
+
+func GetWebhookDeliveryByID(iD uint) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	db.Where(&WebhookDelivery{ID: iD}).First(&delivery)
+	if delivery.ID != 0 {
+		return delivery, nil
+	}
+	return delivery, &WebhookError{What: "WebhookDelivery.ID", Type: "Not-Found", Arg: fmt.Sprintf("%d", iD)}
+}
+
+// ClaimWebhookDeliveries claims up to limit deliveries to attempt as of
+// now, oldest first. Claimed deliveries are IN-FLIGHT until they're saved
+// after the attempt, or until the lease is over, when the attempt was cut
+// short. Every delivery is claimed with a conditional update, so that a
+// single instance of the API attempts it.
+func ClaimWebhookDeliveries(now time.Time, lease time.Duration, limit int) []WebhookDelivery {
+	due := make([]WebhookDelivery, 0)
+	states := []string{DELIVERY_PENDING, DELIVERY_IN_FLIGHT}
+	db.Where("status IN (?) AND next_attempt <= ?", states, now).Order("id").Limit(limit).Find(&due)
+
+	claimed := make([]WebhookDelivery, 0, len(due))
+	for _, delivery := range due {
+		delivery.Status = DELIVERY_IN_FLIGHT
+		delivery.NextAttempt = now.Add(lease)
+		q := db.Model(&WebhookDelivery{}).
+			Where("id = ? AND status IN (?) AND next_attempt <= ?", delivery.ID, states, now).
+			Updates(map[string]interface{}{"status": delivery.Status, "next_attempt": delivery.NextAttempt})
+		if q.Error == nil && q.RowsAffected == 1 {
+			claimed = append(claimed, delivery)
+		}
+	}
+	return claimed
+}
+
+// GetWebhookDeliveries returns up to limit deliveries, latest first, of
+// the webhook, or of every webhook when webhookID is 0, and in status
+// unless it's empty.
+func GetWebhookDeliveries(webhookID uint, status string, limit int) []WebhookDelivery {
+	deliveries := make([]WebhookDelivery, 0)
+	q := db.Order("id desc").Limit(limit)
+	if webhookID != 0 {
+		q = q.Where("webhook_id = ?", webhookID)
+	}
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	q.Find(&deliveries)
+	return deliveries
+}
+
+// RetryWebhookDelivery queues a dead delivery again, with all its
+// attempts.
+func RetryWebhookDelivery(iD uint) (WebhookDelivery, error) {
+	delivery, err := GetWebhookDeliveryByID(iD)
+	if err != nil {
+		return delivery, err
+	}
+	if delivery.Status != DELIVERY_DEAD {
+		return delivery, &WebhookError{What: "WebhookDelivery.Status", Type: "Not-Dead", Arg: delivery.Status}
+	}
+
+	delivery.Status = DELIVERY_PENDING
+	delivery.Attempts = 0
+	delivery.NextAttempt = time.Now().UTC()
+	db.Save(&delivery)
+	return delivery, nil
+}
+
+type WebhookError struct {
+	What string
+	Type string
+	Arg  string
+}
+
+func (e WebhookError) Error() string {
+	return fmt.Sprintf("%s: <%s> %s", e.Type, e.What, e.Arg)
+}
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/repository"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (f *VehicleFilter) filter() repository.VehicleFilter {
+	filter := repository.VehicleFilter{
+		Types:      f.VehicleType,
+		PlateIDs:   f.PlateId,
+		AgentState: f.AgentState,
+	}
+	for _, groupID := range f.VehicleGroupId {
+		filter.GroupIDs = append(filter.GroupIDs, uint(groupID))
+	}
+	return filter
+}
+
+func vehicleMessage(vehicle repository.Vehicle) *Vehicle {
+	message := &Vehicle{
+		PlateId:   vehicle.PlateID,
+		Type:      vehicle.Type,
+		UpdatedAt: timestamp(vehicle.UpdatedAt),
+	}
+	if vehicle.Agent != nil {
+		message.Agent = agentMessage(*vehicle.Agent)
+	}
+	for _, group := range vehicle.Groups {
+		message.Groups = append(message.Groups, groupMessage(*group))
+	}
+	return message
+}
+
+func groupMessage(group repository.Group) *Group {
+	return &Group{Id: uint32(group.ID), Name: group.Name}
+}
+
+func agentMessage(agent repository.Agent) *Agent {
+	return &Agent{
+		Uuid:      agent.UUID,
+		Label:     agent.Label,
+		Lat:       agent.Lat,
+		Lon:       agent.Lon,
+		GpsTs:     agent.TS,
+		Place:     agent.Place,
+		UpdatedAt: timestamp(agent.UpdatedAt),
+	}
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,248 @@
+// Package rpc serves the Tracker gRPC API, defined in tracker.proto.
+//
+// It can listen on a port of its own or share that of the REST API, the
+// requests being told apart by their content type.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative tracker.proto
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the Tracker service.
+type Server struct {
+	UnimplementedTrackerServer
+
+	grpc *grpc.Server
+
+	// stopping is closed on Shutdown, ending the watch streams.
+	stopping chan struct{}
+}
+
+func NewServer() *Server {
+	s := &Server{
+		grpc:     grpc.NewServer(),
+		stopping: make(chan struct{}),
+	}
+	RegisterTrackerServer(s.grpc, s)
+	return s
+}
+
+// Serve accepts the gRPC connections on l until Shutdown.
+func (s *Server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+// Handler serves the gRPC requests, over HTTP/2 without TLS, and the
+// others with h.
+func (s *Server) Handler(h http.Handler) http.Handler {
+	return h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor == 2 && strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc") {
+			s.grpc.ServeHTTP(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	}), &http2.Server{})
+}
+
+// Shutdown ends the watch streams and waits for the other calls in
+// progress to finish, or ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) {
+	close(s.stopping)
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// authenticate returns the user whose token is in the authorization
+// metadata of the call and the session the token was issued in, nil
+// without one.
+func authenticate(ctx context.Context) (*repository.User, repository.Session, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, repository.Session{}, nil
+	}
+	fields := strings.Fields(values[0])
+	if len(fields) != 2 || fields[0] != "Bearer" {
+		return nil, repository.Session{}, status.Error(codes.Unauthenticated, "Not Authorized")
+	}
+	user, session, err := repository.Authenticate(fields[1])
+	if err != nil {
+		return nil, session, status.Error(codes.Unauthenticated, "Not Authorized")
+	}
+	return &user, session, nil
+}
+
+// authorize authenticates the caller and checks that the role of its user
+// grants permission, as the REST routes do. Callers without token are
+// anonymous, with a nil user, when they read and the server allows
+// anonymous reads.
+func authorize(ctx context.Context, permission string) (*repository.User, error) {
+	user, _, err := authorizeSession(ctx, permission)
+	return user, err
+}
+
+// authorizeSession authorizes the caller as authorize does, and returns
+// the session of its token too.
+func authorizeSession(ctx context.Context, permission string) (*repository.User, repository.Session, error) {
+	user, session, err := authenticate(ctx)
+	if err != nil {
+		return nil, session, err
+	}
+	if user == nil {
+		if permission == repository.PERMISSION_READ && config.C.Server.AnonymousRead {
+			return nil, session, nil
+		}
+		return nil, session, status.Error(codes.Unauthenticated, "Not Authorized")
+	}
+	if err := permits(user, permission); err != nil {
+		return nil, session, err
+	}
+	return user, session, nil
+}
+
+// permits checks that the role of the user grants permission.
+func permits(user *repository.User, permission string) error {
+	if !repository.RolePermits(user.Role, permission) {
+		return status.Errorf(codes.PermissionDenied, "Forbidden: the %s role doesn't have the %s permission", user.Role, permission)
+	}
+	return nil
+}
+
+// restriction authorizes the caller to read, and returns the vehicles it
+// can see, those of the groups of its user if any.
+func restriction(ctx context.Context) (repository.VehicleFilter, error) {
+	user, err := authorize(ctx, repository.PERMISSION_READ)
+	if err != nil || user == nil {
+		return repository.VehicleFilter{}, err
+	}
+	return user.VehicleFilter(), nil
+}
+
+func (s *Server) ListVehicles(ctx context.Context, filter *VehicleFilter) (*Vehicles, error) {
+	restriction, err := restriction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vehicles := &Vehicles{}
+	for _, vehicle := range repository.FilterVehiclesBy(filter.filter()) {
+		if restriction.Match(vehicle) {
+			vehicles.Vehicles = append(vehicles.Vehicles, vehicleMessage(vehicle))
+		}
+	}
+	return vehicles, nil
+}
+
+func (s *Server) GetVehicle(ctx context.Context, req *GetVehicleRequest) (*Vehicle, error) {
+	restriction, err := restriction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vehicle, err := repository.GetVehicleByPlateID(req.PlateId)
+	if err == nil && !restriction.Match(vehicle) {
+		err = &repository.VehicleError{What: "Vehicle", Type: "Not-Found", Arg: req.PlateId}
+	}
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return vehicleMessage(vehicle), nil
+}
+
+func (s *Server) ListGroups(ctx context.Context, req *ListGroupsRequest) (*Groups, error) {
+	if _, err := authorize(ctx, repository.PERMISSION_READ); err != nil {
+		return nil, err
+	}
+	groups := &Groups{}
+	for _, group := range repository.GetAllGroups() {
+		groups.Groups = append(groups.Groups, groupMessage(group))
+	}
+	return groups, nil
+}
+
+func (s *Server) GetGroup(ctx context.Context, req *GetGroupRequest) (*Group, error) {
+	if _, err := authorize(ctx, repository.PERMISSION_READ); err != nil {
+		return nil, err
+	}
+	group, err := repository.GetGroupByID(uint(req.Id))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return groupMessage(group), nil
+}
+
+func (s *Server) ListAgents(ctx context.Context, req *ListAgentsRequest) (*Agents, error) {
+	restriction, err := restriction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agents := &Agents{}
+	for _, agent := range repository.RestrictAgents(repository.FilterAgents(req.AgentState), restriction) {
+		agents.Agents = append(agents.Agents, agentMessage(agent))
+	}
+	return agents, nil
+}
+
+func (s *Server) GetAgent(ctx context.Context, req *GetAgentRequest) (*Agent, error) {
+	restriction, err := restriction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	agent, err := repository.GetAgentByUUID(req.Uuid)
+	if err == nil && !restriction.MatchAgent(agent) {
+		err = repository.AgentError{What: "Agent", Type: "Not-Found", Arg: req.Uuid}
+	}
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return agentMessage(agent), nil
+}
+
+// SyncAgent needs a user managing the fleet, unlike the REST route the
+// agents post their positions to.
+func (s *Server) SyncAgent(stream Tracker_SyncAgentServer) error {
+	if _, err := authorize(stream.Context(), repository.PERMISSION_MANAGE_FLEET); err != nil {
+		return err
+	}
+	summary := &SyncAgentSummary{}
+	for {
+		position, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(summary)
+		}
+		if err != nil {
+			return err
+		}
+		if position.Uuid == "" {
+			summary.Rejected++
+			continue
+		}
+		err = repository.SyncAgentByUUID(position.Uuid, position.Lat, position.Lon, position.GpsTs, position.Speed)
+		if err != nil {
+			summary.Rejected++
+			continue
+		}
+		summary.Accepted++
+	}
+}
@@ -0,0 +1,1080 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: tracker.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// VehicleFilter selects vehicles with the semantics of FilterVehicles.
+type VehicleFilter struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	VehicleType    []string               `protobuf:"bytes,1,rep,name=vehicle_type,json=vehicleType,proto3" json:"vehicle_type,omitempty"`
+	VehicleGroupId []uint32               `protobuf:"varint,2,rep,packed,name=vehicle_group_id,json=vehicleGroupId,proto3" json:"vehicle_group_id,omitempty"`
+	PlateId        []string               `protobuf:"bytes,3,rep,name=plate_id,json=plateId,proto3" json:"plate_id,omitempty"`
+	// ASSIGNED or UNASSIGNED
+	AgentState    string `protobuf:"bytes,4,opt,name=agent_state,json=agentState,proto3" json:"agent_state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VehicleFilter) Reset() {
+	*x = VehicleFilter{}
+	mi := &file_tracker_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VehicleFilter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VehicleFilter) ProtoMessage() {}
+
+func (x *VehicleFilter) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VehicleFilter.ProtoReflect.Descriptor instead.
+func (*VehicleFilter) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *VehicleFilter) GetVehicleType() []string {
+	if x != nil {
+		return x.VehicleType
+	}
+	return nil
+}
+
+func (x *VehicleFilter) GetVehicleGroupId() []uint32 {
+	if x != nil {
+		return x.VehicleGroupId
+	}
+	return nil
+}
+
+func (x *VehicleFilter) GetPlateId() []string {
+	if x != nil {
+		return x.PlateId
+	}
+	return nil
+}
+
+func (x *VehicleFilter) GetAgentState() string {
+	if x != nil {
+		return x.AgentState
+	}
+	return ""
+}
+
+type Vehicle struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PlateId       string                 `protobuf:"bytes,1,opt,name=plate_id,json=plateId,proto3" json:"plate_id,omitempty"`
+	Type          string                 `protobuf:"bytes,2,opt,name=type,proto3" json:"type,omitempty"`
+	Agent         *Agent                 `protobuf:"bytes,3,opt,name=agent,proto3" json:"agent,omitempty"`
+	Groups        []*Group               `protobuf:"bytes,4,rep,name=groups,proto3" json:"groups,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Vehicle) Reset() {
+	*x = Vehicle{}
+	mi := &file_tracker_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Vehicle) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Vehicle) ProtoMessage() {}
+
+func (x *Vehicle) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Vehicle.ProtoReflect.Descriptor instead.
+func (*Vehicle) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Vehicle) GetPlateId() string {
+	if x != nil {
+		return x.PlateId
+	}
+	return ""
+}
+
+func (x *Vehicle) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Vehicle) GetAgent() *Agent {
+	if x != nil {
+		return x.Agent
+	}
+	return nil
+}
+
+func (x *Vehicle) GetGroups() []*Group {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+func (x *Vehicle) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type Vehicles struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Vehicles      []*Vehicle             `protobuf:"bytes,1,rep,name=vehicles,proto3" json:"vehicles,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Vehicles) Reset() {
+	*x = Vehicles{}
+	mi := &file_tracker_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Vehicles) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Vehicles) ProtoMessage() {}
+
+func (x *Vehicles) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Vehicles.ProtoReflect.Descriptor instead.
+func (*Vehicles) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Vehicles) GetVehicles() []*Vehicle {
+	if x != nil {
+		return x.Vehicles
+	}
+	return nil
+}
+
+type GetVehicleRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PlateId       string                 `protobuf:"bytes,1,opt,name=plate_id,json=plateId,proto3" json:"plate_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetVehicleRequest) Reset() {
+	*x = GetVehicleRequest{}
+	mi := &file_tracker_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetVehicleRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetVehicleRequest) ProtoMessage() {}
+
+func (x *GetVehicleRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetVehicleRequest.ProtoReflect.Descriptor instead.
+func (*GetVehicleRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetVehicleRequest) GetPlateId() string {
+	if x != nil {
+		return x.PlateId
+	}
+	return ""
+}
+
+type Group struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Group) Reset() {
+	*x = Group{}
+	mi := &file_tracker_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Group) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Group) ProtoMessage() {}
+
+func (x *Group) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Group.ProtoReflect.Descriptor instead.
+func (*Group) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Group) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Group) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+type Groups struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Groups        []*Group               `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Groups) Reset() {
+	*x = Groups{}
+	mi := &file_tracker_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Groups) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Groups) ProtoMessage() {}
+
+func (x *Groups) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Groups.ProtoReflect.Descriptor instead.
+func (*Groups) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Groups) GetGroups() []*Group {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+type ListGroupsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListGroupsRequest) Reset() {
+	*x = ListGroupsRequest{}
+	mi := &file_tracker_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListGroupsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListGroupsRequest) ProtoMessage() {}
+
+func (x *ListGroupsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListGroupsRequest.ProtoReflect.Descriptor instead.
+func (*ListGroupsRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{6}
+}
+
+type GetGroupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint32                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetGroupRequest) Reset() {
+	*x = GetGroupRequest{}
+	mi := &file_tracker_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetGroupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetGroupRequest) ProtoMessage() {}
+
+func (x *GetGroupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetGroupRequest.ProtoReflect.Descriptor instead.
+func (*GetGroupRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetGroupRequest) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type Agent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Label         string                 `protobuf:"bytes,2,opt,name=label,proto3" json:"label,omitempty"`
+	Lat           string                 `protobuf:"bytes,3,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           string                 `protobuf:"bytes,4,opt,name=lon,proto3" json:"lon,omitempty"`
+	GpsTs         string                 `protobuf:"bytes,5,opt,name=gps_ts,json=gpsTs,proto3" json:"gps_ts,omitempty"`
+	Place         string                 `protobuf:"bytes,6,opt,name=place,proto3" json:"place,omitempty"`
+	UpdatedAt     *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Agent) Reset() {
+	*x = Agent{}
+	mi := &file_tracker_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Agent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Agent) ProtoMessage() {}
+
+func (x *Agent) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Agent.ProtoReflect.Descriptor instead.
+func (*Agent) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Agent) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *Agent) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Agent) GetLat() string {
+	if x != nil {
+		return x.Lat
+	}
+	return ""
+}
+
+func (x *Agent) GetLon() string {
+	if x != nil {
+		return x.Lon
+	}
+	return ""
+}
+
+func (x *Agent) GetGpsTs() string {
+	if x != nil {
+		return x.GpsTs
+	}
+	return ""
+}
+
+func (x *Agent) GetPlace() string {
+	if x != nil {
+		return x.Place
+	}
+	return ""
+}
+
+func (x *Agent) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type Agents struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Agents        []*Agent               `protobuf:"bytes,1,rep,name=agents,proto3" json:"agents,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Agents) Reset() {
+	*x = Agents{}
+	mi := &file_tracker_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Agents) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Agents) ProtoMessage() {}
+
+func (x *Agents) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Agents.ProtoReflect.Descriptor instead.
+func (*Agents) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Agents) GetAgents() []*Agent {
+	if x != nil {
+		return x.Agents
+	}
+	return nil
+}
+
+type ListAgentsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ASSIGNED or UNASSIGNED
+	AgentState    string `protobuf:"bytes,1,opt,name=agent_state,json=agentState,proto3" json:"agent_state,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListAgentsRequest) Reset() {
+	*x = ListAgentsRequest{}
+	mi := &file_tracker_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListAgentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListAgentsRequest) ProtoMessage() {}
+
+func (x *ListAgentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListAgentsRequest.ProtoReflect.Descriptor instead.
+func (*ListAgentsRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListAgentsRequest) GetAgentState() string {
+	if x != nil {
+		return x.AgentState
+	}
+	return ""
+}
+
+type GetAgentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetAgentRequest) Reset() {
+	*x = GetAgentRequest{}
+	mi := &file_tracker_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetAgentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetAgentRequest) ProtoMessage() {}
+
+func (x *GetAgentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetAgentRequest.ProtoReflect.Descriptor instead.
+func (*GetAgentRequest) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *GetAgentRequest) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+// AgentPosition is a fix of an agent, in the format of GPSData.
+type AgentPosition struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Uuid          string                 `protobuf:"bytes,1,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Lat           string                 `protobuf:"bytes,2,opt,name=lat,proto3" json:"lat,omitempty"`
+	Lon           string                 `protobuf:"bytes,3,opt,name=lon,proto3" json:"lon,omitempty"`
+	GpsTs         string                 `protobuf:"bytes,4,opt,name=gps_ts,json=gpsTs,proto3" json:"gps_ts,omitempty"`
+	Speed         string                 `protobuf:"bytes,5,opt,name=speed,proto3" json:"speed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AgentPosition) Reset() {
+	*x = AgentPosition{}
+	mi := &file_tracker_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AgentPosition) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AgentPosition) ProtoMessage() {}
+
+func (x *AgentPosition) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AgentPosition.ProtoReflect.Descriptor instead.
+func (*AgentPosition) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *AgentPosition) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *AgentPosition) GetLat() string {
+	if x != nil {
+		return x.Lat
+	}
+	return ""
+}
+
+func (x *AgentPosition) GetLon() string {
+	if x != nil {
+		return x.Lon
+	}
+	return ""
+}
+
+func (x *AgentPosition) GetGpsTs() string {
+	if x != nil {
+		return x.GpsTs
+	}
+	return ""
+}
+
+func (x *AgentPosition) GetSpeed() string {
+	if x != nil {
+		return x.Speed
+	}
+	return ""
+}
+
+type SyncAgentSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Accepted      uint32                 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	Rejected      uint32                 `protobuf:"varint,2,opt,name=rejected,proto3" json:"rejected,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncAgentSummary) Reset() {
+	*x = SyncAgentSummary{}
+	mi := &file_tracker_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncAgentSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncAgentSummary) ProtoMessage() {}
+
+func (x *SyncAgentSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncAgentSummary.ProtoReflect.Descriptor instead.
+func (*SyncAgentSummary) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SyncAgentSummary) GetAccepted() uint32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *SyncAgentSummary) GetRejected() uint32 {
+	if x != nil {
+		return x.Rejected
+	}
+	return 0
+}
+
+type VehicleRemoval struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	PlateId       string                 `protobuf:"bytes,1,opt,name=plate_id,json=plateId,proto3" json:"plate_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VehicleRemoval) Reset() {
+	*x = VehicleRemoval{}
+	mi := &file_tracker_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VehicleRemoval) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VehicleRemoval) ProtoMessage() {}
+
+func (x *VehicleRemoval) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VehicleRemoval.ProtoReflect.Descriptor instead.
+func (*VehicleRemoval) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *VehicleRemoval) GetPlateId() string {
+	if x != nil {
+		return x.PlateId
+	}
+	return ""
+}
+
+type VehicleUpdate struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Update:
+	//
+	//	*VehicleUpdate_Vehicle
+	//	*VehicleUpdate_Removal
+	Update        isVehicleUpdate_Update `protobuf_oneof:"update"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VehicleUpdate) Reset() {
+	*x = VehicleUpdate{}
+	mi := &file_tracker_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VehicleUpdate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VehicleUpdate) ProtoMessage() {}
+
+func (x *VehicleUpdate) ProtoReflect() protoreflect.Message {
+	mi := &file_tracker_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VehicleUpdate.ProtoReflect.Descriptor instead.
+func (*VehicleUpdate) Descriptor() ([]byte, []int) {
+	return file_tracker_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *VehicleUpdate) GetUpdate() isVehicleUpdate_Update {
+	if x != nil {
+		return x.Update
+	}
+	return nil
+}
+
+func (x *VehicleUpdate) GetVehicle() *Vehicle {
+	if x != nil {
+		if x, ok := x.Update.(*VehicleUpdate_Vehicle); ok {
+			return x.Vehicle
+		}
+	}
+	return nil
+}
+
+func (x *VehicleUpdate) GetRemoval() *VehicleRemoval {
+	if x != nil {
+		if x, ok := x.Update.(*VehicleUpdate_Removal); ok {
+			return x.Removal
+		}
+	}
+	return nil
+}
+
+type isVehicleUpdate_Update interface {
+	isVehicleUpdate_Update()
+}
+
+type VehicleUpdate_Vehicle struct {
+	Vehicle *Vehicle `protobuf:"bytes,1,opt,name=vehicle,proto3,oneof"`
+}
+
+type VehicleUpdate_Removal struct {
+	Removal *VehicleRemoval `protobuf:"bytes,2,opt,name=removal,proto3,oneof"`
+}
+
+func (*VehicleUpdate_Vehicle) isVehicleUpdate_Update() {}
+
+func (*VehicleUpdate_Removal) isVehicleUpdate_Update() {}
+
+var File_tracker_proto protoreflect.FileDescriptor
+
+const file_tracker_proto_rawDesc = "" +
+	"\n" +
+	"\rtracker.proto\x12\x0evehicletracker\x1a\x1fgoogle/protobuf/timestamp.proto\"\x98\x01\n" +
+	"\rVehicleFilter\x12!\n" +
+	"\fvehicle_type\x18\x01 \x03(\tR\vvehicleType\x12(\n" +
+	"\x10vehicle_group_id\x18\x02 \x03(\rR\x0evehicleGroupId\x12\x19\n" +
+	"\bplate_id\x18\x03 \x03(\tR\aplateId\x12\x1f\n" +
+	"\vagent_state\x18\x04 \x01(\tR\n" +
+	"agentState\"\xcf\x01\n" +
+	"\aVehicle\x12\x19\n" +
+	"\bplate_id\x18\x01 \x01(\tR\aplateId\x12\x12\n" +
+	"\x04type\x18\x02 \x01(\tR\x04type\x12+\n" +
+	"\x05agent\x18\x03 \x01(\v2\x15.vehicletracker.AgentR\x05agent\x12-\n" +
+	"\x06groups\x18\x04 \x03(\v2\x15.vehicletracker.GroupR\x06groups\x129\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\"?\n" +
+	"\bVehicles\x123\n" +
+	"\bvehicles\x18\x01 \x03(\v2\x17.vehicletracker.VehicleR\bvehicles\".\n" +
+	"\x11GetVehicleRequest\x12\x19\n" +
+	"\bplate_id\x18\x01 \x01(\tR\aplateId\"+\n" +
+	"\x05Group\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\rR\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\"7\n" +
+	"\x06Groups\x12-\n" +
+	"\x06groups\x18\x01 \x03(\v2\x15.vehicletracker.GroupR\x06groups\"\x13\n" +
+	"\x11ListGroupsRequest\"!\n" +
+	"\x0fGetGroupRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\rR\x02id\"\xbd\x01\n" +
+	"\x05Agent\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\x12\x14\n" +
+	"\x05label\x18\x02 \x01(\tR\x05label\x12\x10\n" +
+	"\x03lat\x18\x03 \x01(\tR\x03lat\x12\x10\n" +
+	"\x03lon\x18\x04 \x01(\tR\x03lon\x12\x15\n" +
+	"\x06gps_ts\x18\x05 \x01(\tR\x05gpsTs\x12\x14\n" +
+	"\x05place\x18\x06 \x01(\tR\x05place\x129\n" +
+	"\n" +
+	"updated_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\"7\n" +
+	"\x06Agents\x12-\n" +
+	"\x06agents\x18\x01 \x03(\v2\x15.vehicletracker.AgentR\x06agents\"4\n" +
+	"\x11ListAgentsRequest\x12\x1f\n" +
+	"\vagent_state\x18\x01 \x01(\tR\n" +
+	"agentState\"%\n" +
+	"\x0fGetAgentRequest\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\"t\n" +
+	"\rAgentPosition\x12\x12\n" +
+	"\x04uuid\x18\x01 \x01(\tR\x04uuid\x12\x10\n" +
+	"\x03lat\x18\x02 \x01(\tR\x03lat\x12\x10\n" +
+	"\x03lon\x18\x03 \x01(\tR\x03lon\x12\x15\n" +
+	"\x06gps_ts\x18\x04 \x01(\tR\x05gpsTs\x12\x14\n" +
+	"\x05speed\x18\x05 \x01(\tR\x05speed\"J\n" +
+	"\x10SyncAgentSummary\x12\x1a\n" +
+	"\baccepted\x18\x01 \x01(\rR\baccepted\x12\x1a\n" +
+	"\brejected\x18\x02 \x01(\rR\brejected\"+\n" +
+	"\x0eVehicleRemoval\x12\x19\n" +
+	"\bplate_id\x18\x01 \x01(\tR\aplateId\"\x8a\x01\n" +
+	"\rVehicleUpdate\x123\n" +
+	"\avehicle\x18\x01 \x01(\v2\x17.vehicletracker.VehicleH\x00R\avehicle\x12:\n" +
+	"\aremoval\x18\x02 \x01(\v2\x1e.vehicletracker.VehicleRemovalH\x00R\aremovalB\b\n" +
+	"\x06update2\xd7\x04\n" +
+	"\aTracker\x12G\n" +
+	"\fListVehicles\x12\x1d.vehicletracker.VehicleFilter\x1a\x18.vehicletracker.Vehicles\x12H\n" +
+	"\n" +
+	"GetVehicle\x12!.vehicletracker.GetVehicleRequest\x1a\x17.vehicletracker.Vehicle\x12G\n" +
+	"\n" +
+	"ListGroups\x12!.vehicletracker.ListGroupsRequest\x1a\x16.vehicletracker.Groups\x12B\n" +
+	"\bGetGroup\x12\x1f.vehicletracker.GetGroupRequest\x1a\x15.vehicletracker.Group\x12G\n" +
+	"\n" +
+	"ListAgents\x12!.vehicletracker.ListAgentsRequest\x1a\x16.vehicletracker.Agents\x12B\n" +
+	"\bGetAgent\x12\x1f.vehicletracker.GetAgentRequest\x1a\x15.vehicletracker.Agent\x12N\n" +
+	"\tSyncAgent\x12\x1d.vehicletracker.AgentPosition\x1a .vehicletracker.SyncAgentSummary(\x01\x12O\n" +
+	"\rWatchVehicles\x12\x1d.vehicletracker.VehicleFilter\x1a\x1d.vehicletracker.VehicleUpdate0\x01B(Z&github.com/cad/vehicle-tracker-api/rpcb\x06proto3"
+
+var (
+	file_tracker_proto_rawDescOnce sync.Once
+	file_tracker_proto_rawDescData []byte
+)
+
+func file_tracker_proto_rawDescGZIP() []byte {
+	file_tracker_proto_rawDescOnce.Do(func() {
+		file_tracker_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)))
+	})
+	return file_tracker_proto_rawDescData
+}
+
+var file_tracker_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_tracker_proto_goTypes = []any{
+	(*VehicleFilter)(nil),         // 0: vehicletracker.VehicleFilter
+	(*Vehicle)(nil),               // 1: vehicletracker.Vehicle
+	(*Vehicles)(nil),              // 2: vehicletracker.Vehicles
+	(*GetVehicleRequest)(nil),     // 3: vehicletracker.GetVehicleRequest
+	(*Group)(nil),                 // 4: vehicletracker.Group
+	(*Groups)(nil),                // 5: vehicletracker.Groups
+	(*ListGroupsRequest)(nil),     // 6: vehicletracker.ListGroupsRequest
+	(*GetGroupRequest)(nil),       // 7: vehicletracker.GetGroupRequest
+	(*Agent)(nil),                 // 8: vehicletracker.Agent
+	(*Agents)(nil),                // 9: vehicletracker.Agents
+	(*ListAgentsRequest)(nil),     // 10: vehicletracker.ListAgentsRequest
+	(*GetAgentRequest)(nil),       // 11: vehicletracker.GetAgentRequest
+	(*AgentPosition)(nil),         // 12: vehicletracker.AgentPosition
+	(*SyncAgentSummary)(nil),      // 13: vehicletracker.SyncAgentSummary
+	(*VehicleRemoval)(nil),        // 14: vehicletracker.VehicleRemoval
+	(*VehicleUpdate)(nil),         // 15: vehicletracker.VehicleUpdate
+	(*timestamppb.Timestamp)(nil), // 16: google.protobuf.Timestamp
+}
+var file_tracker_proto_depIdxs = []int32{
+	8,  // 0: vehicletracker.Vehicle.agent:type_name -> vehicletracker.Agent
+	4,  // 1: vehicletracker.Vehicle.groups:type_name -> vehicletracker.Group
+	16, // 2: vehicletracker.Vehicle.updated_at:type_name -> google.protobuf.Timestamp
+	1,  // 3: vehicletracker.Vehicles.vehicles:type_name -> vehicletracker.Vehicle
+	4,  // 4: vehicletracker.Groups.groups:type_name -> vehicletracker.Group
+	16, // 5: vehicletracker.Agent.updated_at:type_name -> google.protobuf.Timestamp
+	8,  // 6: vehicletracker.Agents.agents:type_name -> vehicletracker.Agent
+	1,  // 7: vehicletracker.VehicleUpdate.vehicle:type_name -> vehicletracker.Vehicle
+	14, // 8: vehicletracker.VehicleUpdate.removal:type_name -> vehicletracker.VehicleRemoval
+	0,  // 9: vehicletracker.Tracker.ListVehicles:input_type -> vehicletracker.VehicleFilter
+	3,  // 10: vehicletracker.Tracker.GetVehicle:input_type -> vehicletracker.GetVehicleRequest
+	6,  // 11: vehicletracker.Tracker.ListGroups:input_type -> vehicletracker.ListGroupsRequest
+	7,  // 12: vehicletracker.Tracker.GetGroup:input_type -> vehicletracker.GetGroupRequest
+	10, // 13: vehicletracker.Tracker.ListAgents:input_type -> vehicletracker.ListAgentsRequest
+	11, // 14: vehicletracker.Tracker.GetAgent:input_type -> vehicletracker.GetAgentRequest
+	12, // 15: vehicletracker.Tracker.SyncAgent:input_type -> vehicletracker.AgentPosition
+	0,  // 16: vehicletracker.Tracker.WatchVehicles:input_type -> vehicletracker.VehicleFilter
+	2,  // 17: vehicletracker.Tracker.ListVehicles:output_type -> vehicletracker.Vehicles
+	1,  // 18: vehicletracker.Tracker.GetVehicle:output_type -> vehicletracker.Vehicle
+	5,  // 19: vehicletracker.Tracker.ListGroups:output_type -> vehicletracker.Groups
+	4,  // 20: vehicletracker.Tracker.GetGroup:output_type -> vehicletracker.Group
+	9,  // 21: vehicletracker.Tracker.ListAgents:output_type -> vehicletracker.Agents
+	8,  // 22: vehicletracker.Tracker.GetAgent:output_type -> vehicletracker.Agent
+	13, // 23: vehicletracker.Tracker.SyncAgent:output_type -> vehicletracker.SyncAgentSummary
+	15, // 24: vehicletracker.Tracker.WatchVehicles:output_type -> vehicletracker.VehicleUpdate
+	17, // [17:25] is the sub-list for method output_type
+	9,  // [9:17] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_tracker_proto_init() }
+func file_tracker_proto_init() {
+	if File_tracker_proto != nil {
+		return
+	}
+	file_tracker_proto_msgTypes[15].OneofWrappers = []any{
+		(*VehicleUpdate_Vehicle)(nil),
+		(*VehicleUpdate_Removal)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tracker_proto_rawDesc), len(file_tracker_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   16,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tracker_proto_goTypes,
+		DependencyIndexes: file_tracker_proto_depIdxs,
+		MessageInfos:      file_tracker_proto_msgTypes,
+	}.Build()
+	File_tracker_proto = out.File
+	file_tracker_proto_goTypes = nil
+	file_tracker_proto_depIdxs = nil
+}
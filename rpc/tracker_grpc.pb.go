@@ -0,0 +1,414 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: tracker.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Tracker_ListVehicles_FullMethodName  = "/vehicletracker.Tracker/ListVehicles"
+	Tracker_GetVehicle_FullMethodName    = "/vehicletracker.Tracker/GetVehicle"
+	Tracker_ListGroups_FullMethodName    = "/vehicletracker.Tracker/ListGroups"
+	Tracker_GetGroup_FullMethodName      = "/vehicletracker.Tracker/GetGroup"
+	Tracker_ListAgents_FullMethodName    = "/vehicletracker.Tracker/ListAgents"
+	Tracker_GetAgent_FullMethodName      = "/vehicletracker.Tracker/GetAgent"
+	Tracker_SyncAgent_FullMethodName     = "/vehicletracker.Tracker/SyncAgent"
+	Tracker_WatchVehicles_FullMethodName = "/vehicletracker.Tracker/WatchVehicles"
+)
+
+// TrackerClient is the client API for Tracker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Tracker is the gRPC API of the vehicle tracker, the equivalent of the
+// REST and WebSocket endpoints for backend services.
+type TrackerClient interface {
+	// ListVehicles returns the vehicles passing the filter.
+	ListVehicles(ctx context.Context, in *VehicleFilter, opts ...grpc.CallOption) (*Vehicles, error)
+	GetVehicle(ctx context.Context, in *GetVehicleRequest, opts ...grpc.CallOption) (*Vehicle, error)
+	ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*Groups, error)
+	GetGroup(ctx context.Context, in *GetGroupRequest, opts ...grpc.CallOption) (*Group, error)
+	// ListAgents returns the agents, only those in the state unless it's
+	// empty.
+	ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*Agents, error)
+	GetAgent(ctx context.Context, in *GetAgentRequest, opts ...grpc.CallOption) (*Agent, error)
+	// SyncAgent ingests the positions sent by agents, as the sync endpoint
+	// does. Positions that can't be recorded are counted as rejected and
+	// don't end the stream.
+	SyncAgent(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AgentPosition, SyncAgentSummary], error)
+	// WatchVehicles streams the vehicles passing the filter, as
+	// FilterVehiclesWS does: the matching vehicles first, then each update,
+	// and a removal when a vehicle sent stops matching or is deleted.
+	//
+	// Callers authenticate with the "authorization: Bearer <token>"
+	// metadata, which restricts the vehicles to those the user may see.
+	WatchVehicles(ctx context.Context, in *VehicleFilter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[VehicleUpdate], error)
+}
+
+type trackerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrackerClient(cc grpc.ClientConnInterface) TrackerClient {
+	return &trackerClient{cc}
+}
+
+func (c *trackerClient) ListVehicles(ctx context.Context, in *VehicleFilter, opts ...grpc.CallOption) (*Vehicles, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Vehicles)
+	err := c.cc.Invoke(ctx, Tracker_ListVehicles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) GetVehicle(ctx context.Context, in *GetVehicleRequest, opts ...grpc.CallOption) (*Vehicle, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Vehicle)
+	err := c.cc.Invoke(ctx, Tracker_GetVehicle_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) ListGroups(ctx context.Context, in *ListGroupsRequest, opts ...grpc.CallOption) (*Groups, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Groups)
+	err := c.cc.Invoke(ctx, Tracker_ListGroups_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) GetGroup(ctx context.Context, in *GetGroupRequest, opts ...grpc.CallOption) (*Group, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Group)
+	err := c.cc.Invoke(ctx, Tracker_GetGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) ListAgents(ctx context.Context, in *ListAgentsRequest, opts ...grpc.CallOption) (*Agents, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Agents)
+	err := c.cc.Invoke(ctx, Tracker_ListAgents_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) GetAgent(ctx context.Context, in *GetAgentRequest, opts ...grpc.CallOption) (*Agent, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Agent)
+	err := c.cc.Invoke(ctx, Tracker_GetAgent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) SyncAgent(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[AgentPosition, SyncAgentSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Tracker_ServiceDesc.Streams[0], Tracker_SyncAgent_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[AgentPosition, SyncAgentSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Tracker_SyncAgentClient = grpc.ClientStreamingClient[AgentPosition, SyncAgentSummary]
+
+func (c *trackerClient) WatchVehicles(ctx context.Context, in *VehicleFilter, opts ...grpc.CallOption) (grpc.ServerStreamingClient[VehicleUpdate], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Tracker_ServiceDesc.Streams[1], Tracker_WatchVehicles_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VehicleFilter, VehicleUpdate]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Tracker_WatchVehiclesClient = grpc.ServerStreamingClient[VehicleUpdate]
+
+// TrackerServer is the server API for Tracker service.
+// All implementations must embed UnimplementedTrackerServer
+// for forward compatibility.
+//
+// Tracker is the gRPC API of the vehicle tracker, the equivalent of the
+// REST and WebSocket endpoints for backend services.
+type TrackerServer interface {
+	// ListVehicles returns the vehicles passing the filter.
+	ListVehicles(context.Context, *VehicleFilter) (*Vehicles, error)
+	GetVehicle(context.Context, *GetVehicleRequest) (*Vehicle, error)
+	ListGroups(context.Context, *ListGroupsRequest) (*Groups, error)
+	GetGroup(context.Context, *GetGroupRequest) (*Group, error)
+	// ListAgents returns the agents, only those in the state unless it's
+	// empty.
+	ListAgents(context.Context, *ListAgentsRequest) (*Agents, error)
+	GetAgent(context.Context, *GetAgentRequest) (*Agent, error)
+	// SyncAgent ingests the positions sent by agents, as the sync endpoint
+	// does. Positions that can't be recorded are counted as rejected and
+	// don't end the stream.
+	SyncAgent(grpc.ClientStreamingServer[AgentPosition, SyncAgentSummary]) error
+	// WatchVehicles streams the vehicles passing the filter, as
+	// FilterVehiclesWS does: the matching vehicles first, then each update,
+	// and a removal when a vehicle sent stops matching or is deleted.
+	//
+	// Callers authenticate with the "authorization: Bearer <token>"
+	// metadata, which restricts the vehicles to those the user may see.
+	WatchVehicles(*VehicleFilter, grpc.ServerStreamingServer[VehicleUpdate]) error
+	mustEmbedUnimplementedTrackerServer()
+}
+
+// UnimplementedTrackerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTrackerServer struct{}
+
+func (UnimplementedTrackerServer) ListVehicles(context.Context, *VehicleFilter) (*Vehicles, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListVehicles not implemented")
+}
+func (UnimplementedTrackerServer) GetVehicle(context.Context, *GetVehicleRequest) (*Vehicle, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetVehicle not implemented")
+}
+func (UnimplementedTrackerServer) ListGroups(context.Context, *ListGroupsRequest) (*Groups, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListGroups not implemented")
+}
+func (UnimplementedTrackerServer) GetGroup(context.Context, *GetGroupRequest) (*Group, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetGroup not implemented")
+}
+func (UnimplementedTrackerServer) ListAgents(context.Context, *ListAgentsRequest) (*Agents, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAgents not implemented")
+}
+func (UnimplementedTrackerServer) GetAgent(context.Context, *GetAgentRequest) (*Agent, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAgent not implemented")
+}
+func (UnimplementedTrackerServer) SyncAgent(grpc.ClientStreamingServer[AgentPosition, SyncAgentSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method SyncAgent not implemented")
+}
+func (UnimplementedTrackerServer) WatchVehicles(*VehicleFilter, grpc.ServerStreamingServer[VehicleUpdate]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchVehicles not implemented")
+}
+func (UnimplementedTrackerServer) mustEmbedUnimplementedTrackerServer() {}
+func (UnimplementedTrackerServer) testEmbeddedByValue()                 {}
+
+// UnsafeTrackerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrackerServer will
+// result in compilation errors.
+type UnsafeTrackerServer interface {
+	mustEmbedUnimplementedTrackerServer()
+}
+
+func RegisterTrackerServer(s grpc.ServiceRegistrar, srv TrackerServer) {
+	// If the following call pancis, it indicates UnimplementedTrackerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Tracker_ServiceDesc, srv)
+}
+
+func _Tracker_ListVehicles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VehicleFilter)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).ListVehicles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_ListVehicles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).ListVehicles(ctx, req.(*VehicleFilter))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_GetVehicle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetVehicleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).GetVehicle(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_GetVehicle_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).GetVehicle(ctx, req.(*GetVehicleRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_ListGroups_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListGroupsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).ListGroups(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_ListGroups_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).ListGroups(ctx, req.(*ListGroupsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_GetGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).GetGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_GetGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).GetGroup(ctx, req.(*GetGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_ListAgents_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAgentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).ListAgents(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_ListAgents_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).ListAgents(ctx, req.(*ListAgentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_GetAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).GetAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_GetAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).GetAgent(ctx, req.(*GetAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_SyncAgent_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TrackerServer).SyncAgent(&grpc.GenericServerStream[AgentPosition, SyncAgentSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Tracker_SyncAgentServer = grpc.ClientStreamingServer[AgentPosition, SyncAgentSummary]
+
+func _Tracker_WatchVehicles_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VehicleFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TrackerServer).WatchVehicles(m, &grpc.GenericServerStream[VehicleFilter, VehicleUpdate]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Tracker_WatchVehiclesServer = grpc.ServerStreamingServer[VehicleUpdate]
+
+// Tracker_ServiceDesc is the grpc.ServiceDesc for Tracker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Tracker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "vehicletracker.Tracker",
+	HandlerType: (*TrackerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListVehicles",
+			Handler:    _Tracker_ListVehicles_Handler,
+		},
+		{
+			MethodName: "GetVehicle",
+			Handler:    _Tracker_GetVehicle_Handler,
+		},
+		{
+			MethodName: "ListGroups",
+			Handler:    _Tracker_ListGroups_Handler,
+		},
+		{
+			MethodName: "GetGroup",
+			Handler:    _Tracker_GetGroup_Handler,
+		},
+		{
+			MethodName: "ListAgents",
+			Handler:    _Tracker_ListAgents_Handler,
+		},
+		{
+			MethodName: "GetAgent",
+			Handler:    _Tracker_GetAgent_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SyncAgent",
+			Handler:       _Tracker_SyncAgent_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchVehicles",
+			Handler:       _Tracker_WatchVehicles_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "tracker.proto",
+}
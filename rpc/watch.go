@@ -0,0 +1,156 @@
+package rpc
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// watch sends the vehicle updates matching a filter to a caller.
+type watch struct {
+	stream Tracker_WatchVehiclesServer
+
+	filter      repository.VehicleFilter
+	restriction repository.VehicleFilter
+
+	// sessionID is the sign-in of the caller, zero for anonymous ones.
+	sessionID uint
+
+	// known holds the plate ids of the vehicles sent to the caller.
+	known map[string]bool
+
+	// events queues the vehicle events, overflow is closed when one
+	// couldn't be queued.
+	events       chan *event.Event
+	overflow     chan struct{}
+	overflowOnce sync.Once
+}
+
+// WatchVehicles authorizes the caller to read, as the other calls: it
+// can be anonymous when the server allows anonymous reads. Callers that
+// don't keep up with the updates are disconnected, and so are those whose
+// session is signed out or expires.
+func (s *Server) WatchVehicles(filter *VehicleFilter, stream Tracker_WatchVehiclesServer) error {
+	user, session, err := authorizeSession(stream.Context(), repository.PERMISSION_READ)
+	if err != nil {
+		return err
+	}
+	w := &watch{
+		stream:    stream,
+		filter:    filter.filter(),
+		sessionID: session.ID,
+		known:     map[string]bool{},
+		events:    make(chan *event.Event, config.C.WebSocket.QueueSize),
+		overflow:  make(chan struct{}),
+	}
+	if user != nil {
+		w.restriction = user.VehicleFilter()
+	}
+
+	var checks <-chan time.Time
+	if interval := config.C.Session.StreamCheck; w.sessionID != 0 && interval > 0 {
+		ticker := time.NewTicker(time.Duration(interval * float64(time.Second)))
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+
+	// Subscribed before the snapshot so that no update is missed.
+	name := "grpc"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		name += " " + p.Addr.String()
+	}
+	kinds := []string{repository.NEW_AGENT, repository.VEHICLE_UPDATE, repository.VEHICLE_DELETE}
+	subscription := event.Subscribe(kinds, event.Options{Name: name}, w.queue)
+	defer subscription.Cancel()
+
+	if err := w.snapshot(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case e := <-w.events:
+			if err := w.dispatch(e); err != nil {
+				return err
+			}
+		case <-w.overflow:
+			log.Println("[GRPC] Slow consumer. Disconnecting.")
+			return status.Error(codes.ResourceExhausted, "slow consumer")
+		case <-checks:
+			if err := w.checkSession(); err != nil {
+				log.Println("[GRPC] Session ended. Disconnecting.")
+				return err
+			}
+		case <-s.stopping:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// checkSession authenticates the session of the caller again, and checks
+// that its user may still read.
+func (w *watch) checkSession() error {
+	user, _, err := repository.AuthenticateSession(w.sessionID)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "Not Authorized")
+	}
+	return permits(&user, repository.PERMISSION_READ)
+}
+
+func (w *watch) queue(e *event.Event) {
+	select {
+	case w.events <- e:
+	default:
+		w.overflowOnce.Do(func() { close(w.overflow) })
+	}
+}
+
+// snapshot sends every vehicle matching.
+func (w *watch) snapshot() error {
+	for _, vehicle := range repository.FilterVehiclesBy(w.filter) {
+		if !w.restriction.Match(vehicle) {
+			continue
+		}
+		w.known[vehicle.PlateID] = true
+		if err := w.stream.Send(&VehicleUpdate{Update: &VehicleUpdate_Vehicle{Vehicle: vehicleMessage(vehicle)}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dispatch sends the vehicle of a vehicle event if it matches, and a
+// removal if it no longer does.
+func (w *watch) dispatch(e *event.Event) error {
+	var vehicle repository.Vehicle
+	switch payload := e.Payload.(type) {
+	case repository.Agent:
+		var err error
+		if vehicle, err = repository.GetVehicleByAgentUUID(payload.UUID); err != nil {
+			return nil
+		}
+	case repository.Vehicle:
+		vehicle = payload
+	default:
+		log.Println("[GRPC] Unknown payload. Ignoring.", "Event", e.Kind)
+		return nil
+	}
+
+	if e.Kind != repository.VEHICLE_DELETE && w.restriction.Match(vehicle) && w.filter.Match(vehicle) {
+		w.known[vehicle.PlateID] = true
+		return w.stream.Send(&VehicleUpdate{Update: &VehicleUpdate_Vehicle{Vehicle: vehicleMessage(vehicle)}})
+	}
+	if w.known[vehicle.PlateID] {
+		delete(w.known, vehicle.PlateID)
+		return w.stream.Send(&VehicleUpdate{Update: &VehicleUpdate_Removal{Removal: &VehicleRemoval{PlateId: vehicle.PlateID}}})
+	}
+	return nil
+}
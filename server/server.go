@@ -2,13 +2,22 @@ package server
 
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 	"github.com/cad/vehicle-tracker-api/endpoints"
 	"github.com/cad/vehicle-tracker-api/config"
 	"github.com/cad/vehicle-tracker-api/repository"
 	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/geocode"
+	"github.com/cad/vehicle-tracker-api/webhook"
+	"github.com/cad/vehicle-tracker-api/broker"
+	"github.com/cad/vehicle-tracker-api/rpc"
 	"fmt"
+	"net"
 	"os"
 	"github.com/gorilla/handlers"
 )
@@ -31,11 +40,76 @@ func ExecuteServer(configPath string) {
 
 	repository.ConnectDB(config.C.DB.Type , config.C.DB.URL)
 
+	if path := config.C.Geocoder.Gazetteer; path != "" {
+		gazetteer, err := geocode.LoadGazetteer(path, config.C.Geocoder.MaxDistance)
+		if err != nil {
+			fmt.Printf("Error: %s loading gazetteer: %s\n", path, err)
+			os.Exit(1)
+		}
+		geocode.Default = geocode.NewCache(gazetteer, config.C.Geocoder.CacheSize)
+	}
+
+	if config.C.Broker.Type == config.BROKER_POSTGRES {
+		url := config.C.Broker.URL
+		if url == "" {
+			url = config.C.DB.URL
+		}
+		b, err := broker.NewPostgres(url, config.C.Broker.Channel)
+		if err == nil {
+			err = event.SetBroker(b)
+		}
+		if err != nil {
+			fmt.Printf("Error: connecting to the event broker: %s\n", err)
+			os.Exit(1)
+		}
+		defer b.Close()
+	}
+
 	router := GetServer()
 	router = handlers.LoggingHandler(os.Stdout, router)
 	fmt.Println("API server version", config.VERSION, "is listening on port", config.C.Server.Port)
 	event.Run()
-	log.Fatal(http.ListenAndServe(config.C.Server.Port, router))
-	defer event.Shutdown()
-	defer repository.CloseDB()
+	webhook.Start()
+
+	var grpcServer *rpc.Server
+	if config.C.GRPC.Enabled {
+		grpcServer = rpc.NewServer()
+		if port := config.C.GRPC.Port; port != "" && port != config.C.Server.Port {
+			listener, err := net.Listen("tcp", port)
+			if err != nil {
+				fmt.Printf("Error: %s listening for gRPC: %s\n", port, err)
+				os.Exit(1)
+			}
+			fmt.Println("gRPC server is listening on port", port)
+			go grpcServer.Serve(listener)
+		} else {
+			router = grpcServer.Handler(router)
+		}
+	}
+	server := &http.Server{Addr: config.C.Server.Port, Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Stop on interrupt, once the requests in progress and the events
+	// they emitted are handled.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	fmt.Println("API server is shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Can't shut down the server gracefully:", err)
+	}
+	if grpcServer != nil {
+		grpcServer.Shutdown(ctx)
+	}
+	if err := event.Shutdown(ctx); err != nil {
+		log.Println("Can't handle the queued events:", err)
+	}
+	webhook.Stop()
+	repository.CloseDB()
 }
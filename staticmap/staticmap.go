@@ -0,0 +1,217 @@
+// Package staticmap draws points, lines and polygons on a plain web
+// mercator background, without any tile server.
+package staticmap
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/cad/vehicle-tracker-api/geo"
+)
+
+// MaxZoom is the deepest zoom level a map is fitted to.
+const MaxZoom = 17
+
+var (
+	Background = color.RGBA{242, 239, 233, 255}
+	Graticule  = color.RGBA{222, 218, 210, 255}
+)
+
+// Map is an image showing a region of the web mercator plane.
+type Map struct {
+	img  *image.RGBA
+	zoom float64
+
+	// Position of the top left corner of the image on the plane, in pixels
+	// at zoom.
+	left, top float64
+}
+
+// New returns a width by height map fitting bounds with padding pixels
+// around it, with its background and graticule drawn.
+func New(width, height, padding int, bounds geo.BBox) *Map {
+	x0, y0 := geo.Project(geo.Point{Lat: bounds.MaxLat, Lon: bounds.MinLon}, 0)
+	x1, y1 := geo.Project(geo.Point{Lat: bounds.MinLat, Lon: bounds.MaxLon}, 0)
+
+	zoom := float64(MaxZoom)
+	availableX := float64(width - 2*padding)
+	availableY := float64(height - 2*padding)
+	if dx := x1 - x0; dx > 0 && availableX > 0 {
+		zoom = math.Min(zoom, math.Log2(availableX/dx))
+	}
+	if dy := y1 - y0; dy > 0 && availableY > 0 {
+		zoom = math.Min(zoom, math.Log2(availableY/dy))
+	}
+	zoom = math.Max(zoom, 0)
+
+	scale := math.Pow(2, zoom)
+	m := &Map{
+		img:  image.NewRGBA(image.Rect(0, 0, width, height)),
+		zoom: zoom,
+		left: (x0+x1)/2*scale - float64(width)/2,
+		top:  (y0+y1)/2*scale - float64(height)/2,
+	}
+	m.fill(Background)
+	m.graticule()
+	return m
+}
+
+// Image returns the map drawn so far.
+func (m *Map) Image() image.Image {
+	return m.img
+}
+
+// Pixel returns the position of p on the image.
+func (m *Map) Pixel(p geo.Point) (float64, float64) {
+	x, y := geo.Project(p, m.zoom)
+	return x - m.left, y - m.top
+}
+
+func (m *Map) fill(c color.RGBA) {
+	b := m.img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			m.img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// graticule draws meridians and parallels at a round step in degrees
+// suiting the scale of the map.
+func (m *Map) graticule() {
+	b := m.img.Bounds()
+	topLeft := geo.Unproject(m.left, m.top, m.zoom)
+	bottomRight := geo.Unproject(m.left+float64(b.Dx()), m.top+float64(b.Dy()), m.zoom)
+
+	span := math.Max(bottomRight.Lon-topLeft.Lon, topLeft.Lat-bottomRight.Lat)
+	step := math.Pow(10, math.Floor(math.Log10(span)))
+	if span/step < 3 {
+		step /= 2
+	}
+	if step <= 0 || math.IsInf(step, 0) || math.IsNaN(step) {
+		return
+	}
+
+	for lon := math.Ceil(topLeft.Lon/step) * step; lon <= bottomRight.Lon; lon += step {
+		x, _ := m.Pixel(geo.Point{Lon: lon})
+		m.segment(x, 0, x, float64(b.Dy()), 1, Graticule)
+	}
+	for lat := math.Ceil(bottomRight.Lat/step) * step; lat <= topLeft.Lat; lat += step {
+		_, y := m.Pixel(geo.Point{Lat: lat})
+		m.segment(0, y, float64(b.Dx()), y, 1, Graticule)
+	}
+}
+
+// blend paints c over the pixel at x, y with the given coverage.
+func (m *Map) blend(x, y int, c color.RGBA, coverage float64) {
+	if !(image.Point{X: x, Y: y}).In(m.img.Bounds()) || coverage <= 0 {
+		return
+	}
+	a := float64(c.A) / 255 * math.Min(coverage, 1)
+	dst := m.img.RGBAAt(x, y)
+	mix := func(src, dst uint8) uint8 {
+		return uint8(float64(src)*a + float64(dst)*(1-a) + 0.5)
+	}
+	m.img.SetRGBA(x, y, color.RGBA{
+		R: mix(c.R, dst.R),
+		G: mix(c.G, dst.G),
+		B: mix(c.B, dst.B),
+		A: uint8(math.Min(255, float64(dst.A)+a*float64(255-dst.A)+0.5)),
+	})
+}
+
+// segment draws an antialiased segment of the given width in pixels.
+func (m *Map) segment(x0, y0, x1, y1, width float64, c color.RGBA) {
+	r := width / 2
+	minX := int(math.Floor(math.Min(x0, x1) - r - 1))
+	maxX := int(math.Ceil(math.Max(x0, x1) + r + 1))
+	minY := int(math.Floor(math.Min(y0, y1) - r - 1))
+	maxY := int(math.Ceil(math.Max(y0, y1) + r + 1))
+	b := m.img.Bounds()
+	minX, maxX = clamp(minX, b.Min.X, b.Max.X), clamp(maxX, b.Min.X, b.Max.X)
+	minY, maxY = clamp(minY, b.Min.Y, b.Max.Y), clamp(maxY, b.Min.Y, b.Max.Y)
+
+	dx, dy := x1-x0, y1-y0
+	length2 := dx*dx + dy*dy
+	for y := minY; y < maxY; y++ {
+		for x := minX; x < maxX; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			t := 0.0
+			if length2 > 0 {
+				t = math.Max(0, math.Min(1, ((px-x0)*dx+(py-y0)*dy)/length2))
+			}
+			d := math.Hypot(px-(x0+t*dx), py-(y0+t*dy))
+			m.blend(x, y, c, r+0.5-d)
+		}
+	}
+}
+
+func clamp(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Line draws a polyline through points.
+func (m *Map) Line(points []geo.Point, width float64, c color.RGBA) {
+	for i := 1; i < len(points); i++ {
+		x0, y0 := m.Pixel(points[i-1])
+		x1, y1 := m.Pixel(points[i])
+		m.segment(x0, y0, x1, y1, width, c)
+	}
+}
+
+// Polygon fills the polygon with fill and outlines it with stroke.
+func (m *Map) Polygon(polygon []geo.Point, fill, stroke color.RGBA) {
+	if len(polygon) < 3 {
+		return
+	}
+	xs := make([]float64, len(polygon))
+	ys := make([]float64, len(polygon))
+	for i, p := range polygon {
+		xs[i], ys[i] = m.Pixel(p)
+	}
+
+	minX, minY, maxX, maxY := xs[0], ys[0], xs[0], ys[0]
+	for i := range xs {
+		minX, maxX = math.Min(minX, xs[i]), math.Max(maxX, xs[i])
+		minY, maxY = math.Min(minY, ys[i]), math.Max(maxY, ys[i])
+	}
+	b := m.img.Bounds()
+	for y := clamp(int(minY), b.Min.Y, b.Max.Y); y < clamp(int(maxY)+1, b.Min.Y, b.Max.Y); y++ {
+		py := float64(y) + 0.5
+		for x := clamp(int(minX), b.Min.X, b.Max.X); x < clamp(int(maxX)+1, b.Min.X, b.Max.X); x++ {
+			px := float64(x) + 0.5
+			inside := false
+			for i, j := 0, len(xs)-1; i < len(xs); j, i = i, i+1 {
+				if (ys[i] > py) != (ys[j] > py) &&
+					px < (xs[j]-xs[i])*(py-ys[i])/(ys[j]-ys[i])+xs[i] {
+					inside = !inside
+				}
+			}
+			if inside {
+				m.blend(x, y, fill, 1)
+			}
+		}
+	}
+
+	closed := append(append([]geo.Point{}, polygon...), polygon[0])
+	m.Line(closed, 2, stroke)
+}
+
+// Marker draws a disc of the given radius in pixels centered on p.
+func (m *Map) Marker(p geo.Point, radius float64, fill, stroke color.RGBA) {
+	cx, cy := m.Pixel(p)
+	for y := int(cy - radius - 2); y <= int(cy+radius+2); y++ {
+		for x := int(cx - radius - 2); x <= int(cx+radius+2); x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			m.blend(x, y, stroke, radius+0.5-d)
+			m.blend(x, y, fill, radius-1.5+0.5-d)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+// Package webhook delivers events to the registered webhooks.
+//
+// Every event of the log matching a webhook is stored as a delivery, then
+// posted to the URL of the webhook as a JSON Payload with the headers:
+//
+//   X-Webhook-Event:     the kind of the event, e.g. "GEOFENCE-ENTER"
+//   X-Webhook-Delivery:  the id of the delivery, the same across retries
+//   X-Webhook-Signature: "sha256=" and the hex HMAC-SHA256 of the body,
+//                        keyed with the secret of the webhook
+//
+// Deliveries not answered with a 2xx status are retried with exponential
+// backoff, and are dead letters after the last attempt. With several
+// instances of the API, each event is recorded and each delivery attempted
+// by one of them.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cad/vehicle-tracker-api/config"
+	"github.com/cad/vehicle-tracker-api/event"
+	"github.com/cad/vehicle-tracker-api/repository"
+)
+
+// BATCH_SIZE is the most events recorded, and deliveries attempted, in a
+// pass.
+const BATCH_SIZE = 100
+
+// Payload is the body posted to webhooks.
+type Payload struct {
+	Kind string      `json:"kind"`
+	Key  string      `json:"key"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// Sign returns the signature of body sent in X-Webhook-Signature.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns how long to wait after the failed attempt of a
+// delivery before the next one.
+func Backoff(attempt int) time.Duration {
+	delay := config.C.Webhook.RetryBase
+	for i := 1; i < attempt && delay < config.C.Webhook.RetryMax; i++ {
+		delay *= 2
+	}
+	if delay > config.C.Webhook.RetryMax {
+		delay = config.C.Webhook.RetryMax
+	}
+	return seconds(delay)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Dispatcher records the deliveries of the events of the log and
+// attempts them. It follows the log from a cursor persisted along with
+// the deliveries, so that the events logged while no dispatcher was
+// running are delivered too.
+type Dispatcher struct {
+	client       http.Client
+	subscription *event.Subscription
+
+	// wake is signalled when events were logged or deliveries may be due
+	// before the next poll.
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	lock    sync.Mutex
+	running *Dispatcher
+)
+
+// Start delivers the events logged since the last recorded, until Stop.
+func Start() {
+	lock.Lock()
+	defer lock.Unlock()
+	if running != nil {
+		return
+	}
+	d := &Dispatcher{
+		client: http.Client{Timeout: seconds(config.C.Webhook.Timeout)},
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	d.subscription = event.Subscribe(repository.WEBHOOK_KINDS, event.Options{Name: "webhook"}, func(e *event.Event) {
+		d.signal()
+	})
+	go d.run()
+	running = d
+}
+
+// Stop stops delivering, once the attempts in progress are over. The
+// pending deliveries, and the events logged meanwhile, are attempted after
+// the next Start.
+func Stop() {
+	lock.Lock()
+	defer lock.Unlock()
+	if running == nil {
+		return
+	}
+	running.subscription.Cancel()
+	close(running.stop)
+	<-running.done
+	running = nil
+}
+
+// Wake has the due deliveries attempted now rather than on the next poll.
+func Wake() {
+	lock.Lock()
+	defer lock.Unlock()
+	if running != nil {
+		running.signal()
+	}
+}
+
+func (d *Dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// record queues the deliveries of the events logged since the last
+// recorded.
+func (d *Dispatcher) record() {
+	for {
+		recorded, err := repository.RecordWebhookDeliveries(BATCH_SIZE, deliveries)
+		if err != nil {
+			log.Println("[Webhook] Can't record the deliveries.", err)
+			return
+		}
+		if recorded < BATCH_SIZE {
+			return
+		}
+	}
+}
+
+// deliveries returns a delivery of the logged event to every webhook it
+// matches, among those registered before it.
+func deliveries(stored repository.StoredEvent) []repository.WebhookDelivery {
+	deliveries := make([]repository.WebhookDelivery, 0)
+	payload, err := event.Decode(stored.Kind, stored.Data)
+	if err != nil {
+		log.Println("[Webhook] Can't decode the event. Ignoring.", "Event", stored.ID, err)
+		return deliveries
+	}
+	e := &event.Event{Kind: stored.Kind, Key: stored.Key, Time: stored.CreatedAt.UTC(), Payload: payload}
+
+	var (
+		body     []byte
+		vehicle  *repository.Vehicle
+		resolved bool
+	)
+	for _, webhook := range repository.GetAllWebhooks() {
+		if stored.CreatedAt.Before(webhook.CreatedAt) {
+			continue
+		}
+		if webhook.FiltersVehicles() && !resolved {
+			vehicle, resolved = eventVehicle(e), true
+		}
+		if !webhook.Match(e.Kind, vehicle) {
+			continue
+		}
+		if body == nil {
+			body, err = json.Marshal(Payload{Kind: e.Kind, Key: e.Key, Time: e.Time, Data: stored.Data})
+			if err != nil {
+				log.Println("[Webhook] Can't encode the event. Ignoring.", "Event", stored.ID, err)
+				return deliveries
+			}
+		}
+		deliveries = append(deliveries, repository.WebhookDelivery{WebhookID: webhook.ID, Kind: e.Kind, Key: e.Key, Body: string(body)})
+	}
+	return deliveries
+}
+
+// eventVehicle returns the vehicle an event is about, nil if none.
+func eventVehicle(e *event.Event) *repository.Vehicle {
+	switch payload := e.Payload.(type) {
+	case repository.Agent:
+		vehicle, err := repository.GetVehicleByAgentUUID(payload.UUID)
+		if err != nil {
+			return nil
+		}
+		return &vehicle
+	case repository.Vehicle:
+		return &payload
+	case repository.Change:
+		// Deleted vehicles are only found in the change.
+		for _, state := range []interface{}{payload.After, payload.Before} {
+			if vehicle, ok := state.(repository.Vehicle); ok {
+				return &vehicle
+			}
+		}
+		return nil
+	}
+	// Alerts and geofence events are keyed by plate.
+	vehicle, err := repository.GetVehicleByPlateID(e.Key)
+	if err != nil {
+		return nil
+	}
+	return &vehicle
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	poll := time.NewTicker(seconds(config.C.Webhook.PollInterval))
+	defer poll.Stop()
+	for {
+		d.record()
+		d.deliverDue()
+		select {
+		case <-d.stop:
+			return
+		case <-poll.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue attempts the due deliveries, Workers at a time. Deliveries
+// are claimed before they're attempted, so that with several instances of
+// the API each is posted by one.
+func (d *Dispatcher) deliverDue() {
+	workers := config.C.Webhook.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	// An attempt takes Timeout at most, the lease leaves some slack.
+	lease := 2 * seconds(config.C.Webhook.Timeout)
+	for attempted := 0; attempted < BATCH_SIZE; {
+		deliveries := repository.ClaimWebhookDeliveries(time.Now().UTC(), lease, workers)
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func(delivery repository.WebhookDelivery) {
+				defer wg.Done()
+				d.deliver(delivery)
+			}(delivery)
+		}
+		wg.Wait()
+		if len(deliveries) < workers {
+			return
+		}
+		attempted += len(deliveries)
+	}
+}
+
+func (d *Dispatcher) deliver(delivery repository.WebhookDelivery) {
+	webhook, err := repository.GetWebhookByID(delivery.WebhookID)
+	if err != nil {
+		// Deleted since, along with its deliveries.
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = d.post(webhook, delivery)
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = repository.DELIVERY_DELIVERED
+	case delivery.Attempts >= config.C.Webhook.MaxAttempts:
+		delivery.Status = repository.DELIVERY_DEAD
+		delivery.LastError = err.Error()
+	default:
+		delivery.Status = repository.DELIVERY_PENDING
+		delivery.NextAttempt = time.Now().UTC().Add(Backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+	repository.SaveWebhookDelivery(&delivery)
+}
+
+// post posts the delivery, returning the status code of the answer if
+// any.
+func (d *Dispatcher) post(webhook repository.Webhook, delivery repository.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest("POST", webhook.URL, strings.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Kind)
+	req.Header.Set("X-Webhook-Delivery", fmt.Sprintf("%d", delivery.ID))
+	req.Header.Set("X-Webhook-Signature", Sign(webhook.Secret, []byte(delivery.Body)))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, nil
+}